@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/plan"
+)
+
+var planFile string
+
+func ApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply -f <plan.yaml>",
+		Short: "Run the restore described by a RestorePlan file",
+		Long: fmt.Sprintf(`Read a %s file (YAML or JSON, apiVersion %s), reject it when
+it has unknown fields or invalid values, then run the restore it describes exactly
+as the equivalent flags would. Use --dry-run to review the plan's Jobs first.`, plan.Kind, plan.APIVersion),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runApply()
+		},
+	}
+
+	cmd.Flags().StringVarP(&planFile, "filename", "f", "", "RestorePlan file to apply")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry run")
+	addReportFlags(cmd)
+
+	return cmd
+}
+
+func runApply() error {
+	if planFile == "" {
+		return fmt.Errorf("missing required flag to apply a restore plan: -f")
+	}
+
+	p, err := plan.Load(planFile)
+	if err == nil {
+		setPlanFlags(p.Spec)
+		if err = validateRestoreFlags(); err != nil {
+			err = fmt.Errorf("invalid restore plan %s: %w", planFile, err)
+		}
+	}
+	if err != nil {
+		// Still write the report asked for, of a restore that did not start.
+		report, reportErr := startReport(dryRun)
+		if reportErr != nil {
+			return reportErr
+		}
+		return report.finish("", err)
+	}
+
+	logger.Global.Info("📜 Applying restore plan '%s'", p.Metadata.Name)
+	return runDatabaseRestore()
+}
+
+// setPlanFlags sets the restore flags from the plan, so a plan and the
+// equivalent command line run the same restore.
+func setPlanFlags(spec plan.Spec) {
+	engineName = spec.Engine
+	backupName = spec.Backup.Name
+	backupSelector = spec.Backup.Selector
+	backupBefore = spec.Backup.Before
+
+	sourceContext = spec.Source.Context
+	targetContext = spec.Target.Context
+	namespace = spec.Target.Namespace
+	serviceName = spec.Target.ServiceName
+	chiName = spec.Target.CHI
+	chiCluster = spec.Target.CHICluster
+	databaseNames = spec.Target.Databases
+	databasesFile = ""
+
+	parallelism = 1
+	if spec.Parallelism > 0 {
+		parallelism = spec.Parallelism
+	}
+
+	secretRefs = nil
+	for _, ref := range spec.SecretRefs {
+		secretRefs = append(secretRefs, fmt.Sprintf("%s=%s:%s", ref.Env, ref.Secret, ref.Key))
+	}
+
+	secretEnvFrom = nil
+	for _, from := range spec.SecretEnvFrom {
+		value := from.Secret
+		if from.Prefix != "" {
+			value += ":" + from.Prefix
+		}
+		for _, env := range slices.Sorted(maps.Keys(from.Rename)) {
+			value += fmt.Sprintf(",%s=%s", env, from.Rename[env])
+		}
+		secretEnvFrom = append(secretEnvFrom, value)
+	}
+
+	restoreMode = spec.Mode()
+	physicalTool, statefulSet, recoveryTarget = "", "", ""
+	sourceCluster, targetCluster, repointService = "", "", false
+	clusterTimeout = engine.DefaultClusterTimeout
+	if spec.Physical != nil {
+		physicalTool = spec.Physical.Tool
+		statefulSet = spec.Physical.StatefulSet
+		recoveryTarget = spec.Physical.RecoveryTargetTime
+		if spec.Physical.Timeout != "" {
+			// Checked when the plan was loaded.
+			clusterTimeout, _ = time.ParseDuration(spec.Physical.Timeout)
+		}
+	}
+	if spec.Operator != nil {
+		sourceCluster = spec.Operator.Cluster
+		targetCluster = spec.Operator.TargetCluster
+		recoveryTarget = spec.Operator.RecoveryTargetTime
+		repointService = spec.Operator.RepointService
+		if spec.Operator.Timeout != "" {
+			// Checked when the plan was loaded.
+			clusterTimeout, _ = time.ParseDuration(spec.Operator.Timeout)
+		}
+	}
+
+	image = ""
+	jobOverrides = spec.JobOverrides
+	restoreHooks = spec.Hooks
+	notifyWebhooks = spec.Notifications.Webhooks
+	notifySlack = spec.Notifications.Slack
+	metricsGateway = spec.Metrics.Pushgateway
+	metricsDir = spec.Metrics.TextfileDir
+
+	maskingFile = spec.MaskingRules
+	preserveGrants = spec.PreserveGrants
+	verifyFile = spec.Verification.SQL
+	validate = spec.Verification.Validate
+	manifestFile = spec.Verification.Manifest
+	tolerance = engine.DefaultValidationTolerance
+	if spec.Verification.Tolerance != nil {
+		tolerance = *spec.Verification.Tolerance
+	}
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+)
+
+const testRestorePlan = `
+apiVersion: db-restore.wiremind.io/v1alpha1
+kind: RestorePlan
+metadata:
+  name: shop-refresh
+spec:
+  engine: mock
+  backup:
+    name: daily-2025-06-16
+  target:
+    namespace: preview
+    serviceName: clickhouse
+    databases: [shop]
+  secretRefs:
+    - env: CLICKHOUSE_PASSWORD
+      secret: clickhouse
+      key: password
+  secretEnvFrom:
+    - secret: backup-bucket
+      prefix: BACKUP_
+      rename:
+        AWS_ACCESS_KEY_ID: access-key
+  jobOverrides:
+    serviceAccountName: db-restore
+  hooks:
+    postRestore:
+      - name: notify
+        image: curlimages/curl
+        script: curl -X POST http://hooks.internal/restored
+  notifications:
+    slack: [https://hooks.slack.com/services/T000/B000/XXXX]
+  metrics:
+    pushgateway: http://pushgateway.monitoring:9091
+  preserveGrants: true
+`
+
+func writePlan(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "restore.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRunApply(t *testing.T) {
+	resetVars()
+	mock := &mockEngine{}
+	engine.RegisterEngine(mock)
+
+	planFile = writePlan(t, testRestorePlan)
+	exitCalled := false
+	osExit = func(int) { exitCalled = true }
+	defer func() { osExit = os.Exit }()
+
+	require.NoError(t, runApply())
+	assert.False(t, exitCalled)
+	assert.True(t, mock.restoreCalled)
+	assert.Equal(t, "daily-2025-06-16", mock.lastArgs.backup)
+	assert.Equal(t, "shop", mock.lastArgs.database)
+
+	opts := mock.lastArgs.opts
+	assert.Equal(t, "preview", opts.Namespace)
+	assert.Equal(t, "clickhouse", opts.ServiceName)
+	assert.True(t, opts.PreserveGrants)
+	assert.Equal(t, "db-restore", opts.JobOverrides.ServiceAccountName)
+	require.Len(t, opts.Hooks.PostRestore, 1)
+	assert.Equal(t, "notify", opts.Hooks.PostRestore[0].Name)
+	assert.Equal(t, []k8screds.SecretKeyRef{
+		{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: "clickhouse", Key: "password"},
+		{EnvVarName: "AWS_ACCESS_KEY_ID", SecretName: "backup-bucket", Key: "access-key"},
+	}, opts.SecretKeyRefs)
+	assert.Equal(t, []k8screds.SecretEnvFrom{{SecretName: "backup-bucket", Prefix: "BACKUP_"}}, opts.SecretEnvFrom)
+	assert.Equal(t, []string{"https://hooks.slack.com/services/T000/B000/XXXX"}, opts.Notifications.Slack)
+	assert.Equal(t, "http://pushgateway.monitoring:9091", opts.Metrics.Pushgateway)
+}
+
+func TestRunApply_InvalidPlan(t *testing.T) {
+	resetVars()
+
+	planFile = ""
+	assert.ErrorContains(t, runApply(), "-f")
+
+	planFile = writePlan(t, "apiVersion: db-restore.wiremind.io/v1alpha1\nkind: RestorePlan\nmetadata:\n  name: x\nspec:\n  engine: mock\n")
+	assert.ErrorContains(t, runApply(), "spec.backup: one of name, selector or before is required")
+}
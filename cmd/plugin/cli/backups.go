@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+)
+
+var (
+	listOutput   string
+	listMatch    string
+	listDatabase string
+	listSince    time.Duration
+)
+
+func ListBackupsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list-backups",
+		Short: "List the backups available to restore",
+		Long: `List the backups found in the engine's backup location, using the same
+credentials as a restore (environment variables or --secret-ref).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Keep stdout for the listing itself, progress goes to stderr.
+			logger.Global.SetOutput(cmd.ErrOrStderr())
+			return runListBackups(cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVar(&engineName, "engine", "", "Database engine (clickhouse, ...)")
+	cmd.Flags().StringVar(&serviceName, "service-name", "", "Kubernetes service name for DB")
+	cmd.Flags().StringVar(&chiName, "chi", "", "ClickHouse: ClickHouseInstallation to read the host and credentials from, instead of --service-name")
+	cmd.Flags().StringVar(&chiCluster, "chi-cluster", "", "ClickHouse: cluster of the --chi, when it defines several")
+	cmd.Flags().StringSliceVar(&secretRefs, "secret-ref", nil, "Secret reference in the format VAR=secretName:key (can be repeated)")
+	cmd.Flags().StringArrayVar(&secretEnvFrom, "secret-env-from", nil, secretEnvFromUsage)
+	cmd.Flags().StringVar(&image, "image", "", "Client image of the listing Job (defaults to the engine's)")
+	cmd.Flags().StringVar(&profileName, "profile", "", "Profile of the config file presetting these flags")
+	cmd.Flags().StringVarP(&listOutput, "output", "o", "table", "Output format (table, json)")
+	cmd.Flags().StringVar(&listMatch, "match", "", "Only show backups whose name matches this glob (e.g. 'daily-*')")
+	cmd.Flags().StringVar(&listDatabase, "database", "", "Only show backups containing this database")
+	cmd.Flags().DurationVar(&listSince, "since", 0, "Only show backups taken within this duration (e.g. 168h)")
+
+	return cmd
+}
+
+func runListBackups(out io.Writer) error {
+	if engineName == "" || (serviceName == "" && chiName == "") {
+		return fmt.Errorf("missing required flag(s) to list backups: --engine, --service-name (or --chi)")
+	}
+	if err := validateCHIFlags(); err != nil {
+		return err
+	}
+	if listOutput != "table" && listOutput != "json" {
+		return fmt.Errorf("unsupported output format %q (expected table or json)", listOutput)
+	}
+	if _, err := path.Match(listMatch, ""); err != nil {
+		return fmt.Errorf("invalid --match pattern %q: %w", listMatch, err)
+	}
+
+	eng, err := engine.GetEngine(engineName)
+	if err != nil {
+		return err
+	}
+	lister, ok := eng.(engine.BackupLister)
+	if !ok {
+		return fmt.Errorf("engine %q does not support listing backups", engineName)
+	}
+
+	parsedRefs, err := parseSecretRefs(secretRefs)
+	if err != nil {
+		return err
+	}
+	envFrom, renames, err := parseSecretEnvFrom(secretEnvFrom)
+	if err != nil {
+		return err
+	}
+	parsedRefs = append(parsedRefs, renames...)
+
+	backups, err := lister.ListBackups(KubernetesConfigFlags, engine.RestoreOptions{
+		Namespace:     resolveNamespace(),
+		ServiceName:   serviceName,
+		SecretKeyRefs: parsedRefs,
+		SecretEnvFrom: envFrom,
+		CHI:           chiName,
+		CHICluster:    chiCluster,
+		Image:         image,
+		JobOverrides:  jobOverrides,
+	})
+	if err != nil {
+		return err
+	}
+
+	backups = filterBackups(backups, listMatch, listDatabase, listSince, time.Now())
+
+	if listOutput == "json" {
+		return printBackupsJSON(out, backups)
+	}
+	return printBackupsTable(out, backups)
+}
+
+// filterBackups keeps the backups matching every filter that is set.
+func filterBackups(backups []engine.BackupInfo, match, database string, since time.Duration, now time.Time) []engine.BackupInfo {
+	filtered := []engine.BackupInfo{}
+	for _, b := range backups {
+		if match != "" {
+			if ok, _ := path.Match(match, b.Name); !ok {
+				continue
+			}
+		}
+		if database != "" && !slices.Contains(b.Databases, database) {
+			continue
+		}
+		if since > 0 && b.Timestamp.Before(now.Add(-since)) {
+			continue
+		}
+		filtered = append(filtered, b)
+	}
+	return filtered
+}
+
+func printBackupsJSON(out io.Writer, backups []engine.BackupInfo) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(backups)
+}
+
+func printBackupsTable(out io.Writer, backups []engine.BackupInfo) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tSIZE\tTIMESTAMP\tDATABASES")
+	for _, b := range backups {
+		timestamp := "-"
+		if !b.Timestamp.IsZero() {
+			timestamp = b.Timestamp.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Name, engine.FormatBytes(b.Size), timestamp, strings.Join(b.Databases, ","))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type mockListerEngine struct {
+	mockEngine
+	backups  []engine.BackupInfo
+	lastOpts engine.RestoreOptions
+	listings int
+}
+
+func (m *mockListerEngine) Name() string {
+	return "mock-lister"
+}
+
+func (m *mockListerEngine) ListBackups(_ *genericclioptions.ConfigFlags, opts engine.RestoreOptions) ([]engine.BackupInfo, error) {
+	m.lastOpts = opts
+	m.listings++
+	return m.backups, nil
+}
+
+func resetListVars() {
+	resetVars()
+	listOutput = "table"
+	listMatch = ""
+	listDatabase = ""
+	listSince = 0
+}
+
+var testBackups = []engine.BackupInfo{
+	{Name: "daily-2025-06-16", Size: 3 * 1024 * 1024, Timestamp: time.Date(2025, 6, 16, 2, 0, 0, 0, time.UTC), Databases: []string{"analytics", "events"}},
+	{Name: "daily-2025-06-15", Size: 2048, Timestamp: time.Date(2025, 6, 15, 2, 0, 0, 0, time.UTC), Databases: []string{"analytics"}},
+	{Name: "weekly-2025-06-08", Size: 512, Timestamp: time.Date(2025, 6, 8, 2, 0, 0, 0, time.UTC), Databases: []string{"events"}},
+}
+
+func TestFilterBackups(t *testing.T) {
+	now := time.Date(2025, 6, 16, 12, 0, 0, 0, time.UTC)
+
+	assert.Len(t, filterBackups(testBackups, "", "", 0, now), 3)
+	assert.Len(t, filterBackups(testBackups, "daily-*", "", 0, now), 2)
+	assert.Len(t, filterBackups(testBackups, "", "events", 0, now), 2)
+	assert.Len(t, filterBackups(testBackups, "", "", 24*time.Hour, now), 1)
+	assert.Empty(t, filterBackups(testBackups, "weekly-*", "analytics", 0, now))
+}
+
+func TestRunListBackups_Table(t *testing.T) {
+	resetListVars()
+	mock := &mockListerEngine{backups: testBackups}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-lister"
+	serviceName = "test-svc"
+	namespace = "test-ns"
+	listMatch = "daily-*"
+
+	var out bytes.Buffer
+	require.NoError(t, runListBackups(&out))
+
+	assert.Equal(t, "test-ns", mock.lastOpts.Namespace)
+	assert.Equal(t, "test-svc", mock.lastOpts.ServiceName)
+	assert.Contains(t, out.String(), "NAME")
+	assert.Contains(t, out.String(), "daily-2025-06-16   3.0 MiB   2025-06-16T02:00:00Z   analytics,events")
+	assert.NotContains(t, out.String(), "weekly-2025-06-08")
+}
+
+func TestRunListBackups_JSON(t *testing.T) {
+	resetListVars()
+	engine.RegisterEngine(&mockListerEngine{backups: testBackups})
+
+	engineName = "mock-lister"
+	serviceName = "test-svc"
+	namespace = "test-ns"
+	listOutput = "json"
+	listDatabase = "events"
+
+	var out bytes.Buffer
+	require.NoError(t, runListBackups(&out))
+
+	assert.Contains(t, out.String(), `"name": "weekly-2025-06-08"`)
+	assert.NotContains(t, out.String(), `"name": "daily-2025-06-15"`)
+}
+
+func TestRunListBackups_EngineWithoutLister(t *testing.T) {
+	resetListVars()
+	engine.RegisterEngine(&mockEngine{})
+
+	engineName = "mock"
+	serviceName = "test-svc"
+
+	err := runListBackups(&bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support listing backups")
+}
@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+)
+
+var (
+	cloneSourceNamespace  string
+	cloneSourceService    string
+	cloneSourceCHI        string
+	cloneSourceSecretRefs []string
+	cloneTargetDatabase   string
+)
+
+func CloneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clone",
+		Short: "Back up a live database and restore it into another namespace",
+		Long: `Take a fresh backup of the source database, then restore it into the target
+namespace and service, e.g. to refresh a preview environment from production.
+The backup lands in the engine's usual backup location, so it can be listed and
+restored again later.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runClone()
+		},
+	}
+
+	cmd.Flags().StringVar(&engineName, "engine", "", "Database engine (clickhouse, ...)")
+	cmd.Flags().StringVar(&databaseName, "database", "", "Database to clone")
+	cmd.Flags().StringVar(&cloneTargetDatabase, "target-database", "", "Name of the restored database (defaults to --database)")
+	cmd.Flags().StringVar(&cloneSourceNamespace, "source-namespace", "", "Namespace of the source database")
+	cmd.Flags().StringVar(&cloneSourceService, "source-service-name", "", "Kubernetes service name of the source database")
+	cmd.Flags().StringVar(&cloneSourceCHI, "source-chi", "", "ClickHouse: ClickHouseInstallation of the source database, instead of --source-service-name")
+	cmd.Flags().StringVar(&serviceName, "service-name", "", "Kubernetes service name of the target database")
+	cmd.Flags().StringVar(&chiName, "chi", "", "ClickHouse: ClickHouseInstallation of the target database, instead of --service-name")
+	cmd.Flags().StringVar(&backupName, "backup-name", "", "Name of the backup to take (defaults to clone-<database>-<timestamp>)")
+	cmd.Flags().StringSliceVar(&secretRefs, "secret-ref", nil, "Secret reference in the format VAR=secretName:key, for both sides (can be repeated)")
+	cmd.Flags().StringArrayVar(&secretEnvFrom, "secret-env-from", nil, secretEnvFromUsage+", for both sides")
+	cmd.Flags().StringSliceVar(&cloneSourceSecretRefs, "source-secret-ref", nil, "Secret reference overriding --secret-ref on the source side (can be repeated)")
+	cmd.Flags().StringVar(&maskingFile, "masking-rules", "", "YAML file of columns to mask in the clone (hash, null, fake_email, truncate)")
+	cmd.Flags().BoolVar(&validate, "validate", false, "ClickHouse: compare each restored table's bytes with the backup metadata")
+	cmd.Flags().Float64Var(&tolerance, "validation-tolerance", engine.DefaultValidationTolerance, "Relative difference allowed by the validation (0.05 is 5%)")
+	cmd.Flags().StringVar(&verifyFile, "verify-sql", "", fmt.Sprintf("SQL file of queries with '-- expect:' results, checked on the clone (exit code %d on failure)", ExitVerificationFailed))
+	cmd.Flags().BoolVar(&preserveGrants, "preserve-grants", false, "Keep the grants the target database had before it was replaced by the clone")
+	cmd.Flags().StringVar(&sourceContext, "source-context", "", "Kubeconfig context of the source database (defaults to --context)")
+	cmd.Flags().StringVar(&targetContext, "target-context", "", "Kubeconfig context of the target database (defaults to the source context)")
+	cmd.Flags().StringVar(&image, "image", "", "Client image of the backup and restore Jobs (defaults to the engine's)")
+	cmd.Flags().StringVar(&profileName, "profile", "", "Profile of the config file presetting these flags")
+	cmd.Flags().StringSliceVar(&notifyWebhooks, "notify-webhook", nil, "URL to POST a JSON notification to when the backup and the restore start and end (can be repeated)")
+	cmd.Flags().StringSliceVar(&notifySlack, "notify-slack", nil, "Slack incoming webhook URL notified like --notify-webhook (can be repeated)")
+	cmd.Flags().StringVar(&metricsGateway, "metrics-pushgateway", "", "Prometheus Pushgateway URL to push the restore's duration and outcome to")
+	cmd.Flags().StringVar(&metricsDir, "metrics-textfile-dir", "", "node_exporter textfile collector directory to write the restore's duration and outcome to")
+	addReportFlags(cmd)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry run")
+
+	return cmd
+}
+
+func runClone() error {
+	report, err := startReport(dryRun)
+	if err != nil {
+		return err
+	}
+
+	// The report is written even when the clone could not start.
+	backup, err := cloneDatabase(report)
+	if err := report.finish(backup, err); err != nil {
+		return err
+	}
+
+	logger.Global.Info("🎉 Database '%s' cloned into '%s'", databaseName, cloneTargetName())
+	return nil
+}
+
+// cloneTargetName is the name of the copy, the source's by default.
+func cloneTargetName() string {
+	if cloneTargetDatabase == "" {
+		return databaseName
+	}
+	return cloneTargetDatabase
+}
+
+// cloneDatabase backs up the source and restores it into the target, returning
+// the backup it went through.
+func cloneDatabase(report *commandReport) (string, error) {
+	if engineName == "" || databaseName == "" || cloneSourceNamespace == "" ||
+		(cloneSourceService == "" && cloneSourceCHI == "") || (serviceName == "" && chiName == "") {
+		return backupName, fmt.Errorf("missing required flag(s) to clone: --engine, --database, --source-namespace, --source-service-name (or --source-chi), --service-name (or --chi)")
+	}
+	if (chiName != "" || cloneSourceCHI != "") && engineName != "clickhouse" {
+		return backupName, fmt.Errorf("--chi and --source-chi are only supported by the clickhouse engine")
+	}
+
+	eng, err := engine.GetEngine(engineName)
+	if err != nil {
+		return backupName, err
+	}
+	taker, ok := eng.(engine.BackupTaker)
+	if !ok {
+		return backupName, fmt.Errorf("engine %q does not support taking backups", engineName)
+	}
+
+	targetRefs, err := parseSecretRefs(secretRefs)
+	if err != nil {
+		return backupName, err
+	}
+	envFrom, renames, err := parseSecretEnvFrom(secretEnvFrom)
+	if err != nil {
+		return backupName, err
+	}
+	targetRefs = append(targetRefs, renames...)
+	overrides, err := parseSecretRefs(cloneSourceSecretRefs)
+	if err != nil {
+		return backupName, err
+	}
+	sourceRefs := mergeSecretRefs(targetRefs, overrides)
+
+	var maskingRules []masking.Rule
+	if maskingFile != "" {
+		if maskingRules, err = masking.Load(maskingFile); err != nil {
+			return backupName, err
+		}
+	}
+
+	var checks []verify.Check
+	if verifyFile != "" {
+		if checks, err = verify.Load(verifyFile); err != nil {
+			return backupName, err
+		}
+	}
+
+	validation, err := parseValidation()
+	if err != nil {
+		return backupName, err
+	}
+
+	notifications, err := parseNotifications()
+	if err != nil {
+		return backupName, err
+	}
+
+	targetDatabase := cloneTargetName()
+
+	sourceFlags := KubernetesConfigFlags
+	if sourceContext != "" {
+		sourceFlags = contextFlags(KubernetesConfigFlags, sourceContext)
+	}
+	targetFlags := sourceFlags
+	if targetContext != "" {
+		targetFlags = contextFlags(KubernetesConfigFlags, targetContext)
+	}
+
+	source := engine.RestoreOptions{
+		Namespace:     cloneSourceNamespace,
+		ServiceName:   cloneSourceService,
+		CHI:           cloneSourceCHI,
+		DryRun:        dryRun,
+		SecretKeyRefs: sourceRefs,
+		SecretEnvFrom: envFrom,
+		Image:         image,
+		JobOverrides:  jobOverrides,
+		Notifications: notifications,
+	}
+	target := engine.RestoreOptions{
+		Namespace:     resolveNamespace(),
+		ServiceName:   serviceName,
+		CHI:           chiName,
+		DryRun:        dryRun,
+		SecretKeyRefs: targetRefs,
+		SecretEnvFrom: envFrom,
+		Image:         image,
+		JobOverrides:  jobOverrides,
+		MaskingRules:  maskingRules,
+		Validation:    validation,
+		Checks:        checks,
+		Notifications: notifications,
+		Metrics:       metrics.Config{Pushgateway: metricsGateway, TextfileDir: metricsDir},
+
+		PreserveGrants: preserveGrants,
+	}
+
+	// Restoring drops the target database first: never let it be the source.
+	if engine.ContextName(sourceFlags) == engine.ContextName(targetFlags) &&
+		source.Namespace == target.Namespace && source.ServiceName == target.ServiceName &&
+		source.CHI == target.CHI && databaseName == targetDatabase {
+		return backupName, fmt.Errorf("the clone target is the source database %q itself, pick another namespace, service or --target-database", databaseName)
+	}
+
+	backup := backupName
+	if backup == "" {
+		backup = fmt.Sprintf("clone-%s-%s", databaseName, time.Now().UTC().Format("20060102-150405"))
+	}
+
+	report.collect(&source)
+	report.collect(&target)
+
+	logger.Global.Info("🧬 Cloning database '%s' from namespace '%s' into '%s' in namespace '%s' through backup '%s'",
+		databaseName, source.Namespace, targetDatabase, target.Namespace, backup)
+
+	if err := taker.TakeBackup(sourceFlags, backup, databaseName, source); err != nil {
+		return backup, fmt.Errorf("failed to back up the source database: %w", err)
+	}
+	if err := eng.Restore(targetFlags, backup, targetDatabase, target); err != nil {
+		return backup, fmt.Errorf("failed to restore backup '%s' into the target: %w", backup, err)
+	}
+	return backup, nil
+}
+
+// mergeSecretRefs returns refs with the variables in overrides replaced.
+func mergeSecretRefs(refs, overrides []k8screds.SecretKeyRef) []k8screds.SecretKeyRef {
+	overridden := map[string]bool{}
+	for _, ref := range overrides {
+		overridden[ref.EnvVarName] = true
+	}
+
+	merged := []k8screds.SecretKeyRef{}
+	for _, ref := range refs {
+		if !overridden[ref.EnvVarName] {
+			merged = append(merged, ref)
+		}
+	}
+	return append(merged, overrides...)
+}
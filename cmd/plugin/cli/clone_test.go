@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+type mockTakerEngine struct {
+	mockEngine
+	calls      []string
+	backupOpts engine.RestoreOptions
+}
+
+func (m *mockTakerEngine) Name() string {
+	return "mock-taker"
+}
+
+func (m *mockTakerEngine) TakeBackup(_ *genericclioptions.ConfigFlags, backup, database string, opts engine.RestoreOptions) error {
+	m.calls = append(m.calls, "backup "+database+" to "+backup)
+	m.backupOpts = opts
+	return nil
+}
+
+func (m *mockTakerEngine) Restore(configFlags *genericclioptions.ConfigFlags, backup, database string, opts engine.RestoreOptions) error {
+	m.calls = append(m.calls, "restore "+database+" from "+backup)
+	return m.mockEngine.Restore(configFlags, backup, database, opts)
+}
+
+func resetCloneVars() {
+	resetVars()
+	cloneSourceNamespace = ""
+	cloneSourceService = ""
+	cloneSourceCHI = ""
+	cloneSourceSecretRefs = nil
+	cloneTargetDatabase = ""
+}
+
+func TestRunClone(t *testing.T) {
+	resetCloneVars()
+	mock := &mockTakerEngine{}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-taker"
+	databaseName = "shop"
+	cloneSourceNamespace = "prod"
+	cloneSourceService = "clickhouse"
+	namespace = "preview-42"
+	serviceName = "clickhouse"
+	secretRefs = []string{"CLICKHOUSE_USER=preview-ch:user", "AWS_ACCESS_KEY_ID=s3:key"}
+	cloneSourceSecretRefs = []string{"CLICKHOUSE_USER=prod-ch:user"}
+
+	require.NoError(t, runClone())
+
+	require.Len(t, mock.calls, 2)
+	assert.True(t, strings.HasPrefix(mock.calls[0], "backup shop to clone-shop-"))
+	assert.Equal(t, "restore shop from "+strings.TrimPrefix(mock.calls[0], "backup shop to "), mock.calls[1])
+
+	assert.Equal(t, "prod", mock.backupOpts.Namespace)
+	assert.Equal(t, []k8screds.SecretKeyRef{
+		{EnvVarName: "AWS_ACCESS_KEY_ID", SecretName: "s3", Key: "key"},
+		{EnvVarName: "CLICKHOUSE_USER", SecretName: "prod-ch", Key: "user"},
+	}, mock.backupOpts.SecretKeyRefs)
+	assert.Equal(t, "preview-42", mock.lastArgs.opts.Namespace)
+	assert.Equal(t, "preview-ch", mock.lastArgs.opts.SecretKeyRefs[0].SecretName)
+}
+
+func TestRunClone_RefusesToOverwriteSource(t *testing.T) {
+	resetCloneVars()
+	mock := &mockTakerEngine{}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-taker"
+	databaseName = "shop"
+	cloneSourceNamespace = "prod"
+	cloneSourceService = "clickhouse"
+	namespace = "prod"
+	serviceName = "clickhouse"
+
+	err := runClone()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is the source database")
+	assert.Empty(t, mock.calls)
+
+	cloneTargetDatabase = "shop_copy"
+	require.NoError(t, runClone())
+	assert.True(t, strings.HasPrefix(mock.calls[1], "restore shop_copy from clone-shop-"))
+}
+
+func TestRunClone_EngineWithoutBackups(t *testing.T) {
+	resetCloneVars()
+	engine.RegisterEngine(&mockEngine{})
+
+	engineName = "mock"
+	databaseName = "shop"
+	cloneSourceNamespace = "prod"
+	cloneSourceService = "db"
+	serviceName = "db"
+	namespace = "preview"
+
+	err := runClone()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support taking backups")
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	configFile  string
+	profileName string
+)
+
+// profileKeys are the settings a profile can preset, named after their flags.
+// job-overrides has no flag: only a profile or a restore plan sets it.
+var profileKeys = []string{"engine", "namespace", "service-name", "chi", "chi-cluster", "secret-ref", "secret-env-from", "image", "notify-webhook", "notify-slack", "metrics-pushgateway", "metrics-textfile-dir", "job-overrides"}
+
+// defaultConfigFile is $XDG_CONFIG_HOME/kubectl-db-restore/config.yaml, in ~/.config by default.
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "kubectl-db-restore", "config.yaml")
+}
+
+func configPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	return defaultConfigFile()
+}
+
+// loadProfiles reads the profiles of the config file. A missing file has none.
+func loadProfiles() (map[string]map[string]any, error) {
+	path := configPath()
+	// Profile settings such as node selectors have dots in their keys.
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return map[string]map[string]any{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	profiles := map[string]map[string]any{}
+	for name, raw := range v.GetStringMap("profiles") {
+		settings, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid config file %s: profile %q is not a map", path, name)
+		}
+		for key := range settings {
+			if !slices.Contains(profileKeys, key) {
+				return nil, fmt.Errorf("invalid config file %s: unknown setting %q in profile %q (expected one of %s)", path, key, name, strings.Join(profileKeys, ", "))
+			}
+		}
+		profiles[name] = settings
+	}
+	return profiles, nil
+}
+
+// loadProfile returns the settings of the named profile.
+func loadProfile(name string) (map[string]any, error) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	// Viper lower-cases keys, profile names included.
+	profile, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("profile %q not found in %s (available: %s)", name, configPath(), strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// applyProfile presets the command's flags from the --profile, if any. Flags
+// given explicitly keep their value, and settings the command has no flag for
+// are ignored.
+func applyProfile(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("profile")
+	if flag == nil || flag.Value.String() == "" {
+		return nil
+	}
+
+	profile, err := loadProfile(flag.Value.String())
+	if err != nil {
+		return err
+	}
+
+	for key, value := range profile {
+		if key == "job-overrides" {
+			if jobOverrides == nil {
+				if jobOverrides, err = parseJobOverrides(value); err != nil {
+					return fmt.Errorf("profile %q: %w", flag.Value.String(), err)
+				}
+			}
+			continue
+		}
+
+		f := cmd.Flags().Lookup(key)
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := setFlag(f, value); err != nil {
+			return fmt.Errorf("profile %q: invalid %s: %w", flag.Value.String(), key, err)
+		}
+	}
+	return nil
+}
+
+func setFlag(f *pflag.Flag, value any) error {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		items, isList := value.([]any)
+		if !isList {
+			items = []any{value}
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			values = append(values, fmt.Sprint(item))
+		}
+		return slice.Replace(values)
+	}
+	return f.Value.Set(fmt.Sprint(value))
+}
+
+// parseJobOverrides decodes the job-overrides of a profile, rejecting unknown fields.
+func parseJobOverrides(value any) (*job.Overrides, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job-overrides: %w", err)
+	}
+	var overrides job.Overrides
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return nil, fmt.Errorf("invalid job-overrides: %w", err)
+	}
+	return &overrides, nil
+}
+
+func ConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the profiles of the config file",
+	}
+	cmd.AddCommand(configViewCmd())
+	return cmd
+}
+
+func configViewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Show the profiles, or the effective settings of --profile merged with the given flags",
+		Long: `Without --profile, print every profile of the config file. With --profile,
+print the settings a restore would use: the profile's values, overridden by the
+flags given on the command line.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigView(cmd.OutOrStdout(), cmd.Flags())
+		},
+	}
+
+	cmd.Flags().StringVar(&profileName, "profile", "", "Profile to show, merged with the flags below")
+	cmd.Flags().StringVar(&engineName, "engine", "", "Database engine (clickhouse, postgres, ...)")
+	cmd.Flags().StringVar(&serviceName, "service-name", "", "Kubernetes service name for DB")
+	cmd.Flags().StringVar(&chiName, "chi", "", "ClickHouse: ClickHouseInstallation of the database")
+	cmd.Flags().StringVar(&chiCluster, "chi-cluster", "", "ClickHouse: cluster of the --chi")
+	cmd.Flags().StringSliceVar(&secretRefs, "secret-ref", nil, "Secret reference in the format VAR=secretName:key (can be repeated)")
+	cmd.Flags().StringArrayVar(&secretEnvFrom, "secret-env-from", nil, secretEnvFromUsage)
+	cmd.Flags().StringVar(&image, "image", "", "Client image of the Jobs")
+	cmd.Flags().StringSliceVar(&notifyWebhooks, "notify-webhook", nil, "URL notified of the runs")
+	cmd.Flags().StringSliceVar(&notifySlack, "notify-slack", nil, "Slack incoming webhook URL notified of the runs")
+	cmd.Flags().StringVar(&metricsGateway, "metrics-pushgateway", "", "Prometheus Pushgateway URL the metrics are pushed to")
+	cmd.Flags().StringVar(&metricsDir, "metrics-textfile-dir", "", "node_exporter textfile collector directory the metrics are written to")
+
+	return cmd
+}
+
+func runConfigView(out io.Writer, flags *pflag.FlagSet) error {
+	if profileName == "" {
+		profiles, err := loadProfiles()
+		if err != nil {
+			return err
+		}
+		return printYAML(out, map[string]any{"config": configPath(), "profiles": profiles})
+	}
+
+	effective := map[string]any{}
+	for _, key := range profileKeys {
+		f := flags.Lookup(key)
+		if f == nil {
+			continue
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			if values := slice.GetSlice(); len(values) > 0 {
+				effective[key] = values
+			}
+		} else if f.Value.String() != "" {
+			effective[key] = f.Value.String()
+		}
+	}
+	if jobOverrides != nil {
+		effective["job-overrides"] = jobOverrides
+	}
+
+	return printYAML(out, map[string]any{"config": configPath(), "profile": profileName, "settings": effective})
+}
+
+func printYAML(out io.Writer, v any) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
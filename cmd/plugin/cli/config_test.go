@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+)
+
+const testConfig = `
+profiles:
+  prod-clickhouse:
+    engine: mock
+    namespace: analytics
+    service-name: clickhouse-prod
+    secret-ref:
+      - CLICKHOUSE_PASSWORD=clickhouse:password
+    image: registry.internal/clickhouse:25.5
+    job-overrides:
+      serviceAccountName: db-restore
+      nodeSelector:
+        node.kubernetes.io/pool: restore
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRootCmd_Profile(t *testing.T) {
+	resetVars()
+	mock := &mockEngine{}
+	engine.RegisterEngine(mock)
+
+	cmd := RootCmd()
+	cmd.SetArgs([]string{"database", "--config", writeConfig(t, testConfig), "--profile", "prod-clickhouse",
+		"--service-name", "clickhouse-staging", "--backup-name", "daily", "--database", "shop"})
+	require.NoError(t, cmd.Execute())
+
+	require.True(t, mock.restoreCalled)
+	opts := mock.lastArgs.opts
+	assert.Equal(t, "analytics", opts.Namespace)
+	assert.Equal(t, "clickhouse-staging", opts.ServiceName, "explicit flags override the profile")
+	assert.Equal(t, "registry.internal/clickhouse:25.5", opts.Image)
+	require.Len(t, opts.SecretKeyRefs, 1)
+	assert.Equal(t, "clickhouse", opts.SecretKeyRefs[0].SecretName)
+	require.NotNil(t, opts.JobOverrides)
+	assert.Equal(t, "db-restore", opts.JobOverrides.ServiceAccountName)
+	assert.Equal(t, map[string]string{"node.kubernetes.io/pool": "restore"}, opts.JobOverrides.NodeSelector)
+}
+
+func TestRootCmd_UnknownProfile(t *testing.T) {
+	resetVars()
+
+	cmd := RootCmd()
+	cmd.SetArgs([]string{"database", "--config", writeConfig(t, testConfig), "--profile", "staging"})
+	assert.EqualError(t, cmd.Execute(), `profile "staging" not found in `+configFile+` (available: prod-clickhouse)`)
+}
+
+func TestLoadProfiles_Invalid(t *testing.T) {
+	resetVars()
+
+	configFile = writeConfig(t, "profiles:\n  prod:\n    engin: clickhouse\n")
+	_, err := loadProfiles()
+	assert.ErrorContains(t, err, `unknown setting "engin" in profile "prod"`)
+
+	configFile = filepath.Join(t.TempDir(), "missing.yaml")
+	profiles, err := loadProfiles()
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+}
+
+func TestConfigView(t *testing.T) {
+	resetVars()
+	path := writeConfig(t, testConfig)
+
+	var out bytes.Buffer
+	cmd := RootCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"config", "view", "--config", path, "--profile", "prod-clickhouse", "--image", "clickhouse:latest"})
+	require.NoError(t, cmd.Execute())
+
+	assert.Equal(t, `config: `+path+`
+profile: prod-clickhouse
+settings:
+  engine: mock
+  image: clickhouse:latest
+  job-overrides:
+    nodeSelector:
+      node.kubernetes.io/pool: restore
+    serviceAccountName: db-restore
+  namespace: analytics
+  secret-ref:
+  - CLICKHOUSE_PASSWORD=clickhouse:password
+  service-name: clickhouse-prod
+`, out.String())
+}
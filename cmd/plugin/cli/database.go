@@ -1,48 +1,399 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
 	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
 	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 var (
-	engineName   string
-	backupName   string
-	databaseName string
-	namespace    string
-	serviceName  string
-	dryRun       bool
-	osExit       = os.Exit
-	secretRefs   []string
+	engineName     string
+	backupName     string
+	backupSelector string
+	backupBefore   string
+	restoreMode    string
+	physicalTool   string
+	recoveryTarget string
+	statefulSet    string
+	sourceCluster  string
+	targetCluster  string
+	repointService bool
+	clusterTimeout time.Duration
+	databaseName   string
+	databaseNames  []string
+	databasesFile  string
+	parallelism    int
+	namespace      string
+	serviceName    string
+	chiName        string
+	chiCluster     string
+	sourceContext  string
+	targetContext  string
+	maskingFile    string
+	validate       bool
+	manifestFile   string
+	tolerance      float64
+	verifyFile     string
+	preserveGrants bool
+	dryRun         bool
+	osExit         = os.Exit
+	secretRefs     []string
+	secretEnvFrom  []string
+	image          string
+	notifyWebhooks []string
+	notifySlack    []string
+	metricsGateway string
+	metricsDir     string
+
+	// Only set from a profile or a restore plan, it has no flag.
+	jobOverrides *job.Overrides
+	restoreHooks engine.Hooks
 )
 
+func DatabaseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "database",
+		Short: "Restore a database from a backup",
+		Long: `Drop the database and restore it from a backup, one Kubernetes Job per step.
+Every Job of the run is labelled with its run ID, printed when the restore starts,
+to follow it with status and logs.`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if err := viper.BindPFlags(cmd.Flags()); err != nil {
+				panic(fmt.Errorf("failed to bind flags: %w", err))
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().NFlag() == 0 {
+				return cmd.Help()
+			}
+			if err := validateRestoreFlags(); err != nil {
+				return err
+			}
+			return runDatabaseRestore()
+		},
+	}
+
+	cmd.Flags().StringVar(&engineName, "engine", "", "Database engine (clickhouse, postgres, ...)")
+	cmd.Flags().StringVar(&backupName, "backup-name", "", "Backup name")
+	cmd.Flags().StringVar(&backupSelector, "backup", "", "Restore the newest backup matching 'latest' or a glob (e.g. 'daily-*') instead of --backup-name")
+	cmd.Flags().StringVar(&backupBefore, "backup-before", "", "Restore the newest backup taken before this timestamp (RFC3339 or YYYY-MM-DD, UTC)")
+	cmd.Flags().StringSliceVar(&databaseNames, "database", nil, "Database name (can be repeated or comma-separated to restore several)")
+	cmd.Flags().StringVar(&databasesFile, "databases-file", "", "File listing databases to restore, one per line")
+	cmd.Flags().IntVar(&parallelism, "parallelism", 1, "Number of databases restored at the same time")
+	cmd.Flags().StringVar(&serviceName, "service-name", "", "Kubernetes service name for DB")
+	cmd.Flags().StringVar(&chiName, "chi", "", "ClickHouse: ClickHouseInstallation to read the host, cluster and credentials from, instead of --service-name")
+	cmd.Flags().StringVar(&chiCluster, "chi-cluster", "", "ClickHouse: cluster of the --chi to restore on, when it defines several")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry run")
+	cmd.Flags().StringVar(&maskingFile, "masking-rules", "", "YAML file of columns to mask after a logical restore (hash, null, fake_email, truncate)")
+	cmd.Flags().BoolVar(&validate, "validate", false, "ClickHouse: after the restore, compare each table's bytes with the backup metadata (rows too with a manifest)")
+	cmd.Flags().StringVar(&manifestFile, "validation-manifest", "", "ClickHouse: validate against this manifest of expected table sizes instead (implies --validate)")
+	cmd.Flags().Float64Var(&tolerance, "validation-tolerance", engine.DefaultValidationTolerance, "Relative difference allowed by the validation (0.05 is 5%)")
+	cmd.Flags().StringVar(&verifyFile, "verify-sql", "", fmt.Sprintf("SQL file of queries with '-- expect:' results, checked after the restore (exit code %d on failure)", ExitVerificationFailed))
+	cmd.Flags().BoolVar(&preserveGrants, "preserve-grants", false, "Snapshot the grants on the database before it is dropped and replay them after a logical restore")
+	cmd.Flags().StringVar(&sourceContext, "source-context", "", "Kubeconfig context to read backups and configuration from (defaults to --context)")
+	cmd.Flags().StringVar(&targetContext, "target-context", "", "Kubeconfig context to create the restore Jobs in (defaults to the source context)")
+	cmd.Flags().StringSliceVar(&secretRefs, "secret-ref", nil, "Secret reference in the format VAR=secretName:key (can be repeated)")
+	cmd.Flags().StringArrayVar(&secretEnvFrom, "secret-env-from", nil, secretEnvFromUsage)
+	cmd.Flags().StringVar(&image, "image", "", "Client image of the restore Jobs (defaults to the engine's)")
+	cmd.Flags().StringVar(&profileName, "profile", "", "Profile of the config file presetting --engine, --namespace, --service-name, --secret-ref, --image, ...")
+	cmd.Flags().StringSliceVar(&notifyWebhooks, "notify-webhook", nil, "URL to POST a JSON notification to when the restore starts and ends (can be repeated)")
+	cmd.Flags().StringSliceVar(&notifySlack, "notify-slack", nil, "Slack incoming webhook URL to post a message to when the restore starts and ends (can be repeated)")
+	cmd.Flags().StringVar(&metricsGateway, "metrics-pushgateway", "", "Prometheus Pushgateway URL to push the restore's duration and outcome to")
+	cmd.Flags().StringVar(&metricsDir, "metrics-textfile-dir", "", "node_exporter textfile collector directory to write the restore's duration and outcome to")
+	addReportFlags(cmd)
+	cmd.Flags().StringVar(&restoreMode, "mode", "", "Restore mode: logical (default), physical or operator (postgres only)")
+	cmd.Flags().StringVar(&physicalTool, "physical-tool", "", "Backup tool of a physical restore: wal-g or pgbackrest")
+	cmd.Flags().StringVar(&recoveryTarget, "recovery-target-time", "", "Physical and operator restores: replay WAL up to this timestamp (RFC3339, UTC by default)")
+	cmd.Flags().StringVar(&statefulSet, "statefulset", "", "Physical restores: StatefulSet running the database (defaults to --service-name)")
+	cmd.Flags().StringVar(&sourceCluster, "cluster", "", "Operator restores: CloudNativePG Cluster or Zalando postgresql whose backups are restored")
+	cmd.Flags().StringVar(&targetCluster, "target-cluster", "", "Operator restores: name of the cluster to create (defaults to <cluster>-restore-<timestamp>)")
+	cmd.Flags().BoolVar(&repointService, "repoint-service", false, "Operator restores: point --service-name at the restored cluster once it is ready")
+	cmd.Flags().DurationVar(&clusterTimeout, "cluster-timeout", engine.DefaultClusterTimeout, "Operator and physical restores: how long to wait for the restored cluster or StatefulSet to be ready")
+
+	return cmd
+}
+
 func runDatabaseRestore() error {
-	logger.Global.Info("Restoring database '%s' from backup '%s' using engine '%s'", databaseName, backupName, engineName)
+	report, err := startReport(dryRun)
+	if err != nil {
+		logger.Global.Error(err)
+		osExit(1)
+		return nil
+	}
+	// failed ends a restore that could not start, still writing its report.
+	failed := func(backup string, err error) error {
+		logger.Global.Error(report.finish(backup, err))
+		osExit(1)
+		return nil // add this for testability
+	}
 
 	eng, err := engine.GetEngine(engineName)
+	if err != nil {
+		return failed(backupName, err)
+	}
+	parsedRefs, err := parseSecretRefs(secretRefs)
+	if err != nil {
+		return failed(backupName, err)
+	}
+	envFrom, renames, err := parseSecretEnvFrom(secretEnvFrom)
+	if err != nil {
+		return failed(backupName, err)
+	}
+	parsedRefs = append(parsedRefs, renames...)
+
+	databases, err := restoreDatabaseList()
+	if err != nil {
+		return failed(backupName, err)
+	}
+
+	notifications, err := parseNotifications()
+	if err != nil {
+		return failed(backupName, err)
+	}
+
+	opts := engine.RestoreOptions{
+		Namespace:     resolveNamespace(),
+		ServiceName:   serviceName,
+		DryRun:        dryRun,
+		SecretKeyRefs: parsedRefs,
+		SecretEnvFrom: envFrom,
+		Image:         image,
+		Mode:          restoreMode,
+		PhysicalTool:  physicalTool,
+		StatefulSet:   statefulSet,
+
+		SourceCluster:  sourceCluster,
+		TargetCluster:  targetCluster,
+		RepointService: repointService,
+		ClusterTimeout: clusterTimeout,
+
+		CHI:        chiName,
+		CHICluster: chiCluster,
+
+		PreserveGrants: preserveGrants,
+
+		JobOverrides:  jobOverrides,
+		Hooks:         restoreHooks,
+		Notifications: notifications,
+		Metrics:       metrics.Config{Pushgateway: metricsGateway, TextfileDir: metricsDir},
+	}
+	report.collect(&opts)
+
+	if maskingFile != "" {
+		opts.MaskingRules, err = masking.Load(maskingFile)
+		if err != nil {
+			return failed(backupName, err)
+		}
+	}
+
+	if verifyFile != "" {
+		opts.Checks, err = verify.Load(verifyFile)
+		if err != nil {
+			return failed(backupName, err)
+		}
+	}
+
+	opts.Validation, err = parseValidation()
+	if err != nil {
+		return failed(backupName, err)
+	}
+
+	sourceFlags := KubernetesConfigFlags
+	if sourceContext != "" {
+		sourceFlags = contextFlags(KubernetesConfigFlags, sourceContext)
+	}
+	if targetContext != "" {
+		opts.TargetConfigFlags = contextFlags(KubernetesConfigFlags, targetContext)
+	}
+	if recoveryTarget != "" {
+		opts.RecoveryTargetTime, err = parseTimestamp(recoveryTarget)
+		if err != nil {
+			return failed(backupName, fmt.Errorf("invalid --recovery-target-time: %w", err))
+		}
+	}
+
+	backup := backupName
+	if backup == "" {
+		selector, err := parseBackupSelector(backupSelector, backupBefore)
+		if err != nil {
+			return failed("", err)
+		}
+
+		// Resolved in a dry run too, so the plan shows the backup that would be
+		// restored: listing the backups only reads the bucket.
+		resolved, err := engine.ResolveBackup(eng, sourceFlags, selector, opts)
+		if err != nil {
+			return failed("", fmt.Errorf("failed to resolve backup %q: %w", selector, err))
+		}
+		backup = resolved.Name
+		opts.BackupSize = resolved.Size
+		logger.Global.Info("🔎 Resolved backup %q to '%s'", selector, backup)
+	} else if !opts.Metrics.Empty() && !opts.DryRun {
+		opts.BackupSize = backupSize(eng, sourceFlags, backup, opts)
+	}
+
+	if opts.TargetConfigFlags != nil {
+		logger.Global.Info("🌐 Reading from context '%s', restoring into context '%s'", engine.ContextName(sourceFlags), engine.ContextName(opts.TargetConfigFlags))
+	}
+
+	if len(databases) > 1 {
+		results := restoreDatabases(eng, sourceFlags, backup, databases, opts, parallelism)
+		if err := report.finish(backup, summarizeRestores(results)); err != nil {
+			logger.Global.Error(err)
+			osExit(exitCode(err))
+		}
+		return nil
+	}
+
+	logger.Global.Info("Restoring database '%s' from backup '%s' using engine '%s'", databases[0], backup, engineName)
+
+	err = report.finish(backup, eng.Restore(sourceFlags, backup, databases[0], opts))
 	if err != nil {
 		logger.Global.Error(err)
-		osExit(1)
+		osExit(exitCode(err))
+		return nil
+	}
+
+	logger.Global.Info("Restore completed successfully")
+	return nil
+}
+
+// ExitVerificationFailed is the exit code of a restore that completed but whose
+// --verify-sql checks failed, so automation can tell it from other errors.
+const ExitVerificationFailed = 3
+
+func exitCode(err error) int {
+	var failed *restoresFailedError
+	if errors.As(err, &failed) {
+		if failed.VerificationOnly {
+			return ExitVerificationFailed
+		}
+		return 1
+	}
+	if verify.IsFailed(err) {
+		return ExitVerificationFailed
+	}
+	return 1
+}
+
+// timestampLayouts are the accepted formats of timestamp flags, read as UTC
+// when they carry no zone.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseValidation builds the post-restore validation from --validate,
+// --validation-manifest (which implies it) and --validation-tolerance.
+func parseValidation() (*engine.Validation, error) {
+	if !validate && manifestFile == "" {
+		return nil, nil
 	}
+	if tolerance < 0 {
+		return nil, fmt.Errorf("invalid --validation-tolerance %v, expected a fraction such as 0.05", tolerance)
+	}
+
+	validation := &engine.Validation{Tolerance: tolerance}
+	if manifestFile != "" {
+		manifest, err := engine.LoadValidationManifest(manifestFile)
+		if err != nil {
+			return nil, err
+		}
+		validation.Manifest = manifest
+	}
+	return validation, nil
+}
+
+// parseBackupSelector builds the selector from --backup and --backup-before.
+func parseBackupSelector(pattern, before string) (engine.BackupSelector, error) {
+	selector := engine.BackupSelector{Pattern: pattern}
+	if before == "" {
+		return selector, nil
+	}
+
+	t, err := parseTimestamp(before)
+	if err != nil {
+		return selector, fmt.Errorf("invalid --backup-before: %w", err)
+	}
+	selector.Before = t
+	return selector, nil
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a timestamp like 2025-06-16T02:00:00Z or 2025-06-16", value)
+}
+
+// backupSize looks a backup given by name up in the engine's listing, for the
+// restored_bytes metric. It is 0 when the engine cannot list its backups or does
+// not find it.
+func backupSize(eng engine.Engine, configFlags *genericclioptions.ConfigFlags, backup string, opts engine.RestoreOptions) int64 {
+	lister, ok := eng.(engine.BackupLister)
+	if !ok {
+		return 0
+	}
+	backups, err := lister.ListBackups(configFlags, opts)
+	if err != nil {
+		logger.Global.Warn("⚠️ Failed to read the size of backup '%s', restored_bytes is not exported: %v", backup, err)
+		return 0
+	}
+	for _, b := range backups {
+		if b.Name == backup {
+			return b.Size
+		}
+	}
+	return 0
+}
+
+// parseNotifications checks the --notify-webhook and --notify-slack URLs.
+func parseNotifications() (notify.Config, error) {
+	config := notify.Config{Webhooks: notifyWebhooks, Slack: notifySlack}
+	for _, urls := range [][]string{config.Webhooks, config.Slack} {
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				// The URL itself is not printed, it often holds a token.
+				return notify.Config{}, fmt.Errorf("invalid notification webhook: expected an http(s) URL")
+			}
+		}
+	}
+	return config, nil
+}
+
+// parseSecretRefs parses --secret-ref values of the form VAR=secretName:key.
+func parseSecretRefs(refs []string) ([]k8screds.SecretKeyRef, error) {
 	parsedRefs := []k8screds.SecretKeyRef{}
-	for _, ref := range secretRefs {
+	for _, ref := range refs {
 		parts := strings.SplitN(ref, "=", 2)
 		if len(parts) != 2 {
-			logger.Global.Error(fmt.Errorf("invalid --secret-ref format: %s", ref))
-			osExit(1)
-			return nil // add this for testability
+			return nil, fmt.Errorf("invalid --secret-ref format: %s", ref)
 		}
 
 		secretParts := strings.SplitN(parts[1], ":", 2)
 		if len(secretParts) != 2 {
-			logger.Global.Error(fmt.Errorf("invalid secret/key in --secret-ref: %s", ref))
-			osExit(1)
-			return nil
+			return nil, fmt.Errorf("invalid secret/key in --secret-ref: %s", ref)
 		}
 
 		parsedRefs = append(parsedRefs, k8screds.SecretKeyRef{
@@ -51,20 +402,60 @@ func runDatabaseRestore() error {
 			Key:        secretParts[1],
 		})
 	}
+	return parsedRefs, nil
+}
 
-	opts := engine.RestoreOptions{
-		Namespace:     namespace,
-		ServiceName:   serviceName,
-		DryRun:        dryRun,
-		SecretKeyRefs: parsedRefs,
+const secretEnvFromUsage = "Secret whose every key is loaded into the Jobs, in the format secretName[:PREFIX][,VAR=key...] renaming keys to variables (can be repeated)"
+
+// parseSecretEnvFrom parses --secret-env-from values of the form
+// secretName[:PREFIX][,VAR=key...]. Every key of the Secret is loaded as
+// PREFIX+key, and each VAR=key also loads key as VAR, returned as a SecretKeyRef.
+func parseSecretEnvFrom(values []string) ([]k8screds.SecretEnvFrom, []k8screds.SecretKeyRef, error) {
+	var envFrom []k8screds.SecretEnvFrom
+	var renames []k8screds.SecretKeyRef
+	for _, value := range values {
+		parts := strings.Split(value, ",")
+		name, prefix, _ := strings.Cut(parts[0], ":")
+		if name == "" {
+			return nil, nil, fmt.Errorf("invalid --secret-env-from format: %s", value)
+		}
+		envFrom = append(envFrom, k8screds.SecretEnvFrom{SecretName: name, Prefix: prefix})
+
+		for _, rename := range parts[1:] {
+			env, key, ok := strings.Cut(rename, "=")
+			if !ok || env == "" || key == "" {
+				return nil, nil, fmt.Errorf("invalid VAR=key in --secret-env-from: %s", value)
+			}
+			renames = append(renames, k8screds.SecretKeyRef{EnvVarName: env, SecretName: name, Key: key})
+		}
 	}
+	return envFrom, renames, nil
+}
 
-	err = eng.Restore(KubernetesConfigFlags, backupName, databaseName, opts)
-	if err != nil {
-		logger.Global.Error(err)
-		osExit(1)
+// resolveNamespace returns the namespace to work in: the explicit one if set,
+// otherwise the one from --namespace or the current kubeconfig context.
+func resolveNamespace() string {
+	if namespace != "" {
+		return namespace
+	}
+	ns, _, err := KubernetesConfigFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil || ns == "" {
+		return "default"
 	}
+	return ns
+}
 
-	logger.Global.Info("Restore completed successfully")
-	return nil
+// contextFlags returns a copy of the kubeconfig flags pointing at another context.
+// Settings tied to a context (server, user, credentials) are not copied, they
+// come from the context itself.
+func contextFlags(base *genericclioptions.ConfigFlags, context string) *genericclioptions.ConfigFlags {
+	flags := genericclioptions.NewConfigFlags(false)
+	flags.KubeConfig = base.KubeConfig
+	flags.Namespace = base.Namespace
+	flags.Impersonate = base.Impersonate
+	flags.ImpersonateUID = base.ImpersonateUID
+	flags.ImpersonateGroup = base.ImpersonateGroup
+	flags.Timeout = base.Timeout
+	flags.Context = &context
+	return flags
 }
@@ -2,12 +2,18 @@ package cli
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/wiremind/kubectl-db-restore/pkg/engine"
 	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -15,6 +21,7 @@ import (
 type mockEngine struct {
 	restoreCalled bool
 	returnErr     error
+	lastFlags     *genericclioptions.ConfigFlags
 	lastArgs      struct {
 		backup   string
 		database string
@@ -27,11 +34,12 @@ func (m *mockEngine) Name() string {
 }
 
 func (m *mockEngine) Restore(
-	_ *genericclioptions.ConfigFlags,
+	configFlags *genericclioptions.ConfigFlags,
 	backup, database string,
 	opts engine.RestoreOptions,
 ) error {
 	m.restoreCalled = true
+	m.lastFlags = configFlags
 	m.lastArgs.backup = backup
 	m.lastArgs.database = database
 	m.lastArgs.opts = opts
@@ -42,11 +50,46 @@ func (m *mockEngine) Restore(
 func resetVars() {
 	engineName = ""
 	backupName = ""
+	backupSelector = ""
+	backupBefore = ""
+	restoreMode = ""
+	physicalTool = ""
+	recoveryTarget = ""
+	statefulSet = ""
+	sourceCluster = ""
+	targetCluster = ""
+	repointService = false
+	clusterTimeout = engine.DefaultClusterTimeout
 	databaseName = ""
+	databaseNames = nil
+	databasesFile = ""
+	parallelism = 1
 	namespace = ""
 	serviceName = ""
+	chiName = ""
+	chiCluster = ""
+	sourceContext = ""
+	targetContext = ""
+	maskingFile = ""
+	validate = false
+	manifestFile = ""
+	verifyFile = ""
+	preserveGrants = false
+	tolerance = engine.DefaultValidationTolerance
 	dryRun = false
 	secretRefs = nil
+	secretEnvFrom = nil
+	image = ""
+	profileName = ""
+	configFile = ""
+	jobOverrides = nil
+	restoreHooks = engine.Hooks{}
+	notifyWebhooks = nil
+	notifySlack = nil
+	metricsGateway = ""
+	metricsDir = ""
+	reportFormat = ""
+	reportFile = ""
 	KubernetesConfigFlags = genericclioptions.NewConfigFlags(false)
 }
 
@@ -58,7 +101,7 @@ func TestRunDatabaseRestore_Success(t *testing.T) {
 
 	engineName = "mock"
 	backupName = "test-backup"
-	databaseName = "test-db"
+	databaseNames = []string{"test-db"}
 	namespace = "test-ns"
 	serviceName = "test-svc"
 	dryRun = false
@@ -71,13 +114,53 @@ func TestRunDatabaseRestore_Success(t *testing.T) {
 	assert.Equal(t, "test-backup", mock.lastArgs.backup)
 	assert.Equal(t, "test-db", mock.lastArgs.database)
 	assert.Equal(t, engine.RestoreOptions{
-		Namespace:     "test-ns",
-		ServiceName:   "test-svc",
-		DryRun:        false,
-		SecretKeyRefs: []k8screds.SecretKeyRef{},
+		Namespace:      "test-ns",
+		ServiceName:    "test-svc",
+		DryRun:         false,
+		SecretKeyRefs:  []k8screds.SecretKeyRef{},
+		ClusterTimeout: engine.DefaultClusterTimeout,
 	}, mock.lastArgs.opts)
 }
 
+func TestRunDatabaseRestore_Metrics(t *testing.T) {
+	resetVars()
+	mock := &mockListerEngine{backups: testBackups}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-lister"
+	backupName = "daily-2025-06-15"
+	databaseNames = []string{"analytics"}
+	serviceName = "test-svc"
+	metricsGateway = "http://pushgateway:9091"
+
+	require.NoError(t, runDatabaseRestore())
+	opts := mock.lastArgs.opts
+	assert.Equal(t, metrics.Config{Pushgateway: "http://pushgateway:9091"}, opts.Metrics)
+	assert.Equal(t, int64(2048), opts.BackupSize)
+
+	resetVars()
+	engineName = "mock-lister"
+	backupName = "not-listed"
+	databaseNames = []string{"analytics"}
+	serviceName = "test-svc"
+	metricsDir = t.TempDir()
+
+	require.NoError(t, runDatabaseRestore())
+	assert.Zero(t, mock.lastArgs.opts.BackupSize, "the size of a backup missing from the listing is unknown")
+
+	resetVars()
+	mock.listings = 0
+	engineName = "mock-lister"
+	backupSelector = "latest"
+	databaseNames = []string{"analytics"}
+	serviceName = "test-svc"
+	metricsDir = t.TempDir()
+
+	require.NoError(t, runDatabaseRestore())
+	assert.Equal(t, int64(3*1024*1024), mock.lastArgs.opts.BackupSize)
+	assert.Equal(t, 1, mock.listings, "the size comes from the listing the selector was resolved with")
+}
+
 func TestRunDatabaseRestore_RestoreFails(t *testing.T) {
 	resetVars()
 	mock := &mockEngine{returnErr: errors.New("restore failed")}
@@ -85,7 +168,7 @@ func TestRunDatabaseRestore_RestoreFails(t *testing.T) {
 
 	engineName = "mock"
 	backupName = "test-backup"
-	databaseName = "test-db"
+	databaseNames = []string{"test-db"}
 	serviceName = "test-svc"
 
 	// Capture osExit
@@ -109,7 +192,7 @@ func TestRunDatabaseRestore_InvalidSecretRef(t *testing.T) {
 
 	engineName = "mock"
 	backupName = "test-backup"
-	databaseName = "test-db"
+	databaseNames = []string{"test-db"}
 	serviceName = "test-svc"
 	secretRefs = []string{"INVALID_FORMAT"}
 
@@ -124,3 +207,252 @@ func TestRunDatabaseRestore_InvalidSecretRef(t *testing.T) {
 
 	assert.True(t, exitCalled)
 }
+
+func TestParseSecretEnvFrom(t *testing.T) {
+	envFrom, renames, err := parseSecretEnvFrom([]string{"ch-credentials", "s3:BACKUP_,AWS_ACCESS_KEY_ID=access-key,AWS_SECRET_ACCESS_KEY=secret-key"})
+	require.NoError(t, err)
+	assert.Equal(t, []k8screds.SecretEnvFrom{
+		{SecretName: "ch-credentials"},
+		{SecretName: "s3", Prefix: "BACKUP_"},
+	}, envFrom)
+	assert.Equal(t, []k8screds.SecretKeyRef{
+		{EnvVarName: "AWS_ACCESS_KEY_ID", SecretName: "s3", Key: "access-key"},
+		{EnvVarName: "AWS_SECRET_ACCESS_KEY", SecretName: "s3", Key: "secret-key"},
+	}, renames)
+
+	_, _, err = parseSecretEnvFrom([]string{":PREFIX_"})
+	assert.EqualError(t, err, "invalid --secret-env-from format: :PREFIX_")
+	_, _, err = parseSecretEnvFrom([]string{"s3,AWS_ACCESS_KEY_ID"})
+	assert.EqualError(t, err, "invalid VAR=key in --secret-env-from: s3,AWS_ACCESS_KEY_ID")
+}
+
+func TestRunDatabaseRestore_ResolvesBackupSelector(t *testing.T) {
+	resetVars()
+	mock := &mockListerEngine{backups: testBackups}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-lister"
+	backupSelector = "daily-*"
+	backupBefore = "2025-06-16"
+	databaseNames = []string{"test-db"}
+	namespace = "test-ns"
+	serviceName = "test-svc"
+
+	err := runDatabaseRestore()
+
+	assert.NoError(t, err)
+	assert.True(t, mock.restoreCalled)
+	assert.Equal(t, "daily-2025-06-15", mock.lastArgs.backup)
+}
+
+func TestRunDatabaseRestore_DryRunResolvesSelector(t *testing.T) {
+	resetVars()
+	mock := &mockListerEngine{backups: testBackups}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-lister"
+	backupSelector = "daily-*"
+	databaseNames = []string{"test-db"}
+	serviceName = "test-svc"
+	dryRun = true
+
+	err := runDatabaseRestore()
+
+	assert.NoError(t, err)
+	assert.True(t, mock.restoreCalled)
+	assert.True(t, mock.lastArgs.opts.DryRun)
+	assert.Equal(t, "daily-2025-06-16", mock.lastArgs.backup, "the plan shows the backup that would be restored")
+
+	mock.restoreCalled = false
+	backupSelector = "monthly-*"
+	exitCalled := false
+	osExit = func(code int) {
+		exitCalled = true
+	}
+	defer func() { osExit = os.Exit }()
+
+	require.NoError(t, runDatabaseRestore())
+	assert.True(t, exitCalled, "a selector matching nothing fails the dry run")
+	assert.False(t, mock.restoreCalled)
+}
+
+func TestRunDatabaseRestore_UnresolvableSelector(t *testing.T) {
+	resetVars()
+	mock := &mockListerEngine{backups: testBackups}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock-lister"
+	backupSelector = "monthly-*"
+	databaseNames = []string{"test-db"}
+	serviceName = "test-svc"
+
+	exitCalled := false
+	osExit = func(code int) {
+		exitCalled = true
+	}
+	defer func() { osExit = os.Exit }()
+
+	err := runDatabaseRestore()
+	assert.NoError(t, err)
+
+	assert.True(t, exitCalled)
+	assert.False(t, mock.restoreCalled)
+}
+
+func TestParseBackupSelector(t *testing.T) {
+	selector, err := parseBackupSelector("latest", "")
+	assert.NoError(t, err)
+	assert.Equal(t, engine.BackupSelector{Pattern: "latest"}, selector)
+
+	selector, err = parseBackupSelector("", "2025-06-16T02:00:00+02:00")
+	assert.NoError(t, err)
+	assert.True(t, selector.Before.Equal(time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC)))
+
+	selector, err = parseBackupSelector("", "2025-06-16")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC), selector.Before)
+
+	_, err = parseBackupSelector("", "yesterday")
+	assert.Error(t, err)
+}
+
+func TestValidateRestoreFlags_BackupNameExclusive(t *testing.T) {
+	resetVars()
+	engineName = "mock"
+	databaseNames = []string{"test-db"}
+	serviceName = "test-svc"
+
+	assert.Error(t, validateRestoreFlags())
+
+	backupSelector = "latest"
+	assert.NoError(t, validateRestoreFlags())
+
+	backupName = "exact-backup"
+	assert.Error(t, validateRestoreFlags())
+}
+
+func TestValidateRestoreFlags_CHI(t *testing.T) {
+	resetVars()
+	engineName = "clickhouse"
+	backupName = "test-backup"
+	databaseNames = []string{"test-db"}
+	chiName = "analytics"
+
+	assert.NoError(t, validateRestoreFlags(), "--chi replaces --service-name")
+
+	engineName = "postgres"
+	assert.Error(t, validateRestoreFlags())
+
+	engineName = "clickhouse"
+	chiName = ""
+	serviceName = "test-svc"
+	chiCluster = "events"
+	assert.Error(t, validateRestoreFlags(), "--chi-cluster without --chi")
+}
+
+func TestRunDatabaseRestore_SourceAndTargetContexts(t *testing.T) {
+	resetVars()
+	mock := &mockEngine{}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock"
+	backupName = "test-backup"
+	databaseNames = []string{"test-db"}
+	namespace = "test-ns"
+	serviceName = "test-svc"
+	sourceContext = "prod"
+	targetContext = "staging"
+	kubeconfig := "/tmp/kubeconfig"
+	KubernetesConfigFlags.KubeConfig = &kubeconfig
+
+	err := runDatabaseRestore()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", *mock.lastFlags.Context)
+	assert.Equal(t, "/tmp/kubeconfig", *mock.lastFlags.KubeConfig)
+	if assert.NotNil(t, mock.lastArgs.opts.TargetConfigFlags) {
+		assert.Equal(t, "staging", *mock.lastArgs.opts.TargetConfigFlags.Context)
+		assert.Equal(t, "/tmp/kubeconfig", *mock.lastArgs.opts.TargetConfigFlags.KubeConfig)
+	}
+}
+
+func TestRunDatabaseRestore_SingleContext(t *testing.T) {
+	resetVars()
+	mock := &mockEngine{}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock"
+	backupName = "test-backup"
+	databaseNames = []string{"test-db"}
+	namespace = "test-ns"
+	serviceName = "test-svc"
+
+	assert.NoError(t, runDatabaseRestore())
+	assert.Same(t, KubernetesConfigFlags, mock.lastFlags)
+	assert.Nil(t, mock.lastArgs.opts.TargetConfigFlags)
+}
+
+func TestParseValidation(t *testing.T) {
+	resetVars()
+
+	validation, err := parseValidation()
+	assert.NoError(t, err)
+	assert.Nil(t, validation)
+
+	validate = true
+	validation, err = parseValidation()
+	assert.NoError(t, err)
+	assert.Equal(t, &engine.Validation{Tolerance: engine.DefaultValidationTolerance}, validation)
+
+	tolerance = -1
+	_, err = parseValidation()
+	assert.Error(t, err)
+
+	tolerance = 0
+	manifestFile = "/does/not/exist.yaml"
+	_, err = parseValidation()
+	assert.Error(t, err)
+}
+
+func TestRunDatabaseRestore_VerificationFailureExitCode(t *testing.T) {
+	resetVars()
+	mock := &mockEngine{returnErr: fmt.Errorf("failed to run postgres-verify: %w", &verify.FailedError{Failed: 1, Total: 3})}
+	engine.RegisterEngine(mock)
+
+	engineName = "mock"
+	backupName = "test-backup"
+	databaseNames = []string{"test-db"}
+	namespace = "test-ns"
+	serviceName = "test-svc"
+
+	exitCode := 0
+	osExit = func(code int) {
+		exitCode = code
+	}
+	defer func() { osExit = os.Exit }()
+
+	assert.NoError(t, runDatabaseRestore())
+	assert.Equal(t, ExitVerificationFailed, exitCode)
+
+	mock.returnErr = errors.New("restore failed")
+	assert.NoError(t, runDatabaseRestore())
+	assert.Equal(t, 1, exitCode)
+}
+
+func TestParseNotifications(t *testing.T) {
+	resetVars()
+
+	notifications, err := parseNotifications()
+	assert.NoError(t, err)
+	assert.True(t, notifications.Empty())
+
+	notifyWebhooks = []string{"https://ops.internal/restores"}
+	notifySlack = []string{"https://hooks.slack.com/services/T000/B000/XXXX"}
+	notifications, err = parseNotifications()
+	assert.NoError(t, err)
+	assert.Equal(t, notify.Config{Webhooks: notifyWebhooks, Slack: notifySlack}, notifications)
+
+	notifySlack = []string{"hooks.slack.com/services/T000/B000/XXXX"}
+	_, err = parseNotifications()
+	assert.EqualError(t, err, "invalid notification webhook: expected an http(s) URL")
+}
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// restoreDatabaseList returns the databases given by --database and
+// --databases-file, without duplicates. Physical and operator restores bring
+// back the whole server: they restore a single, unnamed database.
+func restoreDatabaseList() ([]string, error) {
+	names := append([]string{}, databaseNames...)
+	if databasesFile != "" {
+		fromFile, err := readDatabasesFile(databasesFile)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fromFile...)
+	}
+
+	databases := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		databases = append(databases, name)
+	}
+
+	if restoreMode == "physical" || restoreMode == "operator" {
+		if len(databases) > 0 {
+			return nil, fmt.Errorf("--mode %s restores the whole server and takes no --database", restoreMode)
+		}
+		return []string{""}, nil
+	}
+	if len(databases) == 0 {
+		return nil, fmt.Errorf("no database to restore")
+	}
+	if len(databases) > 1 && manifestFile != "" {
+		return nil, fmt.Errorf("--validation-manifest describes a single database, it cannot be used with several")
+	}
+	if parallelism < 1 {
+		return nil, fmt.Errorf("invalid --parallelism %d, expected at least 1", parallelism)
+	}
+	return databases, nil
+}
+
+// readDatabasesFile reads one database name per line, skipping blank lines and
+// '#' comments.
+func readDatabasesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read databases file: %w", err)
+	}
+	defer f.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read databases file: %w", err)
+	}
+	return names, nil
+}
+
+// restoreResult is the outcome of one database of a multi-database restore.
+type restoreResult struct {
+	Database string
+	Err      error
+	Duration time.Duration
+}
+
+// restoreDatabases restores each database from the same backup, at most
+// parallelism at a time. A failure does not stop the other restores. Dry runs
+// print their plans one database after the other.
+func restoreDatabases(eng engine.Engine, configFlags *genericclioptions.ConfigFlags, backup string, databases []string, opts engine.RestoreOptions, parallelism int) []restoreResult {
+	if opts.DryRun {
+		parallelism = 1
+	}
+	logger.Global.Info("Restoring %d databases from backup '%s' using engine '%s', %d at a time", len(databases), backup, eng.Name(), parallelism)
+
+	progress := newRestoreProgress(databases)
+	results := make([]restoreResult, len(databases))
+	slots := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, database := range databases {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			dbOpts := opts
+			dbOpts.Progress = func(step string) { progress.set(database, step) }
+
+			start := time.Now()
+			progress.set(database, "starting")
+			err := eng.Restore(configFlags, backup, database, dbOpts)
+			results[i] = restoreResult{Database: database, Err: err, Duration: time.Since(start)}
+			progress.finish(database, err)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// restoreProgress logs one line with the state of every database each time
+// one of them moves on, so interleaved Job logs can be followed.
+type restoreProgress struct {
+	mu        sync.Mutex
+	databases []string
+	states    map[string]string
+	done      int
+	failed    int
+}
+
+func newRestoreProgress(databases []string) *restoreProgress {
+	states := map[string]string{}
+	for _, database := range databases {
+		states[database] = "queued"
+	}
+	return &restoreProgress{databases: databases, states: states}
+}
+
+func (p *restoreProgress) set(database, state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.states[database] = state
+	logger.Global.Info("📊 %s", p.line())
+}
+
+func (p *restoreProgress) finish(database string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	p.states[database] = "✅ done"
+	if err != nil {
+		p.failed++
+		p.states[database] = "❌ failed"
+	}
+	logger.Global.Info("📊 %s", p.line())
+}
+
+// line renders the progress, e.g. "1/3 finished, 0 failed | shop: clickhouse-restore | ...".
+func (p *restoreProgress) line() string {
+	parts := []string{fmt.Sprintf("%d/%d finished, %d failed", p.done, len(p.databases), p.failed)}
+	for _, database := range p.databases {
+		parts = append(parts, fmt.Sprintf("%s: %s", database, p.states[database]))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// restoresFailedError is returned when at least one database failed to restore.
+type restoresFailedError struct {
+	Failed int
+	Total  int
+	// VerificationOnly is set when every failed restore completed but did not
+	// pass its --verify-sql checks.
+	VerificationOnly bool
+}
+
+func (e *restoresFailedError) Error() string {
+	return fmt.Sprintf("%d of %d database restore(s) failed", e.Failed, e.Total)
+}
+
+// summarizeRestores prints the outcome of every database and returns a
+// *restoresFailedError when any failed.
+func summarizeRestores(results []restoreResult) error {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "DATABASE\tSTATUS\tDURATION\tERROR")
+
+	failed := &restoresFailedError{Total: len(results), VerificationOnly: true}
+	for _, r := range results {
+		status, message := "✅ restored", ""
+		if r.Err != nil {
+			status, message = "❌ failed", r.Err.Error()
+			failed.Failed++
+			failed.VerificationOnly = failed.VerificationOnly && verify.IsFailed(r.Err)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Database, status, r.Duration.Round(time.Second), message)
+	}
+	_ = w.Flush()
+	logger.Global.Instructions("📋 Restore summary:\n%s", b.String())
+
+	if failed.Failed > 0 {
+		return failed
+	}
+	logger.Global.Info("Restore completed successfully")
+	return nil
+}
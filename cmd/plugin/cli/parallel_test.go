@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// concurrentEngine records the restores it runs, possibly from several goroutines.
+type concurrentEngine struct {
+	mu        sync.Mutex
+	running   int
+	maxActive int
+	restored  []string
+	failures  map[string]error
+}
+
+func (e *concurrentEngine) Name() string {
+	return "concurrent"
+}
+
+func (e *concurrentEngine) Restore(_ *genericclioptions.ConfigFlags, _, database string, opts engine.RestoreOptions) error {
+	e.mu.Lock()
+	e.running++
+	e.maxActive = max(e.maxActive, e.running)
+	e.mu.Unlock()
+
+	opts.Progress("restore")
+	time.Sleep(10 * time.Millisecond)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running--
+	e.restored = append(e.restored, database)
+	return e.failures[database]
+}
+
+func TestRestoreDatabaseList(t *testing.T) {
+	resetVars()
+	path := filepath.Join(t.TempDir(), "databases.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# nightly\nevents\n\nusers # pii masked\nshop\n"), 0o600))
+
+	databaseNames = []string{"shop", "billing"}
+	databasesFile = path
+
+	databases, err := restoreDatabaseList()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop", "billing", "events", "users"}, databases)
+
+	parallelism = 0
+	_, err = restoreDatabaseList()
+	assert.ErrorContains(t, err, "invalid --parallelism 0")
+
+	parallelism = 1
+	manifestFile = "manifest.yaml"
+	_, err = restoreDatabaseList()
+	assert.ErrorContains(t, err, "--validation-manifest describes a single database")
+}
+
+func TestRestoreDatabaseList_WholeServerModes(t *testing.T) {
+	resetVars()
+	restoreMode = "physical"
+
+	databases, err := restoreDatabaseList()
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, databases)
+
+	databaseNames = []string{"shop", "events"}
+	_, err = restoreDatabaseList()
+	assert.ErrorContains(t, err, "takes no --database")
+}
+
+func TestRestoreDatabases_RunsAllDespiteFailures(t *testing.T) {
+	eng := &concurrentEngine{failures: map[string]error{"b": errors.New("boom")}}
+
+	results := restoreDatabases(eng, KubernetesConfigFlags, "backup1", []string{"a", "b", "c", "d"}, engine.RestoreOptions{}, 2)
+
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, eng.restored)
+	assert.Equal(t, 2, eng.maxActive)
+	require.Len(t, results, 4)
+	assert.Equal(t, "b", results[1].Database)
+	assert.EqualError(t, results[1].Err, "boom")
+	assert.NoError(t, results[0].Err)
+}
+
+func TestSummarizeRestores(t *testing.T) {
+	verificationFailed := fmt.Errorf("failed to run postgres-verify: %w", &verify.FailedError{Failed: 1, Total: 2})
+
+	assert.NoError(t, summarizeRestores([]restoreResult{{Database: "a"}, {Database: "b"}}))
+
+	err := summarizeRestores([]restoreResult{{Database: "a"}, {Database: "b", Err: verificationFailed}})
+	assert.EqualError(t, err, "1 of 2 database restore(s) failed")
+	assert.Equal(t, ExitVerificationFailed, exitCode(err))
+
+	err = summarizeRestores([]restoreResult{{Database: "a", Err: errors.New("boom")}, {Database: "b", Err: verificationFailed}})
+	assert.Equal(t, 1, exitCode(err))
+}
+
+func TestRunDatabaseRestore_SeveralDatabases(t *testing.T) {
+	resetVars()
+	eng := &concurrentEngine{failures: map[string]error{"events": errors.New("boom")}}
+	engine.RegisterEngine(eng)
+
+	engineName = "concurrent"
+	backupName = "test-backup"
+	databaseNames = []string{"shop", "events", "users"}
+	serviceName = "test-svc"
+	parallelism = 3
+
+	code := 0
+	osExit = func(c int) { code = c }
+	defer func() { osExit = os.Exit }()
+
+	assert.NoError(t, runDatabaseRestore())
+	assert.ElementsMatch(t, []string{"shop", "events", "users"}, eng.restored)
+	assert.Equal(t, 1, code)
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	reportFormat string
+	reportFile   string
+
+	// reportOutput is where --report prints the report without --report-file,
+	// swapped in tests.
+	reportOutput io.Writer = os.Stdout
+)
+
+// addReportFlags registers the report flags of the commands running restores.
+func addReportFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&reportFormat, "report", "", "Print a report of the runs once they have ended, as json or yaml (the logs then go to stderr)")
+	cmd.Flags().StringVar(&reportFile, "report-file", "", "Write the report of the runs to this file instead (YAML for .yaml/.yml, JSON otherwise, unless --report is set)")
+}
+
+// commandReport is the machine-readable outcome of a command: every run it
+// started, with their steps, Jobs and verification results.
+type commandReport struct {
+	Status string             `json:"status"`
+	Backup string             `json:"backup,omitempty"`
+	DryRun bool               `json:"dryRun,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Runs   []engine.RunReport `json:"runs"`
+
+	mu       sync.Mutex
+	encoding string
+}
+
+// startReport returns the report of the command when one was asked for, nil
+// otherwise. The other methods do nothing on a nil report.
+func startReport(dryRun bool) (*commandReport, error) {
+	if reportFormat == "" && reportFile == "" {
+		return nil, nil
+	}
+
+	encoding := reportFormat
+	switch reportFormat {
+	case "json", "yaml":
+	case "":
+		encoding = "json"
+		if ext := filepath.Ext(reportFile); ext == ".yaml" || ext == ".yml" {
+			encoding = "yaml"
+		}
+	default:
+		return nil, fmt.Errorf("invalid --report %q: must be json or yaml", reportFormat)
+	}
+
+	if reportFile == "" {
+		// Keep stdout for the report itself.
+		logger.Global.SetOutput(os.Stderr)
+	}
+	return &commandReport{DryRun: dryRun, Runs: []engine.RunReport{}, encoding: encoding}, nil
+}
+
+// collect adds the runs of the restores started with opts to the report.
+func (r *commandReport) collect(opts *engine.RestoreOptions) {
+	if r == nil {
+		return
+	}
+	opts.Report = func(run engine.RunReport) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.Runs = append(r.Runs, run)
+	}
+}
+
+// finish writes the report with the outcome of the command, err, and returns
+// err. A report that cannot be written fails a command that succeeded, since
+// whatever reads it would not find it.
+func (r *commandReport) finish(backup string, err error) error {
+	if r == nil {
+		return err
+	}
+
+	r.Backup = backup
+	r.Status = history.StatusSucceeded
+	if err != nil {
+		r.Status = history.StatusFailed
+		r.Error = err.Error()
+		// The error of a run has its variable values redacted, prefer it.
+		if len(r.Runs) == 1 && r.Runs[0].Error != "" {
+			r.Error = r.Runs[0].Error
+		}
+	}
+
+	writeErr := r.write()
+	if writeErr == nil {
+		return err
+	}
+	if err == nil {
+		return writeErr
+	}
+	logger.Global.Warn("⚠️ %v", writeErr)
+	return err
+}
+
+func (r *commandReport) write() error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil && r.encoding == "yaml" {
+		data, err = yaml.JSONToYAML(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode the report: %w", err)
+	}
+
+	if reportFile == "" {
+		_, err = fmt.Fprintln(reportOutput, string(data))
+	} else {
+		err = os.WriteFile(reportFile, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+)
+
+// mockReportingEngine reports one run per restore, as the engines do.
+type mockReportingEngine struct {
+	mockEngine
+}
+
+func (m *mockReportingEngine) Name() string {
+	return "mock-reporting"
+}
+
+func (m *mockReportingEngine) Restore(configFlags *genericclioptions.ConfigFlags, backup, database string, opts engine.RestoreOptions) error {
+	err := m.mockEngine.Restore(configFlags, backup, database, opts)
+	status, message := history.StatusSucceeded, ""
+	if err != nil {
+		status, message = history.StatusFailed, "job 'mock-restore-1' failed"
+	}
+	opts.Report(engine.RunReport{
+		RunID:    "20250616-083000-a1b2c3",
+		Engine:   "mock",
+		Database: database,
+		Backup:   backup,
+		Status:   status,
+		Error:    message,
+		Phases:   []engine.PhaseReport{{Name: "mock-restore", Status: status, Job: "mock-restore-1"}},
+	})
+	return err
+}
+
+func TestRunDatabaseRestore_Report(t *testing.T) {
+	resetVars()
+	engine.RegisterEngine(&mockReportingEngine{})
+	defer logger.Global.SetOutput(os.Stdout)
+
+	var out bytes.Buffer
+	reportOutput = &out
+	defer func() { reportOutput = os.Stdout }()
+
+	engineName = "mock-reporting"
+	backupName = "daily-2025-06-16"
+	databaseNames = []string{"shop"}
+	serviceName = "test-svc"
+	reportFormat = "json"
+
+	require.NoError(t, runDatabaseRestore())
+
+	var report commandReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, history.StatusSucceeded, report.Status)
+	assert.Equal(t, "daily-2025-06-16", report.Backup)
+	require.Len(t, report.Runs, 1)
+	assert.Equal(t, "shop", report.Runs[0].Database)
+	assert.Equal(t, "mock-restore-1", report.Runs[0].Phases[0].Job)
+}
+
+func TestRunDatabaseRestore_ReportFile(t *testing.T) {
+	resetVars()
+	engine.RegisterEngine(&mockReportingEngine{mockEngine{returnErr: errors.New("restore failed: password=hunter2")}})
+
+	exitCode := 0
+	osExit = func(code int) { exitCode = code }
+	defer func() { osExit = os.Exit }()
+
+	engineName = "mock-reporting"
+	backupName = "daily-2025-06-16"
+	databaseNames = []string{"shop", "events"}
+	serviceName = "test-svc"
+	reportFile = filepath.Join(t.TempDir(), "report.yaml")
+
+	require.NoError(t, runDatabaseRestore())
+	assert.Equal(t, 1, exitCode)
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report commandReport
+	require.NoError(t, yaml.UnmarshalStrict(data, &report), "the report is YAML")
+	assert.Equal(t, history.StatusFailed, report.Status)
+	assert.Equal(t, "2 of 2 database restore(s) failed", report.Error)
+	assert.Len(t, report.Runs, 2)
+	assert.NotContains(t, string(data), "hunter2")
+}
+
+func TestRunDatabaseRestore_ReportOnEarlyFailure(t *testing.T) {
+	for name, setup := range map[string]func(){
+		"unresolvable selector": func() { backupSelector = "monthly-*" },
+		"missing verify file":   func() { backupName = "daily-2025-06-16"; verifyFile = "missing.sql" },
+		"invalid target time":   func() { backupName = "daily-2025-06-16"; recoveryTarget = "yesterday" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			resetVars()
+			engine.RegisterEngine(&mockListerEngine{backups: testBackups})
+			osExit = func(int) {}
+			defer func() { osExit = os.Exit }()
+
+			engineName = "mock-lister"
+			databaseNames = []string{"shop"}
+			serviceName = "test-svc"
+			reportFile = filepath.Join(t.TempDir(), "report.json")
+			setup()
+
+			require.NoError(t, runDatabaseRestore())
+
+			data, err := os.ReadFile(reportFile)
+			require.NoError(t, err, "a report is written when the restore cannot start")
+			var report commandReport
+			require.NoError(t, json.Unmarshal(data, &report))
+			assert.Equal(t, history.StatusFailed, report.Status)
+			assert.NotEmpty(t, report.Error)
+			assert.Empty(t, report.Runs)
+		})
+	}
+}
+
+func TestStartReport(t *testing.T) {
+	resetVars()
+
+	report, err := startReport(false)
+	require.NoError(t, err)
+	assert.Nil(t, report, "no report without --report or --report-file")
+
+	reportFormat = "xml"
+	_, err = startReport(false)
+	assert.EqualError(t, err, `invalid --report "xml": must be json or yaml`)
+
+	reportFormat = ""
+	reportFile = "report.yml"
+	report, err = startReport(true)
+	require.NoError(t, err)
+	assert.Equal(t, "yaml", report.encoding)
+	assert.True(t, report.DryRun)
+
+	reportFile = "report.txt"
+	report, err = startReport(false)
+	require.NoError(t, err)
+	assert.Equal(t, "json", report.encoding)
+}
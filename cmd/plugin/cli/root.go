@@ -1,22 +1,21 @@
 package cli
 
 import (
-	"errors"
 	"fmt"
-	"os"
+	"log/slog"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/tj/go-spin"
 	"github.com/wiremind/kubectl-db-restore/pkg/logger"
-	"github.com/wiremind/kubectl-db-restore/pkg/plugin"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 var (
 	KubernetesConfigFlags *genericclioptions.ConfigFlags
+
+	verbosity int
+	logFormat string
 )
 
 func validateRestoreFlags() error {
@@ -25,107 +24,105 @@ func validateRestoreFlags() error {
 	if engineName == "" {
 		missing = append(missing, "--engine")
 	}
-	if backupName == "" {
-		missing = append(missing, "--backup-name")
+	if backupName == "" && backupSelector == "" && backupBefore == "" {
+		missing = append(missing, "--backup-name (or --backup/--backup-before)")
 	}
-	if databaseName == "" {
-		missing = append(missing, "--database")
+	// Physical and operator restores bring back the whole server, not one database.
+	if len(databaseNames) == 0 && databasesFile == "" && restoreMode != "physical" && restoreMode != "operator" {
+		missing = append(missing, "--database (or --databases-file)")
 	}
-	if serviceName == "" {
-		missing = append(missing, "--service-name")
+	if serviceName == "" && chiName == "" {
+		missing = append(missing, "--service-name (or --chi)")
 	}
 
 	if len(missing) > 0 {
 		return fmt.Errorf("missing required flag(s) to run restore job: %s", strings.Join(missing, ", "))
 	}
+	if backupName != "" && (backupSelector != "" || backupBefore != "") {
+		return fmt.Errorf("--backup-name names an exact backup and cannot be combined with --backup or --backup-before")
+	}
+	if restoreMode == "physical" && physicalTool == "" {
+		return fmt.Errorf("--mode physical requires --physical-tool (wal-g or pgbackrest)")
+	}
+	if restoreMode == "operator" && sourceCluster == "" {
+		return fmt.Errorf("--mode operator requires --cluster, the operator cluster to restore")
+	}
+	if err := validateCHIFlags(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateCHIFlags checks the Altinity operator flags, shared with list-backups.
+func validateCHIFlags() error {
+	if chiName != "" && engineName != "clickhouse" {
+		return fmt.Errorf("--chi is only supported by the clickhouse engine")
+	}
+	if chiCluster != "" && chiName == "" {
+		return fmt.Errorf("--chi-cluster requires --chi")
+	}
 	return nil
 }
 
 func RootCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:           "kubectl-db-restore",
-		Short:         "",
-		Long:          `.`,
+		Use:   "kubectl-db-restore",
+		Short: "Restore databases running in Kubernetes from their backups",
+		Long: `Restore databases running in Kubernetes from their backups, through Kubernetes
+Jobs labelled with the run they belong to.`,
 		SilenceErrors: true,
 		SilenceUsage:  true,
-		PreRun: func(cmd *cobra.Command, args []string) {
-			if err := viper.BindPFlags(cmd.Flags()); err != nil {
-				panic(fmt.Errorf("failed to bind flags: %w", err))
-			}
-		},
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := validateRestoreFlags(); err == nil {
-				return runDatabaseRestore()
-			} else if engineName != "" || backupName != "" || databaseName != "" || serviceName != "" {
-				// Some flags were set, but not all — show helpful error
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := configureLogging(); err != nil {
 				return err
 			}
-			// If no flags and no args, show help
-			if len(os.Args) == 1 {
-				return cmd.Help()
-			}
-			log := logger.NewLogger()
-			log.Info("")
-
-			s := spin.New()
-			finishedCh := make(chan bool, 1)
-			namespaceName := make(chan string, 1)
-			go func() {
-				lastNamespaceName := ""
-				for {
-					select {
-					case <-finishedCh:
-						fmt.Printf("\r")
-						return
-					case n := <-namespaceName:
-						lastNamespaceName = n
-					case <-time.After(time.Millisecond * 100):
-						if lastNamespaceName == "" {
-							fmt.Printf("\r  \033[36mSearching for namespaces\033[m %s", s.Next())
-						} else {
-							fmt.Printf("\r  \033[36mSearching for namespaces\033[m %s (%s)", s.Next(), lastNamespaceName)
-						}
-					}
-				}
-			}()
-			defer func() {
-				finishedCh <- true
-			}()
-
-			if err := plugin.RunPlugin(KubernetesConfigFlags, namespaceName); err != nil {
-				return errors.Unwrap(err)
-			}
-
-			log.Info("")
-
-			return nil
+			return applyProfile(cmd)
 		},
 	}
 
 	cobra.OnInitialize(initConfig)
 
 	KubernetesConfigFlags = genericclioptions.NewConfigFlags(false)
-	KubernetesConfigFlags.AddFlags(cmd.Flags())
+	KubernetesConfigFlags.AddFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "Print debug messages, such as the progress of each Job")
+	cmd.PersistentFlags().StringVar(&logFormat, "log-format", logger.FormatText, "Format of the messages: text, or json for one JSON object per line")
+	cmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file of the profiles (defaults to ~/.config/kubectl-db-restore/config.yaml)")
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 
-	cmd.Flags().StringVar(&engineName, "engine", "", "Database engine (clickhouse, postgres, ...)")
-	cmd.Flags().StringVar(&backupName, "backup-name", "", "Backup name")
-	cmd.Flags().StringVar(&databaseName, "database", "", "Database name")
-	cmd.Flags().StringVar(&serviceName, "service-name", "", "Kubernetes service name for DB")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Dry run")
-	cmd.Flags().StringSliceVar(&secretRefs, "secret-ref", nil, "Secret reference in the format VAR=secretName:key (can be repeated)")
+	cmd.AddCommand(DatabaseCmd())
+	cmd.AddCommand(EnginesCmd())
+	cmd.AddCommand(StatusCmd())
+	cmd.AddCommand(HistoryCmd())
+	cmd.AddCommand(LogsCmd())
+	cmd.AddCommand(ListBackupsCmd())
+	cmd.AddCommand(CloneCmd())
+	cmd.AddCommand(ApplyCmd())
+	cmd.AddCommand(ConfigCmd())
 
 	return cmd
 }
 
 func InitAndExecute() {
 	if err := RootCmd().Execute(); err != nil {
-		fmt.Println(err)
-		osExit(1)
+		logger.Global.Error(err)
+		osExit(exitCode(err))
 	}
 }
 
+// configureLogging applies --log-format and -v to the logger shared by every package.
+func configureLogging() error {
+	if err := logger.Global.SetFormat(logFormat); err != nil {
+		return fmt.Errorf("invalid --log-format: must be %s or %s", logger.FormatText, logger.FormatJSON)
+	}
+	level := slog.LevelInfo
+	if verbosity > 0 {
+		level = slog.LevelDebug
+	}
+	logger.Global.SetLevel(level)
+	return nil
+}
+
 func initConfig() {
 	viper.AutomaticEnv()
 }
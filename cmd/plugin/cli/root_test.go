@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+)
+
+func TestRootCmd_Logging(t *testing.T) {
+	resetVars()
+	engine.RegisterEngine(&mockEngine{})
+
+	var out bytes.Buffer
+	logger.Global.SetOutput(&out)
+	defer func() {
+		logger.Global.SetOutput(os.Stdout)
+		_ = logger.Global.SetFormat(logger.FormatText)
+		logger.Global.SetLevel(slog.LevelInfo)
+	}()
+
+	cmd := RootCmd()
+	cmd.SetArgs([]string{"database", "--log-format", "json", "-v", "--engine", "mock", "--service-name", "svc",
+		"--backup-name", "daily", "--database", "shop"})
+	require.NoError(t, cmd.Execute())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		var entry struct{ Level, Msg string }
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.NotEmpty(t, entry.Msg)
+	}
+	assert.Contains(t, out.String(), `"msg":"Restoring database 'shop' from backup 'daily' using engine 'mock'"`)
+
+	cmd = RootCmd()
+	cmd.SetArgs([]string{"database", "--log-format", "yaml"})
+	assert.EqualError(t, cmd.Execute(), "invalid --log-format: must be text or json")
+}
@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+)
+
+const runTimeFormat = "2006-01-02 15:04:05"
+
+var (
+	runsOutput      string
+	allNamespaces   bool
+	historyDatabase string
+	historyEngine   string
+	historyUser     string
+	historyStatus   string
+	historySince    time.Duration
+	logsPhase       string
+
+	// Swapped in tests to avoid talking to a cluster.
+	listRuns    = engine.ListRuns
+	listRecords = history.List
+	readJobLogs = job.ReadJobLogs
+)
+
+func EnginesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "engines",
+		Short: "List the registered engines and the variables they need",
+		Long: `List the registered engines with, for each restore mode, the variables read
+from the environment or a --secret-ref.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEngines(cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVarP(&runsOutput, "output", "o", "table", "Output format (table, json)")
+
+	return cmd
+}
+
+type engineVars struct {
+	Engine string            `json:"engine"`
+	Modes  []engine.ModeVars `json:"modes"`
+}
+
+func runEngines(out io.Writer) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	engines := []engineVars{}
+	for _, e := range engine.Engines() {
+		modes := []engine.ModeVars{}
+		if lister, ok := e.(engine.VarLister); ok {
+			modes = lister.Vars()
+		}
+		engines = append(engines, engineVars{Engine: e.Name(), Modes: modes})
+	}
+
+	if runsOutput == "json" {
+		return printJSON(out, engines)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ENGINE\tMODE\tREQUIRED\tOPTIONAL")
+	for _, e := range engines {
+		if len(e.Modes) == 0 {
+			_, _ = fmt.Fprintf(w, "%s\t-\t-\t-\n", e.Engine)
+		}
+		for _, m := range e.Modes {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Engine, m.Mode, joinOrDash(m.Required), joinOrDash(m.Optional))
+		}
+	}
+	return w.Flush()
+}
+
+func HistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List who restored what, from which backup, and how it ended",
+		Long: `List the restores recorded in the namespace, newest first. Each run is
+recorded in a ConfigMap of the namespace restored into, which outlives its Jobs.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(cmd.OutOrStdout(), time.Now())
+		},
+	}
+
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "List the restores of every namespace")
+	cmd.Flags().StringVar(&historyDatabase, "database", "", "Only show the restores of this database")
+	cmd.Flags().StringVar(&historyEngine, "engine", "", "Only show the restores of this engine")
+	cmd.Flags().StringVar(&historyUser, "user", "", "Only show the restores started by this user")
+	cmd.Flags().StringVar(&historyStatus, "status", "", "Only show the restores in this state (Running, Succeeded, Failed)")
+	cmd.Flags().DurationVar(&historySince, "since", 0, "Only show the restores started within this duration (e.g. 168h)")
+	cmd.Flags().StringVarP(&runsOutput, "output", "o", "table", "Output format (table, json)")
+
+	return cmd
+}
+
+func runHistory(out io.Writer, now time.Time) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	ns := resolveNamespace()
+	if allNamespaces {
+		ns = ""
+	}
+	records, err := listRecords(KubernetesConfigFlags, ns)
+	if err != nil {
+		return err
+	}
+
+	filtered := []history.Record{}
+	for _, r := range records {
+		if historyDatabase != "" && r.Database != historyDatabase {
+			continue
+		}
+		if historyEngine != "" && r.Engine != historyEngine {
+			continue
+		}
+		if historyUser != "" && r.User != historyUser {
+			continue
+		}
+		if historyStatus != "" && !strings.EqualFold(r.Status, historyStatus) {
+			continue
+		}
+		if historySince > 0 && r.Started.Before(now.Add(-historySince)) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+
+	if runsOutput == "json" {
+		return printJSON(out, filtered)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	header := "RUN ID\tUSER\tOPERATION\tENGINE\tDATABASE\tBACKUP\tTARGET\tSTATUS\tSTARTED\tDURATION"
+	if allNamespaces {
+		header = "NAMESPACE\t" + header
+	}
+	_, _ = fmt.Fprintln(w, header)
+	for _, r := range filtered {
+		if allNamespaces {
+			_, _ = fmt.Fprintf(w, "%s\t", r.Namespace)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.User, r.Operation, r.Engine, orDash(r.Database),
+			orDash(r.Backup), orDash(r.Target), r.Status, r.Started.Local().Format(runTimeFormat), runDuration(r.Started, r.Finished))
+	}
+	return w.Flush()
+}
+
+func StatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status <run-id>",
+		Short: "Show the status of a restore and of each of its Jobs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatus(cmd.OutOrStdout(), args[0])
+		},
+	}
+
+	cmd.Flags().StringVarP(&runsOutput, "output", "o", "table", "Output format (table, json)")
+
+	return cmd
+}
+
+func runStatus(out io.Writer, runID string) error {
+	if err := validateOutput(); err != nil {
+		return err
+	}
+
+	run, err := findRun(runID)
+	if err != nil {
+		return err
+	}
+
+	if runsOutput == "json" {
+		return printJSON(out, run)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Run:\t%s\n", run.ID)
+	_, _ = fmt.Fprintf(w, "Namespace:\t%s\n", run.Namespace)
+	_, _ = fmt.Fprintf(w, "Engine:\t%s\n", run.Engine)
+	_, _ = fmt.Fprintf(w, "Database:\t%s\n", orDash(run.Database))
+	_, _ = fmt.Fprintf(w, "Backup:\t%s\n", orDash(run.Backup))
+	_, _ = fmt.Fprintf(w, "Status:\t%s\n", run.Status)
+	_, _ = fmt.Fprintf(w, "Started:\t%s\n", run.Started.Local().Format(runTimeFormat))
+	_, _ = fmt.Fprintf(w, "Duration:\t%s\n", runDuration(run.Started, run.Finished))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PHASE\tJOB\tSTATUS\tDURATION")
+	for _, j := range run.Jobs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orDash(j.Phase), j.Name, j.Status, runDuration(j.Started, j.Finished))
+	}
+	return w.Flush()
+}
+
+func LogsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <run-id>",
+		Short: "Print the logs of the Jobs of a restore",
+		Long: `Print the logs of every Job of a restore, in the order they ran, each one
+preceded by its phase and name.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLogs(cmd.OutOrStdout(), args[0])
+		},
+	}
+
+	cmd.Flags().StringVar(&logsPhase, "phase", "", "Only print the logs of this phase (e.g. clickhouse-restore)")
+
+	return cmd
+}
+
+func runLogs(out io.Writer, runID string) error {
+	run, err := findRun(runID)
+	if err != nil {
+		return err
+	}
+
+	// Jobs are listed in the order they were created, which is the order they ran.
+	printed := 0
+	for _, j := range run.Jobs {
+		if logsPhase != "" && j.Phase != logsPhase {
+			continue
+		}
+		logs, err := readJobLogs(KubernetesConfigFlags, run.Namespace, j.Name)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "==> %s (%s, %s) <==\n", orDash(j.Phase), j.Name, j.Status)
+		_, _ = fmt.Fprint(out, logs)
+		if logs != "" && !strings.HasSuffix(logs, "\n") {
+			_, _ = fmt.Fprintln(out)
+		}
+		printed++
+	}
+
+	if printed == 0 && logsPhase != "" {
+		phases := []string{}
+		for _, j := range run.Jobs {
+			if !slices.Contains(phases, j.Phase) {
+				phases = append(phases, j.Phase)
+			}
+		}
+		return fmt.Errorf("run %s has no %s phase (phases: %s)", run.ID, logsPhase, strings.Join(phases, ", "))
+	}
+	return nil
+}
+
+// findRun looks the run up in the namespace, then in every namespace since a
+// run ID is enough to tell runs apart.
+func findRun(runID string) (engine.Run, error) {
+	runs, err := listRuns(KubernetesConfigFlags, resolveNamespace(), runID)
+	if err != nil {
+		return engine.Run{}, err
+	}
+	if len(runs) == 0 {
+		if runs, err = listRuns(KubernetesConfigFlags, "", runID); err != nil {
+			return engine.Run{}, err
+		}
+	}
+	if len(runs) == 0 {
+		return engine.Run{}, fmt.Errorf("no Job found for run %s, they may have been deleted (its record is kept, see kubectl db-restore history -A)", runID)
+	}
+	return runs[0], nil
+}
+
+func validateOutput() error {
+	if runsOutput != "table" && runsOutput != "json" {
+		return fmt.Errorf("unsupported output format %q (expected table or json)", runsOutput)
+	}
+	return nil
+}
+
+func printJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runDuration is the time between start and finish, or since start while running.
+func runDuration(started, finished time.Time) string {
+	if started.IsZero() {
+		return "-"
+	}
+	if finished.IsZero() {
+		return time.Since(started).Round(time.Second).String() + " (running)"
+	}
+	return finished.Sub(started).Round(time.Second).String()
+}
+
+func joinOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var testRuns = []engine.Run{
+	{
+		ID: "20250616-083000-a1b2c3", Namespace: "analytics", Engine: "clickhouse", Database: "shop", Backup: "daily-2025-06-16",
+		Status: engine.RunFailed, Started: time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC), Finished: time.Date(2025, 6, 16, 8, 32, 0, 0, time.UTC),
+		Jobs: []engine.RunJob{
+			{Name: "clickhouse-drop-db-x1", Phase: "clickhouse-drop-db", Status: engine.RunSucceeded,
+				Started: time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC), Finished: time.Date(2025, 6, 16, 8, 30, 10, 0, time.UTC)},
+			{Name: "clickhouse-restore-x2", Phase: "clickhouse-restore", Status: engine.RunFailed,
+				Started: time.Date(2025, 6, 16, 8, 30, 10, 0, time.UTC), Finished: time.Date(2025, 6, 16, 8, 32, 0, 0, time.UTC)},
+		},
+	},
+	{
+		ID: "20250615-020000-d4e5f6", Namespace: "analytics", Engine: "postgres", Database: "billing", Backup: "base",
+		Status: engine.RunSucceeded, Started: time.Date(2025, 6, 15, 2, 0, 0, 0, time.UTC), Finished: time.Date(2025, 6, 15, 2, 5, 0, 0, time.UTC),
+	},
+}
+
+type runsCall struct {
+	namespace, runID string
+}
+
+func mockRuns(t *testing.T, runs []engine.Run) *[]runsCall {
+	calls := &[]runsCall{}
+	listRuns = func(_ *genericclioptions.ConfigFlags, namespace, runID string) ([]engine.Run, error) {
+		*calls = append(*calls, runsCall{namespace, runID})
+		found := []engine.Run{}
+		for _, r := range runs {
+			if (namespace == "" || r.Namespace == namespace) && (runID == "" || r.ID == runID) {
+				found = append(found, r)
+			}
+		}
+		return found, nil
+	}
+	t.Cleanup(func() { listRuns = engine.ListRuns })
+	return calls
+}
+
+func resetRunsVars() {
+	resetVars()
+	runsOutput = "table"
+	allNamespaces = false
+	historyDatabase = ""
+	historyEngine = ""
+	historyUser = ""
+	historyStatus = ""
+	historySince = 0
+	logsPhase = ""
+}
+
+func TestRunEngines(t *testing.T) {
+	resetRunsVars()
+
+	var out bytes.Buffer
+	require.NoError(t, runEngines(&out))
+	assert.Contains(t, out.String(), "ENGINE")
+	assert.Regexp(t, `clickhouse\s+logical\s+CLICKHOUSE_USER,CLICKHOUSE_PASSWORD`, out.String())
+	assert.Regexp(t, `postgres\s+physical \(wal-g\)`, out.String())
+
+	runsOutput = "yaml"
+	assert.Error(t, runEngines(&out))
+}
+
+var testRecords = []history.Record{
+	{
+		ID: "20250616-083000-a1b2c3", Operation: "restore", User: "alice", Engine: "clickhouse", Database: "shop",
+		Backup: "daily-2025-06-16", Namespace: "analytics", Target: "clickhouse-service", Status: history.StatusFailed,
+		Started: time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC), Finished: time.Date(2025, 6, 16, 8, 32, 0, 0, time.UTC),
+	},
+	{
+		ID: "20250609-020000-d4e5f6", Operation: "restore", User: "bob", Engine: "postgres", Database: "billing",
+		Backup: "base", Namespace: "billing", Target: "postgres", Status: history.StatusSucceeded,
+		Started: time.Date(2025, 6, 9, 2, 0, 0, 0, time.UTC), Finished: time.Date(2025, 6, 9, 2, 5, 0, 0, time.UTC),
+	},
+}
+
+func TestRunHistory(t *testing.T) {
+	resetRunsVars()
+	namespace = "analytics"
+	var namespaces []string
+	listRecords = func(_ *genericclioptions.ConfigFlags, namespace string) ([]history.Record, error) {
+		namespaces = append(namespaces, namespace)
+		return testRecords, nil
+	}
+	defer func() { listRecords = history.List }()
+	now := time.Date(2025, 6, 16, 12, 0, 0, 0, time.UTC)
+
+	var out bytes.Buffer
+	require.NoError(t, runHistory(&out, now))
+	assert.Regexp(t, `20250616-083000-a1b2c3\s+alice\s+restore\s+clickhouse\s+shop\s+daily-2025-06-16\s+clickhouse-service\s+Failed\s+.*\s+2m0s`, out.String())
+	assert.Contains(t, out.String(), "20250609-020000-d4e5f6")
+
+	out.Reset()
+	allNamespaces = true
+	historyUser = "bob"
+	require.NoError(t, runHistory(&out, now))
+	assert.Equal(t, []string{"analytics", ""}, namespaces)
+	assert.Contains(t, out.String(), "NAMESPACE")
+	assert.NotContains(t, out.String(), "a1b2c3")
+
+	out.Reset()
+	historyUser = ""
+	historyStatus = "failed"
+	historySince = 7 * 24 * time.Hour
+	runsOutput = "json"
+	require.NoError(t, runHistory(&out, now))
+	var records []history.Record
+	require.NoError(t, json.Unmarshal(out.Bytes(), &records))
+	assert.Equal(t, testRecords[:1], records)
+}
+
+func TestRunStatus(t *testing.T) {
+	resetRunsVars()
+	namespace = "default"
+	calls := mockRuns(t, testRuns)
+
+	var out bytes.Buffer
+	require.NoError(t, runStatus(&out, "20250616-083000-a1b2c3"))
+	assert.Equal(t, []runsCall{{"default", "20250616-083000-a1b2c3"}, {"", "20250616-083000-a1b2c3"}}, *calls,
+		"a run outside the namespace is found in the others")
+	assert.Regexp(t, `Status:\s+Failed`, out.String())
+	assert.Regexp(t, `clickhouse-drop-db\s+clickhouse-drop-db-x1\s+Succeeded\s+10s`, out.String())
+	assert.Regexp(t, `clickhouse-restore\s+clickhouse-restore-x2\s+Failed\s+1m50s`, out.String())
+
+	assert.ErrorContains(t, runStatus(&out, "unknown"), "no Job found for run unknown")
+}
+
+func TestRunLogs(t *testing.T) {
+	resetRunsVars()
+	namespace = "analytics"
+	mockRuns(t, testRuns)
+	readJobLogs = func(_ *genericclioptions.ConfigFlags, namespace, jobName string) (string, error) {
+		return "logs of " + namespace + "/" + jobName, nil
+	}
+	defer func() { readJobLogs = job.ReadJobLogs }()
+
+	var out bytes.Buffer
+	require.NoError(t, runLogs(&out, "20250616-083000-a1b2c3"))
+	assert.Equal(t, `==> clickhouse-drop-db (clickhouse-drop-db-x1, Succeeded) <==
+logs of analytics/clickhouse-drop-db-x1
+==> clickhouse-restore (clickhouse-restore-x2, Failed) <==
+logs of analytics/clickhouse-restore-x2
+`, out.String())
+
+	out.Reset()
+	logsPhase = "clickhouse-restore"
+	require.NoError(t, runLogs(&out, "20250616-083000-a1b2c3"))
+	assert.NotContains(t, out.String(), "drop-db")
+
+	logsPhase = "clickhouse-validate"
+	assert.EqualError(t, runLogs(&out, "20250616-083000-a1b2c3"),
+		"run 20250616-083000-a1b2c3 has no clickhouse-validate phase (phases: clickhouse-drop-db, clickhouse-restore)")
+}
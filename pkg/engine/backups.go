@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// BackupInfo describes one backup found in an engine's backup location.
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+	Databases []string  `json:"databases"`
+}
+
+// BackupLister is implemented by engines able to browse their backup location.
+// Only Namespace, ServiceName and SecretKeyRefs of the options are used.
+type BackupLister interface {
+	ListBackups(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions) ([]BackupInfo, error)
+}
+
+// BackupTaker is implemented by engines able to take a backup on demand, to the
+// location their restores and listings read from.
+type BackupTaker interface {
+	TakeBackup(configFlags *genericclioptions.ConfigFlags, backupName, databaseName string, opts RestoreOptions) error
+}
+
+// FormatBytes renders a size in binary units, e.g. "3.0 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// backupRow is one JSONEachRow line printed by a listing Job.
+type backupRow struct {
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	Timestamp int64    `json:"timestamp"`
+	Databases []string `json:"databases"`
+}
+
+// parseBackupRows decodes the JSONEachRow output of a listing Job,
+// newest backup first.
+func parseBackupRows(output string) ([]BackupInfo, error) {
+	backups := []BackupInfo{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var row backupRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, fmt.Errorf("failed to parse backup listing line %q: %w", line, err)
+		}
+
+		info := BackupInfo{
+			Name:      row.Name,
+			Size:      row.Size,
+			Databases: row.Databases,
+		}
+		if row.Timestamp > 0 {
+			info.Timestamp = time.Unix(row.Timestamp, 0).UTC()
+		}
+		backups = append(backups, info)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read backup listing: %w", err)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+	return backups, nil
+}
+
+// BackupSelector picks a backup among the listed ones instead of naming it exactly.
+// The newest backup matching Pattern (a path.Match glob, "latest" for any)
+// and taken strictly before Before (when set) is selected.
+type BackupSelector struct {
+	Pattern string
+	Before  time.Time
+}
+
+func (s BackupSelector) String() string {
+	desc := s.Pattern
+	if desc == "" {
+		desc = "latest"
+	}
+	if !s.Before.IsZero() {
+		desc += " before " + s.Before.Format(time.RFC3339)
+	}
+	return desc
+}
+
+// BackupResolver is implemented by engines whose backup naming needs more than
+// picking the newest listed backup; others are resolved through BackupLister.
+type BackupResolver interface {
+	ResolveBackup(configFlags *genericclioptions.ConfigFlags, selector BackupSelector, opts RestoreOptions) (BackupInfo, error)
+}
+
+// ResolveBackup turns a selector into the concrete backup to restore, as listed,
+// so its size is known without listing the backups again.
+func ResolveBackup(e Engine, configFlags *genericclioptions.ConfigFlags, selector BackupSelector, opts RestoreOptions) (BackupInfo, error) {
+	if resolver, ok := e.(BackupResolver); ok {
+		return resolver.ResolveBackup(configFlags, selector, opts)
+	}
+
+	lister, ok := e.(BackupLister)
+	if !ok {
+		return BackupInfo{}, fmt.Errorf("engine %q cannot resolve backup selectors, use --backup-name", e.Name())
+	}
+
+	backups, err := lister.ListBackups(configFlags, opts)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return SelectBackup(backups, selector)
+}
+
+// SelectBackup returns the newest backup matching the selector.
+func SelectBackup(backups []BackupInfo, selector BackupSelector) (BackupInfo, error) {
+	var selected *BackupInfo
+	for i, b := range backups {
+		if selector.Pattern != "" && selector.Pattern != "latest" {
+			ok, err := path.Match(selector.Pattern, b.Name)
+			if err != nil {
+				return BackupInfo{}, fmt.Errorf("invalid backup pattern %q: %w", selector.Pattern, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if !selector.Before.IsZero() && !b.Timestamp.Before(selector.Before) {
+			continue
+		}
+		if selected == nil || b.Timestamp.After(selected.Timestamp) {
+			selected = &backups[i]
+		}
+	}
+
+	if selected == nil {
+		return BackupInfo{}, fmt.Errorf("no backup matches %q", selector)
+	}
+	return *selected, nil
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackupRows_NewestFirst(t *testing.T) {
+	output := `{"name":"daily-2025-06-15","size":1024,"timestamp":1749945600,"databases":["analytics"]}
+
+{"name":"daily-2025-06-16","size":2048,"timestamp":1750032000,"databases":["analytics","events"]}
+`
+	backups, err := parseBackupRows(output)
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	assert.Equal(t, "daily-2025-06-16", backups[0].Name)
+	assert.Equal(t, int64(2048), backups[0].Size)
+	assert.Equal(t, time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC), backups[0].Timestamp)
+	assert.Equal(t, []string{"analytics", "events"}, backups[0].Databases)
+	assert.Equal(t, "daily-2025-06-15", backups[1].Name)
+}
+
+func TestParseBackupRows_InvalidLine(t *testing.T) {
+	_, err := parseBackupRows("Code: 499. DB::Exception: S3 error")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse backup listing line")
+}
+
+func TestSelectBackup(t *testing.T) {
+	backups := []BackupInfo{
+		{Name: "weekly-2025-06-08", Timestamp: time.Date(2025, 6, 8, 2, 0, 0, 0, time.UTC)},
+		{Name: "daily-2025-06-16", Timestamp: time.Date(2025, 6, 16, 2, 0, 0, 0, time.UTC)},
+		{Name: "daily-2025-06-15", Timestamp: time.Date(2025, 6, 15, 2, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name     string
+		selector BackupSelector
+		want     string
+	}{
+		{"latest", BackupSelector{Pattern: "latest"}, "daily-2025-06-16"},
+		{"empty pattern is latest", BackupSelector{}, "daily-2025-06-16"},
+		{"glob", BackupSelector{Pattern: "weekly-*"}, "weekly-2025-06-08"},
+		{"before", BackupSelector{Before: time.Date(2025, 6, 16, 2, 0, 0, 0, time.UTC)}, "daily-2025-06-15"},
+		{"glob and before", BackupSelector{Pattern: "daily-*", Before: time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectBackup(backups, tt.selector)
+			if tt.want == "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "no backup matches")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+}
+
+func TestResolveBackup_EngineWithoutLister(t *testing.T) {
+	_, err := ResolveBackup(&DummyEngine{}, nil, BackupSelector{Pattern: "latest"}, RestoreOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use --backup-name")
+}
@@ -3,70 +3,86 @@ package engine
 import (
 	"fmt"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/wiremind/kubectl-db-restore/pkg/job"
-	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
 	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
+const clickhouseImage = "clickhouse/clickhouse-server:25.5-alpine"
+
+var clickhouseRequiredVars = []string{
+	"CLICKHOUSE_USER",
+	"CLICKHOUSE_PASSWORD",
+	"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+}
+
 type ClickhouseEngine struct{}
 
 func (c *ClickhouseEngine) Name() string {
 	return "clickhouse"
 }
 
+func (c *ClickhouseEngine) Vars() []ModeVars {
+	return []ModeVars{{Mode: "logical", Required: clickhouseRequiredVars}}
+}
+
 func (c *ClickhouseEngine) Restore(configFlags *genericclioptions.ConfigFlags, backupName, databaseName string, opts RestoreOptions) error {
-	requiredVars := []string{
-		"CLICKHOUSE_USER",
-		"CLICKHOUSE_PASSWORD",
-		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP",
-		"AWS_ACCESS_KEY_ID",
-		"AWS_SECRET_ACCESS_KEY",
+	if opts.Mode != "" && opts.Mode != "logical" {
+		return fmt.Errorf("unsupported clickhouse restore mode %q", opts.Mode)
+	}
+
+	requiredVars := clickhouseRequiredVars
+
+	target, err := resolveClickhouseTarget(opts.targetFlags(configFlags), opts)
+	if err != nil {
+		return err
 	}
 
 	// Load secrets
-	resolvedVars, err := k8screds.LoadSecretsVars(configFlags, opts.Namespace, opts.SecretKeyRefs, requiredVars)
+	resolvedVars, err := loadClickhouseVars(opts.targetFlags(configFlags), opts, target)
 	if err != nil {
-		return fmt.Errorf("failed to load secret vars: %w", err)
+		return err
 	}
 
 	// Convert to environment variables
-	var envSources []job.EnvVarSource
-	for name, lv := range resolvedVars {
-		env := job.EnvVarSource{Name: name}
-		if lv.FromSecretRef != nil {
-			env.SecretRef = lv.FromSecretRef
-		} else if lv.FromEnv != nil {
-			env.Value = lv.FromEnv
-		}
-		envSources = append(envSources, env)
+	envSources := toEnvSources(resolvedVars)
+	meta := newRunMetadata(c.Name(), databaseName, backupName)
+	phases := clickhousePhases(backupName, databaseName, target)
+	if opts.Validation != nil {
+		phases = append(phases, clickhouseValidationPhase(meta, target, opts, envSources))
+	}
+	if len(opts.MaskingRules) > 0 {
+		phases = append(phases, clickhouseMaskingPhase(databaseName, target, opts.MaskingRules))
 	}
+	if len(opts.Checks) > 0 {
+		phases = append(phases, verificationPhase(meta, target.Image, "", fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" --database %s --format TSVRaw`, target.Host, databaseName), opts, envSources))
+	}
+	if opts.PreserveGrants {
+		phases = withGrantPhases(phases, meta, opts, envSources, clickhouseGrantCapture(databaseName, target))
+	}
+	phases = withHookPhases(phases, opts.Hooks)
 
 	if opts.DryRun {
 		logger.Global.Info("🔍 [Dry Run] Initiating validation for restore process...")
 		logger.Global.Info("[Dry Run] Target database: '%s'", databaseName)
-		logger.Global.Info("[Dry Run] Backup source: '%s'", backupName)
-		logger.Global.Info("[Dry Run] Service name (ClickHouse host): '%s'", opts.ServiceName)
-		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
-		logger.Global.Info("[Dry Run] Validated secret keys: %v", requiredVars)
-
-		for _, env := range envSources {
-			switch {
-			case env.SecretRef != nil:
-				logger.Global.Info("[Dry Run] Would load secret for var: %s", env.Name)
-			case env.Value != nil:
-				logger.Global.Info("[Dry Run] Would use env var '%s' with direct value (masked)", env.Name)
-			default:
-				logger.Global.Info("[Dry Run] ⚠️ Missing or unresolved value for env var: %s", env.Name)
+		logger.Global.Info("[Dry Run] Backup source: '%s/%s'", os.Getenv("CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP"), backupName)
+		logger.Global.Info("[Dry Run] Service name (ClickHouse host): '%s'", target.Host)
+		if opts.CHI != "" {
+			logger.Global.Info("[Dry Run] ClickHouseInstallation: '%s' (cluster '%s')", opts.CHI, target.Cluster)
+			if target.User != "" {
+				logger.Global.Info("[Dry Run] Credentials of CHI user '%s'", target.User)
 			}
 		}
+		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
+		logger.Global.Info("[Dry Run] Validated secret keys: %v", requiredVars)
 
-		logger.Global.Info("[Dry Run] Would create 3 sequential Kubernetes jobs:")
-		logger.Global.Info("  - 🗑️ Job: Drop database '%s' (if it exists)", databaseName)
-		logger.Global.Info("  - 🏗️ Job: Create new database '%s'", databaseName)
-		logger.Global.Info("  - 📦 Job: Restore database '%s' from S3 path '%s/%s'", databaseName, os.Getenv("CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP"), backupName)
+		logDryRunPlan(configFlags, opts, meta, envSources, phases)
 
 		logger.Global.Info("✅ [Dry Run] Validation completed successfully. No changes were made.")
 		return nil
@@ -74,61 +90,240 @@ func (c *ClickhouseEngine) Restore(configFlags *genericclioptions.ConfigFlags, b
 
 	logger.Global.Info("🚀 Starting ClickHouse restore sequence for database: %s", databaseName)
 
-	timestamp := time.Now().Unix()
+	if err := runPhases(opts.targetFlags(configFlags), opts, meta, envSources, phases); err != nil {
+		return err
+	}
+
+	logger.Global.Info("🎉 All jobs for ClickHouse restore sequence completed successfully!")
+	return nil
+}
+
+// TakeBackup backs the database up to CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/<backupName>,
+// where Restore and ListBackups look for backups.
+func (c *ClickhouseEngine) TakeBackup(configFlags *genericclioptions.ConfigFlags, backupName, databaseName string, opts RestoreOptions) error {
+	target, err := resolveClickhouseTarget(opts.targetFlags(configFlags), opts)
+	if err != nil {
+		return err
+	}
 
-	// SQL job phases
-	jobs := []struct {
-		Name           string
-		Script         string
-		SuccessMessage string
-		FailureHeader  string
-	}{
+	resolvedVars, err := loadClickhouseVars(opts.targetFlags(configFlags), opts, target)
+	if err != nil {
+		return err
+	}
+
+	envSources := toEnvSources(resolvedVars)
+	meta := newRunMetadata(c.Name(), databaseName, backupName)
+	meta.Operation = "backup"
+	phases := []phase{
 		{
-			Name: "clickhouse-drop-db",
+			Name:  "clickhouse-backup",
+			Image: target.Image,
 			Script: fmt.Sprintf(`clickhouse-client --host %s \
 --user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
---query "DROP DATABASE IF EXISTS %s ON CLUSTER default SYNC"`, opts.ServiceName, databaseName),
+--query "BACKUP DATABASE %s TO S3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/%s', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY')"`,
+				target.Host, databaseName, backupName),
+			Description:    fmt.Sprintf("📸 Job: Back up database '%s' to backup '%s'", databaseName, backupName),
+			SuccessMessage: fmt.Sprintf("📸 Successfully backed up database '%s' to '%s'", databaseName, backupName),
+			FailureHeader:  "💥 ClickHouse backup job failed",
+		},
+	}
+
+	if opts.DryRun {
+		logger.Global.Info("🔍 [Dry Run] Initiating validation for backup process...")
+		logger.Global.Info("[Dry Run] Source database: '%s'", databaseName)
+		logger.Global.Info("[Dry Run] Backup destination: '%s/%s'", os.Getenv("CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP"), backupName)
+		logger.Global.Info("[Dry Run] Service name (ClickHouse host): '%s'", target.Host)
+		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
+
+		logDryRunPlan(configFlags, opts, meta, envSources, phases)
+		return nil
+	}
+
+	logger.Global.Info("📸 Backing up ClickHouse database '%s' to '%s'", databaseName, backupName)
+	return runPhases(opts.targetFlags(configFlags), opts, meta, envSources, phases)
+}
+
+// ListBackups reads the .backup metadata file of every backup directly under
+// CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP, through the server's s3() table function.
+func (c *ClickhouseEngine) ListBackups(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions) ([]BackupInfo, error) {
+	target, err := resolveClickhouseTarget(configFlags, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedVars, err := loadClickhouseVars(configFlags, opts, target)
+	if err != nil {
+		return nil, err
+	}
+
+	jobSpec := job.JobSpec{
+		Namespace: opts.Namespace,
+		JobName:   jobName("clickhouse-list-backups"),
+		Image:     target.Image,
+		Command:   []string{"/bin/sh"},
+		Args: []string{"-c", fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--format JSONEachRow --output_format_json_quote_64bit_integers 0 \
+--query "SELECT
+    arrayElement(splitByChar('/', _path), -2) AS name,
+    arraySum(arrayMap(s -> toInt64(s), extractAll(raw_blob, '<size>([0-9]+)</size>'))) AS size,
+    toUnixTimestamp(parseDateTimeBestEffortOrZero(extract(raw_blob, '<timestamp>([^<]+)</timestamp>'))) AS timestamp,
+    arrayDistinct(extractAll(raw_blob, '<name>metadata/([^/<]+)[.]sql</name>')) AS databases
+FROM s3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/*/.backup', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY', 'RawBLOB')"`,
+			target.Host)},
+		EnvVars:           toEnvSources(resolvedVars),
+		EnvFrom:           opts.SecretEnvFrom,
+		Labels:            runMetadata{Engine: c.Name()}.labels(),
+		JobSuccessMessage: "📚 Backup listing completed",
+		JobFailureHeader:  "💥 Failed to list ClickHouse backups",
+		Overrides:         opts.JobOverrides,
+		Ephemeral:         true,
+	}
+
+	output, err := createJobForOutput(configFlags, jobSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run backup listing job: %w", err)
+	}
+
+	return parseBackupRows(output)
+}
+
+// clickhousePhases returns the SQL jobs of a restore, in execution order.
+// The preflight comes first: it reads the backup's .backup metadata file from S3
+// through the server, so a wrong backup name fails before anything is dropped.
+func clickhousePhases(backupName, databaseName string, target clickhouseTarget) []phase {
+	return []phase{
+		{
+			Name:  "clickhouse-preflight",
+			Image: target.Image,
+			Script: fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--query "SELECT throwIf(length(raw_blob) = 0, 'backup metadata is empty') FROM s3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/%s/.backup', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY', 'RawBLOB')"`,
+				target.Host, backupName),
+			Description:    fmt.Sprintf("🔎 Job: Check backup '%s' exists and is readable", backupName),
+			SuccessMessage: fmt.Sprintf("🔎 Backup '%s' found and readable", backupName),
+			FailureHeader:  "🚫 Backup not found or unreadable, nothing was dropped",
+		},
+		{
+			Name:  "clickhouse-drop-db",
+			Image: target.Image,
+			Script: fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--query "DROP DATABASE IF EXISTS %s ON CLUSTER %s SYNC"`, target.Host, databaseName, target.Cluster),
+			Description:    fmt.Sprintf("🗑️ Job: Drop database '%s' (if it exists)", databaseName),
 			SuccessMessage: fmt.Sprintf("🗑️ Successfully dropped database '%s' (if it existed)", databaseName),
 			FailureHeader:  "🛑 Failed to drop existing database",
 		},
 		{
-			Name: "clickhouse-create-db",
+			Name:  "clickhouse-create-db",
+			Image: target.Image,
 			Script: fmt.Sprintf(`clickhouse-client --host %s \
 --user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
---query "CREATE DATABASE %s ON CLUSTER default"`, opts.ServiceName, databaseName),
+--query "CREATE DATABASE %s ON CLUSTER %s"`, target.Host, databaseName, target.Cluster),
+			Description:    fmt.Sprintf("🏗️ Job: Create new database '%s'", databaseName),
 			SuccessMessage: fmt.Sprintf("🏗️ Successfully created database '%s'", databaseName),
 			FailureHeader:  "❌ Failed to create new database",
 		},
 		{
-			Name: "clickhouse-restore",
-			Script: fmt.Sprintf(`clickhouse-client --host %s \
---user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
---query "RESTORE DATABASE %s FROM S3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/%s', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY')"`,
-				opts.ServiceName, databaseName, backupName),
+			Name:           "clickhouse-restore",
+			Image:          target.Image,
+			Script:         clickhouseRestoreScript(backupName, databaseName, target),
+			Watch:          newClickhouseRestoreProgress(databaseName).watch,
+			Description:    fmt.Sprintf("📦 Job: Restore database '%s' from backup '%s'", databaseName, backupName),
 			SuccessMessage: fmt.Sprintf("✅ Successfully restored database '%s' from backup '%s'", databaseName, backupName),
 			FailureHeader:  "💣 ClickHouse restore job failed",
 		},
 	}
+}
 
-	for i, jobCfg := range jobs {
-		jobSpec := job.JobSpec{
-			Namespace:         opts.Namespace,
-			JobName:           fmt.Sprintf("%s-%d", jobCfg.Name, timestamp+int64(i)),
-			Image:             "clickhouse/clickhouse-server:25.5-alpine",
-			Command:           []string{"/bin/sh"},
-			Args:              []string{"-c", jobCfg.Script},
-			EnvVars:           envSources,
-			JobSuccessMessage: jobCfg.SuccessMessage,
-			JobFailureHeader:  jobCfg.FailureHeader,
-		}
+// clickhouseValidationPhase compares the restored tables with the backup. Their row
+// counts and bytes come from system.parts of one replica per shard; the expected
+// bytes are the sizes of the table's data files listed in the backup metadata,
+// unless a manifest provides the expectations. The metadata has no row counts:
+// rows are only compared against a manifest.
+func clickhouseValidationPhase(meta runMetadata, target clickhouseTarget, opts RestoreOptions, envSources []job.EnvVarSource) phase {
+	backupName, databaseName := meta.Backup, meta.Database
+	validation := *opts.Validation
 
-		if err := job.CreateJob(configFlags, jobSpec); err != nil {
-			return fmt.Errorf("failed to create %s job: %w", jobCfg.Name, err)
-		}
+	query := fmt.Sprintf(`SELECT 'restored' AS source, t.name AS table, toInt64(sum(p.rows)) AS rows, toInt64(sum(p.bytes_on_disk)) AS bytes
+FROM system.tables AS t
+LEFT JOIN (
+    SELECT table, rows, bytes_on_disk FROM cluster('%[2]s', system.parts)
+    WHERE database = '%[1]s' AND active
+) AS p ON p.table = t.name
+WHERE t.database = '%[1]s' AND t.engine LIKE '%%MergeTree'
+GROUP BY t.name;
+`, databaseName, target.Cluster)
+	if validation.Manifest == nil {
+		query += fmt.Sprintf(`SELECT 'backup' AS source, m[1] AS table, CAST(NULL, 'Nullable(Int64)') AS rows, toInt64(sum(toInt64(m[2]))) AS bytes
+FROM s3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/%[1]s/.backup', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY', 'RawBLOB')
+ARRAY JOIN extractAllGroupsVertical(raw_blob, '<name>(?:shards/[^/<]+/replicas/[^/<]+/)?data/%[2]s/([^/<]+)/[^<]*</name>[[:space:]]*<size>([0-9]+)</size>') AS m
+GROUP BY table;
+`, backupName, databaseName)
 	}
 
-	logger.Global.Info("🎉 All jobs for ClickHouse restore sequence completed successfully!")
-	return nil
+	labels := meta.labels()
+	labels[LabelPrefix+"phase"] = "clickhouse-validate"
+
+	source := "backup metadata"
+	if validation.Manifest != nil {
+		source = "manifest"
+	}
+
+	return phase{
+		Name:        "clickhouse-validate",
+		Description: fmt.Sprintf("🧮 Job: Compare the tables of '%s' with the %s (tolerance %.1f%%)", databaseName, source, validation.Tolerance*100),
+		Action: func(configFlags *genericclioptions.ConfigFlags, track jobTracker) error {
+			spec := job.JobSpec{
+				Namespace: opts.Namespace,
+				JobName:   jobName("clickhouse-validate"),
+				Image:     target.Image,
+				Command:   []string{"/bin/sh"},
+				Args: []string{"-c", fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--multiquery --format JSONEachRow --output_format_json_quote_64bit_integers 0 <<SQL
+%sSQL`, target.Host, query)},
+				EnvVars:           envSources,
+				EnvFrom:           opts.SecretEnvFrom,
+				Labels:            labels,
+				Annotations:       meta.annotations(),
+				JobSuccessMessage: "🧮 Table sizes collected",
+				JobFailureHeader:  "💥 Failed to collect the restored table sizes",
+				Overrides:         opts.JobOverrides,
+			}
+			track(&spec)
+			output, err := createJobForOutput(configFlags, spec)
+			if err != nil {
+				return err
+			}
+
+			expected, restored, err := parseStatsRows(output)
+			if err != nil {
+				return err
+			}
+			if validation.Manifest != nil {
+				expected = validation.Manifest.Tables
+			}
+			return checkTableStats(databaseName, expected, restored, validation.Tolerance)
+		},
+	}
+}
+
+// clickhouseMaskingPhase rewrites the masked columns of the restored database.
+// mutations_sync makes the Job wait until every replica applied the mutations.
+func clickhouseMaskingPhase(databaseName string, target clickhouseTarget, rules []masking.Rule) phase {
+	return phase{
+		Name:  "clickhouse-mask",
+		Image: target.Image,
+		Script: fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--multiquery --mutations_sync 2 <<'SQL'
+%s
+SQL`, target.Host, strings.Join(masking.ClickhouseStatements(databaseName, target.Cluster, rules), "\n")),
+		Description:    fmt.Sprintf("🎭 Job: Mask %d column(s) of database '%s'", len(rules), databaseName),
+		SuccessMessage: fmt.Sprintf("🎭 Successfully masked database '%s'", databaseName),
+		FailureHeader:  "🚨 Masking failed, the restored database may still contain unmasked data",
+	}
 }
 
 func init() {
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var chiGVR = schema.GroupVersionResource{Group: "clickhouse.altinity.com", Version: "v1", Resource: "clickhouseinstallations"}
+
+// defaultClickhouseCluster is the ON CLUSTER target when no CHI says otherwise.
+const defaultClickhouseCluster = "default"
+
+// clickhouseTarget is where, and as whom, the restore SQL runs.
+type clickhouseTarget struct {
+	Host    string
+	Cluster string
+	Image   string // client image of the Jobs
+	// Vars holds the credentials read from the CHI, if any.
+	Vars map[string]k8screds.LoadedVar
+	// User is the CHI user the credentials belong to, for display.
+	User string
+}
+
+// resolveClickhouseTarget reads the ClickHouseInstallation named by opts.CHI, if any.
+// Without a CHI, the host is the Service name and ON CLUSTER targets "default".
+// configFlags point at the cluster the Jobs run in: the CHI's endpoint and
+// password Secret are only meaningful there.
+func resolveClickhouseTarget(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions) (clickhouseTarget, error) {
+	if opts.CHI == "" {
+		return clickhouseTarget{Host: opts.ServiceName, Cluster: defaultClickhouseCluster, Image: opts.image(clickhouseImage)}, nil
+	}
+
+	chi, err := getResource(configFlags, chiGVR, opts.Namespace, opts.CHI)
+	if err != nil {
+		return clickhouseTarget{}, fmt.Errorf("failed to read ClickHouseInstallation %q: %w", opts.CHI, err)
+	}
+	return chiTarget(chi, opts)
+}
+
+// chiTarget derives the host, cluster and credentials from a CHI. An explicit
+// --service-name, --chi-cluster or credential --secret-ref takes precedence.
+func chiTarget(chi *unstructured.Unstructured, opts RestoreOptions) (clickhouseTarget, error) {
+	target := clickhouseTarget{Host: opts.ServiceName, Image: opts.image(clickhouseImage)}
+
+	if target.Host == "" {
+		// status.endpoint is the FQDN of the CHI-wide Service the operator created.
+		target.Host, _, _ = unstructured.NestedString(chi.Object, "status", "endpoint")
+	}
+	if target.Host == "" {
+		target.Host = "clickhouse-" + chi.GetName()
+	}
+
+	cluster, err := chiCluster(chi, opts.CHICluster)
+	if err != nil {
+		return clickhouseTarget{}, err
+	}
+	target.Cluster = cluster
+
+	for _, ref := range opts.SecretKeyRefs {
+		if ref.EnvVarName == "CLICKHOUSE_USER" || ref.EnvVarName == "CLICKHOUSE_PASSWORD" {
+			return target, nil
+		}
+	}
+
+	user, password, err := chiCredentials(chi, os.Getenv("CLICKHOUSE_USER"))
+	if err != nil {
+		return clickhouseTarget{}, err
+	}
+	if user != "" {
+		target.User = user
+		target.Vars = map[string]k8screds.LoadedVar{
+			"CLICKHOUSE_USER":     {FromEnv: &user},
+			"CLICKHOUSE_PASSWORD": password,
+		}
+	}
+	return target, nil
+}
+
+// chiCluster returns the requested cluster, or the CHI's only cluster.
+func chiCluster(chi *unstructured.Unstructured, requested string) (string, error) {
+	clusters, _, _ := unstructured.NestedSlice(chi.Object, "spec", "configuration", "clusters")
+
+	names := []string{}
+	for _, c := range clusters {
+		if cluster, ok := c.(map[string]any); ok {
+			if name, ok := cluster["name"].(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	switch {
+	case requested != "":
+		if !slices.Contains(names, requested) {
+			return "", fmt.Errorf("ClickHouseInstallation %q has no cluster %q (clusters: %s)", chi.GetName(), requested, strings.Join(names, ", "))
+		}
+		return requested, nil
+	case len(names) == 1:
+		return names[0], nil
+	case len(names) == 0:
+		return "", fmt.Errorf("ClickHouseInstallation %q defines no cluster", chi.GetName())
+	default:
+		return "", fmt.Errorf("ClickHouseInstallation %q defines several clusters (%s), pick one with --chi-cluster", chi.GetName(), strings.Join(names, ", "))
+	}
+}
+
+// chiCredentials returns the user to connect as and where its password comes from.
+// Only users whose password can be read back are candidates: a plain password,
+// a valueFrom.secretKeyRef or a k8s_secret_password. With no wanted user, the CHI
+// must have exactly one candidate; with none at all, an empty user is returned.
+func chiCredentials(chi *unstructured.Unstructured, wanted string) (string, k8screds.LoadedVar, error) {
+	users, _, _ := unstructured.NestedMap(chi.Object, "spec", "configuration", "users")
+	settings := map[string]any{}
+	flattenSettings("", users, settings)
+
+	passwords := map[string]k8screds.LoadedVar{}
+	for key, value := range settings {
+		user, setting, ok := strings.Cut(key, "/")
+		if !ok {
+			continue
+		}
+		password, err := chiPassword(chi, setting, value)
+		if err != nil {
+			return "", k8screds.LoadedVar{}, fmt.Errorf("user %q of ClickHouseInstallation %q: %w", user, chi.GetName(), err)
+		}
+		if password != nil {
+			passwords[user] = *password
+		}
+	}
+
+	candidates := slices.Collect(maps.Keys(passwords))
+	sort.Strings(candidates)
+
+	if wanted != "" {
+		password, ok := passwords[wanted]
+		if !ok {
+			return "", k8screds.LoadedVar{}, fmt.Errorf("ClickHouseInstallation %q has no user %q with a readable password (users: %s)", chi.GetName(), wanted, strings.Join(candidates, ", "))
+		}
+		return wanted, password, nil
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", k8screds.LoadedVar{}, nil
+	case 1:
+		return candidates[0], passwords[candidates[0]], nil
+	default:
+		return "", k8screds.LoadedVar{}, fmt.Errorf("ClickHouseInstallation %q has several users (%s), set CLICKHOUSE_USER to pick one", chi.GetName(), strings.Join(candidates, ", "))
+	}
+}
+
+// flattenSettings turns nested user settings into the operator's flat
+// "user/setting" form. valueFrom maps are values, not nesting.
+func flattenSettings(prefix string, in map[string]any, out map[string]any) {
+	for key, value := range in {
+		if prefix != "" {
+			key = prefix + "/" + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			if _, isValueFrom := nested["valueFrom"]; !isValueFrom {
+				flattenSettings(key, nested, out)
+				continue
+			}
+		}
+		out[key] = value
+	}
+}
+
+// chiPassword reads one user setting, returning nil when it is not a usable password.
+func chiPassword(chi *unstructured.Unstructured, setting string, value any) (*k8screds.LoadedVar, error) {
+	switch setting {
+	case "password":
+		switch v := value.(type) {
+		case string:
+			return &k8screds.LoadedVar{FromEnv: &v}, nil
+		case map[string]any:
+			name, _, _ := unstructured.NestedString(v, "valueFrom", "secretKeyRef", "name")
+			key, _, _ := unstructured.NestedString(v, "valueFrom", "secretKeyRef", "key")
+			if name == "" || key == "" {
+				return nil, fmt.Errorf("password.valueFrom must be a secretKeyRef with a name and a key")
+			}
+			return &k8screds.LoadedVar{FromSecretRef: &k8screds.SecretKeyRef{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: name, Key: key}}, nil
+		}
+	case "k8s_secret_password":
+		ref, _ := value.(string)
+		// "secret/key", or "namespace/secret/key".
+		parts := strings.Split(ref, "/")
+		if len(parts) == 3 {
+			if parts[0] != chi.GetNamespace() {
+				return nil, fmt.Errorf("password secret %q is outside namespace %q, where the restore Jobs run", ref, chi.GetNamespace())
+			}
+			parts = parts[1:]
+		}
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid k8s_secret_password %q, expected secret/key", ref)
+		}
+		return &k8screds.LoadedVar{FromSecretRef: &k8screds.SecretKeyRef{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: parts[0], Key: parts[1]}}, nil
+	}
+	return nil, nil
+}
+
+// loadSecretsVars is swapped in tests to avoid talking to a cluster.
+var loadSecretsVars = k8screds.LoadSecretsVars
+
+// loadClickhouseVars loads the required variables, except those the target
+// already provides. configFlags point at the cluster the Jobs run in, where the
+// Secrets of opts.SecretEnvFrom are read.
+func loadClickhouseVars(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions, target clickhouseTarget) (map[string]k8screds.LoadedVar, error) {
+	required := []string{}
+	for _, v := range clickhouseRequiredVars {
+		if _, ok := target.Vars[v]; !ok {
+			required = append(required, v)
+		}
+	}
+
+	// The target's credentials may come from the CHI's Secrets: check them
+	// along with the flags' refs.
+	refs := slices.Clone(opts.SecretKeyRefs)
+	for _, name := range slices.Sorted(maps.Keys(target.Vars)) {
+		if ref := target.Vars[name].FromSecretRef; ref != nil {
+			refs = append(refs, *ref)
+		}
+	}
+
+	resolvedVars, err := loadSecretsVars(configFlags, opts.Namespace, refs, opts.SecretEnvFrom, required)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret vars: %w", err)
+	}
+	maps.Copy(resolvedVars, target.Vars)
+	return resolvedVars, nil
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/workload"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func testCHI(users map[string]any, clusters ...string) *unstructured.Unstructured {
+	clusterList := []any{}
+	for _, name := range clusters {
+		clusterList = append(clusterList, map[string]any{"name": name})
+	}
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "clickhouse.altinity.com/v1",
+		"kind":       "ClickHouseInstallation",
+		"metadata":   map[string]any{"name": "analytics", "namespace": "data"},
+		"spec": map[string]any{
+			"configuration": map[string]any{"clusters": clusterList, "users": users},
+		},
+		"status": map[string]any{"endpoint": "clickhouse-analytics.data.svc.cluster.local"},
+	}}
+}
+
+func TestChiTarget_SecretPassword(t *testing.T) {
+	chi := testCHI(map[string]any{
+		"restore/k8s_secret_password": "clickhouse-credentials/restore-password",
+		"restore/networks/ip":         []any{"::/0"},
+		"readonly/profile":            "readonly",
+	}, "events")
+
+	target, err := chiTarget(chi, RestoreOptions{CHI: "analytics"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "clickhouse-analytics.data.svc.cluster.local", target.Host)
+	assert.Equal(t, "events", target.Cluster)
+	assert.Equal(t, "restore", target.User)
+	assert.Equal(t, "restore", *target.Vars["CLICKHOUSE_USER"].FromEnv)
+	assert.Equal(t, &k8screds.SecretKeyRef{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: "clickhouse-credentials", Key: "restore-password"},
+		target.Vars["CLICKHOUSE_PASSWORD"].FromSecretRef)
+}
+
+func TestChiTarget_NestedValueFrom(t *testing.T) {
+	chi := testCHI(map[string]any{
+		"admin": map[string]any{
+			"password": map[string]any{
+				"valueFrom": map[string]any{"secretKeyRef": map[string]any{"name": "admin-secret", "key": "password"}},
+			},
+		},
+	}, "events")
+
+	target, err := chiTarget(chi, RestoreOptions{CHI: "analytics", ServiceName: "chi-analytics-events-0-0"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "chi-analytics-events-0-0", target.Host, "--service-name wins over status.endpoint")
+	assert.Equal(t, "admin-secret", target.Vars["CLICKHOUSE_PASSWORD"].FromSecretRef.SecretName)
+}
+
+func TestChiTarget_UserSelection(t *testing.T) {
+	chi := testCHI(map[string]any{
+		"admin/password":                "plain",
+		"restore/k8s_secret_password":   "data/clickhouse-credentials/restore",
+		"hashed/password_sha256_hex":    "65e84be33532fb784c48129675f9eff3a682b27168c0ea744b2cf58ee02337c5",
+		"elsewhere/k8s_secret_password": "other-ns/secret/key",
+	}, "events")
+
+	_, err := chiTarget(chi, RestoreOptions{CHI: "analytics"})
+	require.Error(t, err)
+
+	t.Setenv("CLICKHOUSE_USER", "admin")
+	delete(chi.Object["spec"].(map[string]any)["configuration"].(map[string]any)["users"].(map[string]any), "elsewhere/k8s_secret_password")
+	target, err := chiTarget(chi, RestoreOptions{CHI: "analytics"})
+	require.NoError(t, err)
+	assert.Equal(t, "plain", *target.Vars["CLICKHOUSE_PASSWORD"].FromEnv)
+
+	t.Setenv("CLICKHOUSE_USER", "hashed")
+	_, err = chiTarget(chi, RestoreOptions{CHI: "analytics"})
+	assert.Error(t, err, "a hashed password cannot be read back")
+}
+
+func TestChiTarget_ExplicitSecretRefWins(t *testing.T) {
+	chi := testCHI(map[string]any{"restore/password": "plain"}, "events")
+
+	target, err := chiTarget(chi, RestoreOptions{
+		CHI:           "analytics",
+		SecretKeyRefs: []k8screds.SecretKeyRef{{EnvVarName: "CLICKHOUSE_USER", SecretName: "ch", Key: "user"}},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, target.Vars)
+}
+
+func TestChiCluster(t *testing.T) {
+	chi := testCHI(nil, "events", "logs")
+
+	_, err := chiCluster(chi, "")
+	assert.ErrorContains(t, err, "--chi-cluster")
+
+	cluster, err := chiCluster(chi, "logs")
+	require.NoError(t, err)
+	assert.Equal(t, "logs", cluster)
+
+	_, err = chiCluster(chi, "metrics")
+	assert.Error(t, err)
+}
+
+func TestClickhouseEngine_Restore_CHI(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	getResource = func(_ *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+		assert.Equal(t, chiGVR, gvr)
+		assert.Equal(t, "data", namespace)
+		assert.Equal(t, "analytics", name)
+		return testCHI(map[string]any{"restore/k8s_secret_password": "clickhouse-credentials/restore"}, "events"), nil
+	}
+	var checked []k8screds.SecretKeyRef
+	loadSecretsVars = func(_ *genericclioptions.ConfigFlags, _ string, refs []k8screds.SecretKeyRef, _ []k8screds.SecretEnvFrom, required []string) (map[string]k8screds.LoadedVar, error) {
+		checked = refs
+		return k8screds.LoadSecretsVarsWithClient(nil, "data", nil, nil, required)
+	}
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() {
+		getResource = workload.GetResource
+		loadSecretsVars = k8screds.LoadSecretsVars
+		createJob = job.CreateJob
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "mydb", RestoreOptions{
+		Namespace: "data",
+		CHI:       "analytics",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []k8screds.SecretKeyRef{{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: "clickhouse-credentials", Key: "restore"}},
+		checked, "the CHI's password Secret is checked like the flags' refs")
+
+	require.Len(t, created, 4)
+	assert.Contains(t, created[1].Args[1], "--host clickhouse-analytics.data.svc.cluster.local")
+	assert.Contains(t, created[1].Args[1], "DROP DATABASE IF EXISTS mydb ON CLUSTER events SYNC")
+	assert.Contains(t, created[2].Args[1], "CREATE DATABASE mydb ON CLUSTER events")
+
+	env := map[string]string{}
+	for _, e := range created[0].EnvVars {
+		if e.SecretRef != nil {
+			env[e.Name] = e.SecretRef.SecretName
+		} else {
+			env[e.Name] = *e.Value
+		}
+	}
+	assert.Equal(t, "restore", env["CLICKHOUSE_USER"])
+	assert.Equal(t, "clickhouse-credentials", env["CLICKHOUSE_PASSWORD"])
+}
+
+func TestClickhouseEngine_Restore_TargetContext(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	prod, staging := "prod", "staging"
+	source := &genericclioptions.ConfigFlags{Context: &prod}
+	target := &genericclioptions.ConfigFlags{Context: &staging}
+
+	getResource = func(configFlags *genericclioptions.ConfigFlags, _ schema.GroupVersionResource, _, _ string) (*unstructured.Unstructured, error) {
+		assert.Same(t, target, configFlags, "the CHI is read where the Jobs run, its endpoint and Secrets are there")
+		return testCHI(map[string]any{"restore/password": "plain"}, "events"), nil
+	}
+	jobs := 0
+	createJob = func(configFlags *genericclioptions.ConfigFlags, _ job.JobSpec) error {
+		assert.Same(t, target, configFlags, "Jobs are created in the target context")
+		jobs++
+		return nil
+	}
+	defer func() {
+		getResource = workload.GetResource
+		createJob = job.CreateJob
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(source, "backup1", "mydb", RestoreOptions{
+		Namespace:         "data",
+		CHI:               "analytics",
+		TargetConfigFlags: target,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, jobs)
+}
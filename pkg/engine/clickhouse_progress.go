@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+)
+
+// progressMarker starts the lines of the restore Job's logs carrying a row of
+// system.backups, so the progress can be told from the client's output.
+const progressMarker = "@@db-restore-progress"
+
+// progressInterval is how often, in seconds, the restore Job polls
+// system.backups, a var for tests.
+var progressInterval = 5
+
+// clickhouseRestoreScript starts the RESTORE asynchronously and polls its row of
+// system.backups until it is over, printing each row after progressMarker. The
+// row is read from every replica of the cluster: the Service may send the
+// polling queries to another server than the one restoring. A RESTORE that
+// fails to start fails the Job, and so does a row missing for a minute: a
+// restarted server forgets its system.backups.
+func clickhouseRestoreScript(backupName, databaseName string, target clickhouseTarget) string {
+	return fmt.Sprintf(`set -e
+ch() { clickhouse-client --host %s --user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" "$@"; }
+started=$(ch --format TSVRaw --query "RESTORE DATABASE %s FROM S3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/%s', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY') ASYNC")
+id=$(printf '%%s\n' "$started" | cut -f1)
+[ -n "$id" ] || { echo "ClickHouse RESTORE returned no id"; exit 1; }
+echo "ClickHouse restore $id started"
+failures=0
+while true; do
+  sleep %d
+  if ! row=$(ch --format TSVRaw --query "SELECT status, files_read, num_files, bytes_read, total_size, replaceAll(error, '\n', ' ') FROM clusterAllReplicas('%s', system.backups) WHERE id = '$id' LIMIT 1") || [ -z "$row" ]; then
+    failures=$((failures + 1))
+    [ "$failures" -lt 12 ] || { echo "Lost track of ClickHouse restore $id"; exit 1; }
+    continue
+  fi
+  failures=0
+  printf '%%s\t%%s\n' '%s' "$row"
+  case "$row" in
+    RESTORED*) exit 0 ;;
+    RESTORE_FAILED*|RESTORE_CANCELLED*) exit 1 ;;
+  esac
+done`, target.Host, databaseName, backupName, progressInterval, target.Cluster, progressMarker)
+}
+
+// backupProgress is one row of system.backups, as printed by the restore Job.
+type backupProgress struct {
+	Status     string
+	FilesRead  int64
+	NumFiles   int64
+	BytesRead  int64
+	TotalBytes int64
+	Error      string
+}
+
+// lastBackupProgress returns the last row printed in the logs.
+func lastBackupProgress(logs string) (backupProgress, bool) {
+	lines := strings.Split(logs, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		rest, ok := strings.CutPrefix(lines[i], progressMarker+"\t")
+		if !ok {
+			continue
+		}
+		fields := strings.SplitN(rest, "\t", 6)
+		if len(fields) < 5 {
+			continue
+		}
+
+		p := backupProgress{Status: fields[0]}
+		numbers := []*int64{&p.FilesRead, &p.NumFiles, &p.BytesRead, &p.TotalBytes}
+		valid := true
+		for j, n := range numbers {
+			v, err := strconv.ParseInt(fields[j+1], 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			*n = v
+		}
+		if !valid {
+			continue
+		}
+		if len(fields) == 6 {
+			p.Error = fields[5]
+		}
+		return p, true
+	}
+	return backupProgress{}, false
+}
+
+// clickhouseRestoreProgress logs the progress of one RESTORE each time its row
+// of system.backups changes.
+type clickhouseRestoreProgress struct {
+	database string
+	now      func() time.Time
+
+	last       backupProgress
+	seen       bool
+	firstTime  time.Time // first row with bytes read, for the ETA
+	firstBytes int64
+}
+
+func newClickhouseRestoreProgress(database string) *clickhouseRestoreProgress {
+	return &clickhouseRestoreProgress{database: database, now: time.Now}
+}
+
+// watch is the job.JobSpec Watch of the restore Job.
+func (p *clickhouseRestoreProgress) watch(logs string) {
+	row, ok := lastBackupProgress(logs)
+	if !ok || (p.seen && row == p.last) {
+		return
+	}
+	p.last, p.seen = row, true
+
+	if row.Status == "RESTORE_FAILED" || row.Status == "RESTORE_CANCELLED" {
+		logger.Global.Warn("💣 RESTORE of '%s' %s: %s", p.database, strings.ToLower(strings.TrimPrefix(row.Status, "RESTORE_")), row.Error)
+		return
+	}
+	logger.Global.Info("%s", p.render(row))
+}
+
+const progressBarWidth = 20
+
+// render draws the row, e.g.
+// "📦 Restoring 'shop' [██████░░░░░░░░░░░░░░]  31% 1.2 GiB / 3.9 GiB, 120/410 files, ETA 4m10s".
+func (p *clickhouseRestoreProgress) render(row backupProgress) string {
+	fraction := 0.0
+	if row.TotalBytes > 0 {
+		fraction = min(float64(row.BytesRead)/float64(row.TotalBytes), 1)
+	}
+	if row.Status == "RESTORED" {
+		fraction = 1
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+
+	line := fmt.Sprintf("📦 Restoring '%s' [%s] %3d%% %s / %s, %d/%d files",
+		p.database, bar, int(fraction*100), FormatBytes(row.BytesRead), FormatBytes(row.TotalBytes), row.FilesRead, row.NumFiles)
+
+	if row.Status == "RESTORED" {
+		return line + ", done"
+	}
+	if eta, ok := p.eta(row); ok {
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	return line
+}
+
+// eta extrapolates the rate observed since the first bytes were read.
+func (p *clickhouseRestoreProgress) eta(row backupProgress) (time.Duration, bool) {
+	now := p.now()
+	if p.firstTime.IsZero() {
+		if row.BytesRead > 0 {
+			p.firstTime, p.firstBytes = now, row.BytesRead
+		}
+		return 0, false
+	}
+
+	read := row.BytesRead - p.firstBytes
+	elapsed := now.Sub(p.firstTime)
+	if read <= 0 || elapsed <= 0 || row.TotalBytes <= row.BytesRead {
+		return 0, false
+	}
+	rate := float64(read) / elapsed.Seconds()
+	return time.Duration(float64(row.TotalBytes-row.BytesRead) / rate * float64(time.Second)), true
+}
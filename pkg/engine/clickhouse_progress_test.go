@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickhouseRestoreScript(t *testing.T) {
+	script := clickhouseRestoreScript("daily-2025-06-16", "shop", clickhouseTarget{Host: "clickhouse-service", Cluster: "main"})
+
+	assert.Contains(t, script, `--query "RESTORE DATABASE shop FROM S3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/daily-2025-06-16', '$AWS_ACCESS_KEY_ID', '$AWS_SECRET_ACCESS_KEY') ASYNC"`)
+	assert.Contains(t, script, `FROM clusterAllReplicas('main', system.backups) WHERE id = '$id'`)
+	assert.Contains(t, script, `printf '%s\t%s\n' '@@db-restore-progress' "$row"`)
+	assert.Contains(t, script, "RESTORED*) exit 0")
+}
+
+// stubClickhouseClient puts a clickhouse-client on the PATH answering the
+// RESTORE with restore and each poll with row, failing on an empty reply.
+const stubClickhouseClient = `#!/bin/sh
+case "$*" in
+  *RESTORE*) [ -n "$STUB_RESTORE" ] || exit 1; printf '%s\n' "$STUB_RESTORE" ;;
+  *) printf '%s' "$STUB_ROW" ;;
+esac
+`
+
+func TestClickhouseRestoreScript_Ends(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	defer func(interval int) { progressInterval = interval }(progressInterval)
+	progressInterval = 0
+
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "clickhouse-client"), []byte(stubClickhouseClient), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	script := clickhouseRestoreScript("daily-2025-06-16", "shop", clickhouseTarget{Host: "clickhouse-service", Cluster: "main"})
+
+	for name, tc := range map[string]struct {
+		restore, row string
+		ok           bool
+		output       string
+	}{
+		"restored":           {"3f2a\tRESTORING", "RESTORED\t410\t410\t4\t4\t", true, "@@db-restore-progress\tRESTORED"},
+		"restore failed":     {"3f2a\tRESTORING", "RESTORE_FAILED\t0\t410\t0\t4\tCode: 598.", false, "RESTORE_FAILED"},
+		"restore not run":    {"", "", false, ""},
+		"no id":              {"\t", "", false, "ClickHouse RESTORE returned no id"},
+		"row lost (restart)": {"3f2a\tRESTORING", "", false, "Lost track of ClickHouse restore 3f2a"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, "sh", "-c", script)
+			cmd.Env = append(os.Environ(), "STUB_RESTORE="+tc.restore, "STUB_ROW="+tc.row)
+			out, err := cmd.CombinedOutput()
+
+			require.NoError(t, ctx.Err(), "the script must end")
+			assert.Equal(t, tc.ok, err == nil, string(out))
+			assert.Contains(t, string(out), tc.output)
+		})
+	}
+}
+
+func TestLastBackupProgress(t *testing.T) {
+	logs := "ClickHouse restore 3f2a started\n" +
+		"@@db-restore-progress\tRESTORING\t10\t410\t1048576\t4194304\t\n" +
+		"@@db-restore-progress\tRESTORING\t120\t410\t2097152\t4194304\t\n" +
+		"@@db-restore-progress\tgarbage\n"
+
+	p, ok := lastBackupProgress(logs)
+	assert.True(t, ok)
+	assert.Equal(t, backupProgress{Status: "RESTORING", FilesRead: 120, NumFiles: 410, BytesRead: 2097152, TotalBytes: 4194304}, p)
+
+	p, ok = lastBackupProgress("@@db-restore-progress\tRESTORE_FAILED\t0\t410\t0\t4194304\tCode: 598. Backup not found\n")
+	assert.True(t, ok)
+	assert.Equal(t, "Code: 598. Backup not found", p.Error)
+
+	_, ok = lastBackupProgress("ClickHouse restore 3f2a started\n")
+	assert.False(t, ok)
+}
+
+func TestClickhouseRestoreProgress_Render(t *testing.T) {
+	now := time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+	p := newClickhouseRestoreProgress("shop")
+	p.now = func() time.Time { return now }
+
+	row := backupProgress{Status: "RESTORING", FilesRead: 10, NumFiles: 410, BytesRead: 1 << 30, TotalBytes: 4 << 30}
+	assert.Equal(t, "📦 Restoring 'shop' [█████░░░░░░░░░░░░░░░]  25% 1.0 GiB / 4.0 GiB, 10/410 files", p.render(row))
+
+	// 1 GiB more in 10s: the remaining 2 GiB take 20s.
+	now = now.Add(10 * time.Second)
+	row.FilesRead, row.BytesRead = 200, 2<<30
+	assert.Equal(t, "📦 Restoring 'shop' [██████████░░░░░░░░░░]  50% 2.0 GiB / 4.0 GiB, 200/410 files, ETA 20s", p.render(row))
+
+	row.Status, row.FilesRead, row.BytesRead = "RESTORED", 410, 4<<30
+	assert.Equal(t, "📦 Restoring 'shop' [████████████████████] 100% 4.0 GiB / 4.0 GiB, 410/410 files, done", p.render(row))
+}
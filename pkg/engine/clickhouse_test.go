@@ -1,9 +1,15 @@
 package engine
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -36,3 +42,306 @@ func TestClickhouseEngine_Restore_DryRun(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestClickhouseEngine_Restore_PreflightFailureStopsBeforeDrop(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return fmt.Errorf("job '%s' failed", spec.JobName)
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "typo-backup", "mydb", RestoreOptions{
+		ServiceName: "clickhouse-service",
+		Namespace:   "default",
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "clickhouse-preflight")
+	require.Len(t, created, 1)
+	assert.True(t, strings.HasPrefix(created[0].JobName, "clickhouse-preflight-"))
+	assert.Contains(t, created[0].Args[1], "$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/typo-backup/.backup")
+}
+
+func TestClickhousePhases_PreflightRunsFirst(t *testing.T) {
+	phases := clickhousePhases("backup1", "mydb", clickhouseTarget{Host: "clickhouse-service", Cluster: defaultClickhouseCluster})
+
+	names := []string{}
+	for _, p := range phases {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"clickhouse-preflight", "clickhouse-drop-db", "clickhouse-create-db", "clickhouse-restore"}, names)
+}
+
+func TestClickhouseEngine_ListBackups(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created job.JobSpec
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		created = spec
+		return `{"name":"backup1","size":10,"timestamp":1750032000,"databases":["mydb"]}`, nil
+	}
+	defer func() { createJobForOutput = job.CreateJobForOutput }()
+
+	backups, err := (&ClickhouseEngine{}).ListBackups(&genericclioptions.ConfigFlags{}, RestoreOptions{
+		ServiceName: "clickhouse-service",
+		Namespace:   "default",
+	})
+
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "backup1", backups[0].Name)
+	assert.Equal(t, "default", created.Namespace)
+	assert.Contains(t, created.Args[1], "--host clickhouse-service")
+	assert.Contains(t, created.Args[1], "$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/*/.backup")
+}
+
+func TestClickhouseEngine_TakeBackup(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	err := (&ClickhouseEngine{}).TakeBackup(&genericclioptions.ConfigFlags{}, "clone-shop-20250616-020000", "shop", RestoreOptions{
+		ServiceName: "clickhouse-prod",
+		Namespace:   "prod",
+	})
+
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	assert.Equal(t, "prod", created[0].Namespace)
+	assert.Contains(t, created[0].Args[1], "--host clickhouse-prod")
+	assert.Contains(t, created[0].Args[1], "BACKUP DATABASE shop TO S3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/clone-shop-20250616-020000'")
+	assert.Equal(t, "clickhouse-backup", created[0].Labels[LabelPrefix+"phase"])
+	assert.Equal(t, clickhouseImage, created[0].Image)
+}
+
+func TestClickhouseEngine_Restore_Masking(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName:  "clickhouse-service",
+		Namespace:    "preview",
+		MaskingRules: []masking.Rule{{Table: "users", Column: "email", Strategy: masking.FakeEmail}},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, created, 5)
+	assert.True(t, strings.HasPrefix(created[4].JobName, "clickhouse-mask-"))
+	assert.Contains(t, created[4].Args[1], "--mutations_sync 2 <<'SQL'\nALTER TABLE `shop`.`users` ON CLUSTER `default` UPDATE `email` = ")
+}
+
+func TestClickhouseEngine_Restore_ValidationFailure(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	var validationJob job.JobSpec
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		validationJob = spec
+		return `{"source":"restored","table":"events","rows":10,"bytes":400}
+{"source":"backup","table":"events","rows":null,"bytes":1000}
+`, nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName:  "clickhouse-service",
+		Namespace:    "preview",
+		Validation:   &Validation{Tolerance: DefaultValidationTolerance},
+		MaskingRules: []masking.Rule{{Table: "events", Column: "ip", Strategy: masking.Hash}},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 difference(s) beyond the 5.0% tolerance")
+	assert.Len(t, created, 4, "masking does not run after a failed validation")
+	assert.Equal(t, "preview", validationJob.Namespace)
+	assert.Contains(t, validationJob.Args[1], "FROM cluster('default', system.parts)")
+	assert.Contains(t, validationJob.Args[1], "s3('$CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP/backup1/.backup'")
+	assert.Contains(t, validationJob.Args[1], "data/shop/([^/<]+)/")
+}
+
+func TestClickhouseEngine_Restore_ValidationWithManifest(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	createJob = func(_ *genericclioptions.ConfigFlags, _ job.JobSpec) error { return nil }
+	var script string
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		script = spec.Args[1]
+		return `{"source":"restored","table":"events","rows":1000,"bytes":400}`, nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	rows := int64(990)
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName: "clickhouse-service",
+		Namespace:   "preview",
+		Validation: &Validation{
+			Tolerance: 0.02,
+			Manifest:  &ValidationManifest{Tables: []TableStats{{Table: "events", Rows: &rows}}},
+		},
+	})
+
+	require.NoError(t, err)
+	assert.NotContains(t, script, ".backup", "the backup metadata is not read with a manifest")
+}
+
+func TestClickhouseEngine_Restore_Verification(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	createJob = func(_ *genericclioptions.ConfigFlags, _ job.JobSpec) error { return nil }
+	var verification job.JobSpec
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		verification = spec
+		return "@@db-restore-verify 0\n0\n", nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName: "clickhouse-service",
+		Namespace:   "preview",
+		Checks:      []verify.Check{{Name: "orders", Query: "SELECT count() FROM orders", Op: ">", Value: "0"}},
+	})
+
+	require.Error(t, err)
+	assert.True(t, verify.IsFailed(err))
+	assert.Equal(t, "clickhouse-verify", verification.Labels[LabelPrefix+"phase"])
+	assert.Contains(t, verification.Args[1], "--database shop --format TSVRaw <<'DB_RESTORE_VERIFY_SQL'")
+}
+
+func TestClickhouseEngine_Restore_PreserveGrants(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	var snapshot job.JobSpec
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		snapshot = spec
+		require.Len(t, created, 1, "grants are captured after the preflight, before the drop")
+		return `{"user_name":"app","role_name":null,"access_type":"SELECT","database":"shop","table":null,"column":null,"is_partial_revoke":0,"grant_option":0}`, nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName:    "clickhouse-service",
+		Namespace:      "preview",
+		PreserveGrants: true,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "clickhouse-snapshot-grants", snapshot.Labels[LabelPrefix+"phase"])
+	assert.Contains(t, snapshot.Args[1], "WHERE database = 'shop'")
+	require.Len(t, created, 5)
+	assert.True(t, strings.HasPrefix(created[3].JobName, "clickhouse-restore-"))
+	assert.True(t, strings.HasPrefix(created[4].JobName, "clickhouse-replay-grants-"))
+	assert.Equal(t, "preview", created[4].Namespace)
+	assert.Contains(t, created[4].Args[1], "<<'SQL'\nGRANT ON CLUSTER `default` SELECT ON `shop`.* TO `app`;\nSQL")
+}
+
+func TestClickhouseEngine_Restore_PreserveGrantsWithoutGrants(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"CLICKHOUSE_USER":                       "user",
+		"CLICKHOUSE_PASSWORD":                   "pass",
+		"CLICKHOUSE_AWS_S3_ENDPOINT_URL_BACKUP": "http://minio:9000/backups",
+		"AWS_ACCESS_KEY_ID":                     "minio",
+		"AWS_SECRET_ACCESS_KEY":                 "minio123",
+	})
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, _ job.JobSpec) (string, error) { return "", nil }
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	err := (&ClickhouseEngine{}).Restore(&genericclioptions.ConfigFlags{}, "backup1", "shop", RestoreOptions{
+		ServiceName:    "clickhouse-service",
+		PreserveGrants: true,
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, created, 4, "no replay Job without captured grants")
+}
@@ -2,8 +2,15 @@ package engine
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
 	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -12,6 +19,94 @@ type RestoreOptions struct {
 	ServiceName   string
 	DryRun        bool
 	SecretKeyRefs []k8screds.SecretKeyRef
+	// SecretEnvFrom are Secrets loaded whole into the Jobs through envFrom.
+	SecretEnvFrom []k8screds.SecretEnvFrom
+	// Image replaces the engine's default client image in its Jobs.
+	Image string
+
+	// Mode selects an engine-specific restore strategy; empty means the default
+	// logical restore. Postgres also supports "physical" and "operator".
+	Mode string
+	// Physical restores only.
+	PhysicalTool       string    // "wal-g" or "pgbackrest"
+	RecoveryTargetTime time.Time // zero replays all available WAL
+	StatefulSet        string    // defaults to ServiceName
+	// Operator restores only.
+	SourceCluster  string // operator cluster whose backups are restored
+	TargetCluster  string // cluster to create, defaults to <SourceCluster>-restore-<timestamp>
+	RepointService bool   // point ServiceName at the restored cluster once ready
+	// ClusterTimeout bounds the wait for the restored cluster, or for the
+	// StatefulSet of a physical restore, DefaultClusterTimeout when zero.
+	ClusterTimeout time.Duration
+
+	// ClickHouse only: ClickHouseInstallation to read the host, cluster and
+	// credentials from, and which of its clusters to target when it has several.
+	CHI        string
+	CHICluster string
+
+	// TargetConfigFlags, when set, points at the cluster the restore runs in: Jobs
+	// are created and workloads changed there, while the configFlags passed to
+	// Restore are only read from (backups, CHI, operator clusters).
+	TargetConfigFlags *genericclioptions.ConfigFlags
+
+	// MaskingRules are applied to the restored database by a final Job.
+	// Logical restores only.
+	MaskingRules []masking.Rule
+
+	// Validation, when set, compares the restored tables' sizes with the backup
+	// (or a manifest) before any masking. ClickHouse only.
+	Validation *Validation
+
+	// Checks are smoke-test queries run by a final Job. Logical restores only.
+	Checks []verify.Check
+
+	// PreserveGrants snapshots the grants on the target database before it is
+	// dropped and replays them once the data is restored. Logical restores only.
+	PreserveGrants bool
+
+	// JobOverrides customise the pod of every Job created for the restore.
+	JobOverrides *job.Overrides
+
+	// Hooks are Jobs run before the first and after the last restore step.
+	Hooks Hooks
+
+	// Notifications are the webhooks told when the restore starts and ends.
+	Notifications notify.Config
+
+	// Metrics is where the duration and outcome of the restore are exported.
+	Metrics metrics.Config
+	// BackupSize is the size of the restored backup, exported when known.
+	BackupSize int64
+
+	// Progress, when set, is called with the name of each step as it starts,
+	// e.g. to follow several restores running concurrently.
+	Progress func(step string)
+
+	// Report, when set, is called with the report of each run once it has ended.
+	// Restores running concurrently call it concurrently.
+	Report func(RunReport)
+}
+
+// targetFlags returns the flags to create Jobs and change workloads with.
+func (o RestoreOptions) targetFlags(source *genericclioptions.ConfigFlags) *genericclioptions.ConfigFlags {
+	if o.TargetConfigFlags != nil {
+		return o.TargetConfigFlags
+	}
+	return source
+}
+
+// crossContext tells whether the restore runs in another cluster than the one
+// source points at.
+func (o RestoreOptions) crossContext(source *genericclioptions.ConfigFlags) bool {
+	return o.TargetConfigFlags != nil && ContextName(o.TargetConfigFlags) != ContextName(source)
+}
+
+// image returns the client image of the engine's Jobs.
+func (o RestoreOptions) image(fallback string) string {
+	if o.Image != "" {
+		return o.Image
+	}
+	return fallback
 }
 
 type Engine interface {
@@ -19,12 +114,35 @@ type Engine interface {
 	Restore(configFlags *genericclioptions.ConfigFlags, backupName string, databaseName string, opts RestoreOptions) error
 }
 
+// ModeVars are the variables a restore mode reads from the environment or a
+// --secret-ref.
+type ModeVars struct {
+	Mode     string   `json:"mode"`
+	Required []string `json:"required"`
+	Optional []string `json:"optional,omitempty"`
+}
+
+// VarLister is implemented by engines that can tell which variables they need.
+type VarLister interface {
+	Vars() []ModeVars
+}
+
 var registry = map[string]Engine{}
 
 func RegisterEngine(e Engine) {
 	registry[e.Name()] = e
 }
 
+// Engines returns the registered engines, sorted by name.
+func Engines() []Engine {
+	engines := make([]Engine, 0, len(registry))
+	for _, e := range registry {
+		engines = append(engines, e)
+	}
+	sort.Slice(engines, func(i, j int) bool { return engines[i].Name() < engines[j].Name() })
+	return engines
+}
+
 func GetEngine(name string) (Engine, error) {
 	e, ok := registry[name]
 	if !ok {
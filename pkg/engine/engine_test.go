@@ -1,13 +1,24 @@
 package engine
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/events"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
+func TestMain(m *testing.M) {
+	// Restores record their runs and publish Events in the cluster, which tests do not talk to.
+	saveRunRecord = func(*genericclioptions.ConfigFlags, history.Record) error { return nil }
+	emitEvent = func(*genericclioptions.ConfigFlags, events.Event) error { return nil }
+	whoAmI = func(*genericclioptions.ConfigFlags) string { return "tester" }
+	os.Exit(m.Run())
+}
+
 // DummyEngine is a test double for the Engine interface
 type DummyEngine struct {
 	calledRestore bool
@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// grantCapture describes how an engine snapshots and replays the grants of a database.
+type grantCapture struct {
+	Image  string
+	Script string // prints the grants, parsed by Parse
+	Parse  func(output string) ([]string, error)
+	// Replay returns the phase running the captured statements.
+	Replay func(statements []string) phase
+}
+
+// withGrantPhases wraps the logical restore phases (preflight, drop, create,
+// restore, then any validation, masking and verification) with a snapshot of
+// the target database's grants, taken after the preflight so nothing runs
+// against a backup that does not exist, and its replay as the last step, so no
+// grantee reads the data before it is masked. The statements captured by one
+// phase are handed to the other when the plan runs.
+func withGrantPhases(phases []phase, meta runMetadata, opts RestoreOptions, envSources []job.EnvVarSource, capture grantCapture) []phase {
+	// Set by the snapshot's Action, for the replay and the report.
+	var statements []string
+
+	labels := meta.labels()
+	labels[LabelPrefix+"phase"] = meta.Engine + "-snapshot-grants"
+
+	snapshot := phase{
+		Name:        meta.Engine + "-snapshot-grants",
+		Description: fmt.Sprintf("🔐 Job: Capture the grants on '%s' before it is dropped", meta.Database),
+		Action: func(configFlags *genericclioptions.ConfigFlags, track jobTracker) error {
+			spec := job.JobSpec{
+				Namespace:         opts.Namespace,
+				JobName:           jobName(meta.Engine + "-snapshot-grants"),
+				Image:             capture.Image,
+				Command:           []string{"/bin/sh"},
+				Args:              []string{"-c", capture.Script},
+				EnvVars:           envSources,
+				EnvFrom:           opts.SecretEnvFrom,
+				Labels:            labels,
+				Annotations:       meta.annotations(),
+				JobSuccessMessage: "🔐 Grants captured",
+				JobFailureHeader:  "💥 Failed to capture the grants, nothing was dropped",
+				Overrides:         opts.JobOverrides,
+			}
+			track(&spec)
+			output, err := createJobForOutput(configFlags, spec)
+			if err != nil {
+				return err
+			}
+
+			statements, err = capture.Parse(output)
+			if err != nil {
+				return err
+			}
+			if len(statements) == 0 {
+				logger.Global.Info("🔐 No grants on '%s' to preserve", meta.Database)
+				return nil
+			}
+			logger.Global.Instructions("🔐 Captured %d grant statement(s) on '%s':\n%s", len(statements), meta.Database, strings.Join(statements, "\n"))
+			return nil
+		},
+		Report: func(report *PhaseReport) {
+			report.Grants = statements
+		},
+	}
+
+	replay := phase{
+		Name:        meta.Engine + "-replay-grants",
+		Description: fmt.Sprintf("🔐 Job: Replay the captured grants on '%s'", meta.Database),
+		Action: func(configFlags *genericclioptions.ConfigFlags, track jobTracker) error {
+			if len(statements) == 0 {
+				return nil
+			}
+			p := capture.Replay(statements)
+			spec := p.jobSpec(opts, meta, envSources, jobName(p.Name))
+			track(&spec)
+			return createJob(configFlags, spec)
+		},
+	}
+
+	out := append([]phase{phases[0], snapshot}, phases[1:]...)
+	return append(out, replay)
+}
+
+// clickhouseGrant is one row of system.grants, printed as JSONEachRow.
+type clickhouseGrant struct {
+	UserName        *string `json:"user_name"`
+	RoleName        *string `json:"role_name"`
+	AccessType      string  `json:"access_type"`
+	Database        string  `json:"database"`
+	Table           *string `json:"table"`
+	Column          *string `json:"column"`
+	IsPartialRevoke int     `json:"is_partial_revoke"`
+	GrantOption     int     `json:"grant_option"`
+}
+
+// clickhouseGrantCapture reads the grants on the database from system.grants.
+// Users and roles defined in users.xml are skipped: their grants live in the
+// server configuration and cannot be changed with SQL.
+func clickhouseGrantCapture(databaseName string, target clickhouseTarget) grantCapture {
+	return grantCapture{
+		Image: target.Image,
+		Script: fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--format JSONEachRow --query "SELECT user_name, role_name, access_type, database, table, column,
+    toUInt8(is_partial_revoke) AS is_partial_revoke, toUInt8(grant_option) AS grant_option
+FROM system.grants
+WHERE database = '%s'
+  AND (user_name IN (SELECT name FROM system.users WHERE storage != 'users_xml')
+    OR role_name IN (SELECT name FROM system.roles WHERE storage != 'users_xml'))"`, target.Host, databaseName),
+		Parse: func(output string) ([]string, error) {
+			return parseClickhouseGrants(output, target.Cluster)
+		},
+		Replay: func(statements []string) phase {
+			return phase{
+				Name:  "clickhouse-replay-grants",
+				Image: target.Image,
+				Script: fmt.Sprintf(`clickhouse-client --host %s \
+--user "$CLICKHOUSE_USER" --password "$CLICKHOUSE_PASSWORD" \
+--multiquery <<'SQL'
+%s
+SQL`, target.Host, strings.Join(statements, "\n")),
+				SuccessMessage: fmt.Sprintf("🔐 Successfully replayed %d grant statement(s) on '%s'", len(statements), databaseName),
+				FailureHeader:  "🚨 Failed to replay the grants, users of the restored database may be denied access",
+			}
+		},
+	}
+}
+
+// parseClickhouseGrants turns system.grants rows into GRANT statements, followed
+// by the REVOKE statements of partial revokes, all ON CLUSTER.
+func parseClickhouseGrants(output, cluster string) ([]string, error) {
+	grants, revokes := []string{}, []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var g clickhouseGrant
+		if err := json.Unmarshal([]byte(line), &g); err != nil {
+			return nil, fmt.Errorf("failed to parse grant line %q: %w", line, err)
+		}
+
+		grantee := g.RoleName
+		if g.UserName != nil {
+			grantee = g.UserName
+		}
+		if grantee == nil {
+			return nil, fmt.Errorf("grant line %q has no user nor role", line)
+		}
+
+		privilege := g.AccessType
+		if g.Column != nil {
+			privilege += "(" + clickhouseIdentifier(*g.Column) + ")"
+		}
+		object := clickhouseIdentifier(g.Database) + ".*"
+		if g.Table != nil {
+			object = clickhouseIdentifier(g.Database) + "." + clickhouseIdentifier(*g.Table)
+		}
+
+		if g.IsPartialRevoke != 0 {
+			revokes = append(revokes, fmt.Sprintf("REVOKE ON CLUSTER %s %s ON %s FROM %s;",
+				clickhouseIdentifier(cluster), privilege, object, clickhouseIdentifier(*grantee)))
+			continue
+		}
+		statement := fmt.Sprintf("GRANT ON CLUSTER %s %s ON %s TO %s",
+			clickhouseIdentifier(cluster), privilege, object, clickhouseIdentifier(*grantee))
+		if g.GrantOption != 0 {
+			statement += " WITH GRANT OPTION"
+		}
+		grants = append(grants, statement+";")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read grants: %w", err)
+	}
+	return append(grants, revokes...), nil
+}
+
+// clickhouseIdentifier quotes a name read from the server.
+func clickhouseIdentifier(name string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+// postgresGrantPrefixes are the ACL statements kept from a schema-only dump.
+var postgresGrantPrefixes = []string{"GRANT ", "REVOKE ", "ALTER DEFAULT PRIVILEGES "}
+
+// postgresGrantCapture keeps the ACL statements of a schema-only pg_dump of the
+// database. --create adds the database-level grants. A database that does not
+// exist yet has no grants.
+func postgresGrantCapture(databaseName, serviceName, image string) grantCapture {
+	return grantCapture{
+		Image: image,
+		Script: postgresScriptHeader + fmt.Sprintf(`if psql --host %[1]s --dbname postgres --tuples-only --no-align \
+  --command "SELECT 1 FROM pg_database WHERE datname = '%[2]s'" | grep -q 1; then
+  pg_dump --host %[1]s --schema-only --create %[2]s | { grep -E '^(GRANT|REVOKE|ALTER DEFAULT PRIVILEGES) ' || true; }
+fi`, serviceName, databaseName),
+		Parse: parsePostgresGrants,
+		Replay: func(statements []string) phase {
+			return phase{
+				Name:  "postgres-replay-grants",
+				Image: image,
+				Script: postgresScriptHeader + fmt.Sprintf(`psql --host %s --dbname %s -v ON_ERROR_STOP=1 --single-transaction <<'SQL'
+%s
+SQL`, serviceName, databaseName, strings.Join(statements, "\n")),
+				SuccessMessage: fmt.Sprintf("🔐 Successfully replayed %d grant statement(s) on '%s'", len(statements), databaseName),
+				FailureHeader:  "🚨 Failed to replay the grants, users of the restored database may be denied access",
+			}
+		},
+	}
+}
+
+func parsePostgresGrants(output string) ([]string, error) {
+	statements := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range postgresGrantPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				statements = append(statements, line)
+				break
+			}
+		}
+	}
+	return statements, nil
+}
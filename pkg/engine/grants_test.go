@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClickhouseGrants(t *testing.T) {
+	output := `{"user_name":"app","role_name":null,"access_type":"SELECT","database":"shop","table":null,"column":null,"is_partial_revoke":0,"grant_option":0}
+{"user_name":"app","role_name":null,"access_type":"SELECT","database":"shop","table":"users","column":"email","is_partial_revoke":1,"grant_option":0}
+{"user_name":null,"role_name":"analyst","access_type":"ALTER UPDATE","database":"shop","table":"orders","column":null,"is_partial_revoke":0,"grant_option":1}
+`
+
+	statements, err := parseClickhouseGrants(output, "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"GRANT ON CLUSTER `main` SELECT ON `shop`.* TO `app`;",
+		"GRANT ON CLUSTER `main` ALTER UPDATE ON `shop`.`orders` TO `analyst` WITH GRANT OPTION;",
+		"REVOKE ON CLUSTER `main` SELECT(`email`) ON `shop`.`users` FROM `app`;",
+	}, statements)
+
+	_, err = parseClickhouseGrants(`{"access_type":"SELECT","database":"shop"}`, "main")
+	assert.ErrorContains(t, err, "no user nor role")
+}
+
+func TestClickhouseIdentifier(t *testing.T) {
+	assert.Equal(t, "`we\\`ird`", clickhouseIdentifier("we`ird"))
+}
+
+func TestParsePostgresGrants(t *testing.T) {
+	output := `CREATE DATABASE shop WITH TEMPLATE = template0 ENCODING = 'UTF8';
+GRANT CONNECT ON DATABASE shop TO reporting;
+REVOKE ALL ON SCHEMA public FROM PUBLIC;
+GRANT SELECT ON TABLE public.orders TO reporting;
+ALTER DEFAULT PRIVILEGES FOR ROLE app IN SCHEMA public GRANT SELECT ON TABLES TO reporting;
+`
+
+	statements, err := parsePostgresGrants(output)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"GRANT CONNECT ON DATABASE shop TO reporting;",
+		"REVOKE ALL ON SCHEMA public FROM PUBLIC;",
+		"GRANT SELECT ON TABLE public.orders TO reporting;",
+		"ALTER DEFAULT PRIVILEGES FOR ROLE app IN SCHEMA public GRANT SELECT ON TABLES TO reporting;",
+	}, statements)
+}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Hook is a Job run around a restore, e.g. to pause the consumers writing to
+// the database. It gets the same environment variables as the restore Jobs.
+type Hook struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Script string `json:"script"`
+}
+
+// Hooks run before the first step of a restore and after its last one. A failing
+// pre-restore hook stops the restore, post-restore hooks only run once it succeeded.
+type Hooks struct {
+	PreRestore  []Hook `json:"preRestore,omitempty"`
+	PostRestore []Hook `json:"postRestore,omitempty"`
+}
+
+// hookName is short enough for the hook's Job name to stay a valid label value.
+var hookName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,28}[a-z0-9])?$`)
+
+func (h Hook) Validate() error {
+	if !hookName.MatchString(h.Name) {
+		return fmt.Errorf("invalid hook name %q, expected at most 30 lowercase alphanumerics or '-'", h.Name)
+	}
+	if h.Image == "" {
+		return fmt.Errorf("hook %q has no image", h.Name)
+	}
+	if h.Script == "" {
+		return fmt.Errorf("hook %q has no script", h.Name)
+	}
+	return nil
+}
+
+// withHookPhases surrounds the restore phases with the hooks' Jobs.
+func withHookPhases(phases []phase, hooks Hooks) []phase {
+	out := []phase{}
+	for _, h := range hooks.PreRestore {
+		out = append(out, h.phase("pre-restore"))
+	}
+	out = append(out, phases...)
+	for _, h := range hooks.PostRestore {
+		out = append(out, h.phase("post-restore"))
+	}
+	return out
+}
+
+func (h Hook) phase(when string) phase {
+	return phase{
+		Name:           fmt.Sprintf("%s-hook-%s", when, h.Name),
+		Image:          h.Image,
+		Script:         h.Script,
+		Description:    fmt.Sprintf("🪝 Job: Run %s hook '%s' (%s)", when, h.Name, h.Image),
+		SuccessMessage: fmt.Sprintf("🪝 Hook '%s' completed", h.Name),
+		FailureHeader:  fmt.Sprintf("💥 %s hook '%s' failed", when, h.Name),
+	}
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestHook_Validate(t *testing.T) {
+	assert.NoError(t, Hook{Name: "pause-consumers", Image: "bitnami/kubectl", Script: "kubectl scale deploy/consumer --replicas 0"}.Validate())
+	assert.ErrorContains(t, Hook{Name: "Pause", Image: "alpine", Script: "true"}.Validate(), "invalid hook name")
+	assert.ErrorContains(t, Hook{Name: "pause", Script: "true"}.Validate(), "has no image")
+	assert.ErrorContains(t, Hook{Name: "pause", Image: "alpine"}.Validate(), "has no script")
+}
+
+func TestPostgresEngine_Restore_HooksAndOverrides(t *testing.T) {
+	setPostgresEnv(t)
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	overrides := &job.Overrides{ServiceAccountName: "db-restore"}
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName:  "postgres-service",
+		Namespace:    "default",
+		JobOverrides: overrides,
+		Hooks: Hooks{
+			PreRestore:  []Hook{{Name: "pause-consumers", Image: "bitnami/kubectl", Script: "kubectl scale deploy/consumer --replicas 0"}},
+			PostRestore: []Hook{{Name: "resume-consumers", Image: "bitnami/kubectl", Script: "kubectl scale deploy/consumer --replicas 3"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 6)
+
+	assert.True(t, strings.HasPrefix(created[0].JobName, "pre-restore-hook-pause-consumers-"), created[0].JobName)
+	assert.True(t, strings.HasPrefix(created[1].JobName, "postgres-preflight-"), created[1].JobName)
+	assert.True(t, strings.HasPrefix(created[5].JobName, "post-restore-hook-resume-consumers-"), created[5].JobName)
+	assert.Equal(t, "kubectl scale deploy/consumer --replicas 0", created[0].Args[1])
+	for _, spec := range created {
+		assert.Same(t, overrides, spec.Overrides, spec.JobName)
+	}
+}
@@ -0,0 +1,297 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// phase is a single step of a restore sequence, executed as one Kubernetes Job,
+// or by calling Action for steps done directly through the API (e.g. scaling).
+type phase struct {
+	Name           string
+	Image          string
+	Script         string
+	Description    string // shown in the dry-run plan
+	SuccessMessage string
+	FailureHeader  string
+
+	// Watch, when set, follows the progress the Job prints in its logs.
+	Watch func(logs string)
+
+	// Extra pod settings, for jobs that work on a database's volume.
+	Volumes         []corev1.Volume
+	VolumeMounts    []corev1.VolumeMount
+	SecurityContext *corev1.PodSecurityContext
+
+	Action func(configFlags *genericclioptions.ConfigFlags, track jobTracker) error
+	// Report, when set, adds what the Action learnt to the step's report.
+	Report func(report *PhaseReport)
+}
+
+// jobTracker registers a Job an Action creates with the run, before it is
+// created: the Job is listed in the run's record and its timing observed, as
+// for the Job of a step that has no Action.
+type jobTracker func(spec *job.JobSpec)
+
+// LabelPrefix namespaces the labels and annotations set on restore Jobs.
+const LabelPrefix = "db-restore.wiremind.io/"
+
+// runMetadata identifies the Jobs created for one restore.
+type runMetadata struct {
+	Engine   string
+	Database string
+	Backup   string // concrete backup name, after any selector was resolved
+	RunID    string // shared by every Job of the run, see NewRunID
+
+	// Recorded in the restore history only.
+	Operation string // "restore" when empty
+	Target    string // what is restored into, the service or CHI when empty
+}
+
+func newRunMetadata(engine, database, backup string) runMetadata {
+	return runMetadata{Engine: engine, Database: database, Backup: backup, RunID: NewRunID()}
+}
+
+// NewRunID returns a run identifier that sorts by time and stays unique when
+// restores start in the same second, e.g. "20250616-083000-3fa9c1".
+func NewRunID() string {
+	return fmt.Sprintf("%s-%06x", time.Now().UTC().Format("20060102-150405"), rand.IntN(1<<24))
+}
+
+func (m runMetadata) labels() map[string]string {
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "kubectl-db-restore",
+	}
+	for key, value := range map[string]string{
+		"engine":   m.Engine,
+		"database": m.Database,
+		"backup":   m.Backup,
+		"run-id":   m.RunID,
+	} {
+		if value != "" {
+			labels[LabelPrefix+key] = labelValue(value)
+		}
+	}
+	return labels
+}
+
+// annotations keep the exact backup name, which may not be a valid label value.
+func (m runMetadata) annotations() map[string]string {
+	return map[string]string{
+		LabelPrefix + "backup": m.Backup,
+	}
+}
+
+// labelValue turns s into a valid label value: at most 63 alphanumerics,
+// '-', '_' or '.', starting and ending with an alphanumeric.
+func labelValue(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' && c != '.' {
+			b[i] = '-'
+		}
+	}
+	if len(b) > 63 {
+		b = b[:63]
+	}
+	return strings.Trim(string(b), "-_.")
+}
+
+var (
+	jobSuffixMu   sync.Mutex
+	lastJobSuffix int64
+)
+
+// jobName suffixes name with a Unix timestamp, never the same one twice in a
+// process, so restores running concurrently do not create Jobs of the same name.
+func jobName(name string) string {
+	jobSuffixMu.Lock()
+	defer jobSuffixMu.Unlock()
+
+	suffix := time.Now().Unix()
+	if suffix <= lastJobSuffix {
+		suffix = lastJobSuffix + 1
+	}
+	lastJobSuffix = suffix
+	return fmt.Sprintf("%s-%d", name, suffix)
+}
+
+// createJob and createJobForOutput are swapped in tests to avoid talking to a cluster.
+var (
+	createJob          = job.CreateJob
+	createJobForOutput = job.CreateJobForOutput
+)
+
+// toEnvSources converts resolved variables into Job environment variables,
+// sorted by name so the generated Job specs are stable. The variables of the
+// Secrets loaded whole come with the Jobs' envFrom instead.
+func toEnvSources(resolvedVars map[string]k8screds.LoadedVar) []job.EnvVarSource {
+	var envSources []job.EnvVarSource
+	for name, lv := range resolvedVars {
+		if lv.FromSecretEnv != nil {
+			continue
+		}
+		env := job.EnvVarSource{Name: name}
+		if lv.FromSecretRef != nil {
+			env.SecretRef = lv.FromSecretRef
+		} else if lv.FromEnv != nil {
+			env.Value = lv.FromEnv
+		}
+		envSources = append(envSources, env)
+	}
+	sort.Slice(envSources, func(i, j int) bool { return envSources[i].Name < envSources[j].Name })
+	return envSources
+}
+
+// logDryRunPlan prints where the restore would run, how each variable would be
+// provided and the Jobs that would be created.
+func logDryRunPlan(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions, meta runMetadata, envSources []job.EnvVarSource, phases []phase) {
+	if opts.TargetConfigFlags != nil {
+		logger.Global.Info("[Dry Run] Source context (read from): '%s'", ContextName(configFlags))
+		logger.Global.Info("[Dry Run] Target context (Jobs created in): '%s'", ContextName(opts.TargetConfigFlags))
+	}
+
+	for _, from := range opts.SecretEnvFrom {
+		if from.Prefix != "" {
+			logger.Global.Info("[Dry Run] Would load every key of secret '%s' as %s<key>", from.SecretName, from.Prefix)
+		} else {
+			logger.Global.Info("[Dry Run] Would load every key of secret '%s'", from.SecretName)
+		}
+	}
+	for _, env := range envSources {
+		switch {
+		case env.SecretRef != nil:
+			logger.Global.Info("[Dry Run] Would load secret for var: %s", env.Name)
+		case env.Value != nil:
+			logger.Global.Info("[Dry Run] Would use env var '%s' with direct value (masked)", env.Name)
+		default:
+			logger.Global.Warn("[Dry Run] ⚠️ Missing or unresolved value for env var: %s", env.Name)
+		}
+	}
+
+	if len(opts.MaskingRules) > 0 {
+		logger.Global.Info("[Dry Run] Masking rules:")
+		for _, r := range opts.MaskingRules {
+			logger.Global.Info("  - %s", r)
+		}
+	}
+
+	logDryRunChecks(opts.Checks)
+
+	logger.Global.Info("[Dry Run] Would run %d sequential steps:", len(phases))
+	for _, p := range phases {
+		logger.Global.Info("  - %s", p.Description)
+	}
+
+	labels := meta.labels()
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	logger.Global.Info("[Dry Run] Jobs would be labelled:")
+	for _, k := range keys {
+		logger.Global.Info("  - %s=%s", k, labels[k])
+	}
+}
+
+// ContextName returns the kubeconfig context the flags point at.
+func ContextName(configFlags *genericclioptions.ConfigFlags) string {
+	if configFlags.Context != nil && *configFlags.Context != "" {
+		return *configFlags.Context
+	}
+	rawConfig, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil || rawConfig.CurrentContext == "" {
+		return "(current context)"
+	}
+	return rawConfig.CurrentContext
+}
+
+// runPhases creates the Jobs one after the other and stops at the first failure,
+// so a failing check never lets a later destructive step run.
+func runPhases(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions, meta runMetadata, envSources []job.EnvVarSource, phases []phase) (err error) {
+	logger.Global.Info("🆔 Run ID: %s (kubectl db-restore status %s)", meta.RunID, meta.RunID)
+
+	record := startRunRecord(configFlags, opts, meta, envSources)
+	next := 0
+	defer func() { record.finish(err, phases[next:]) }()
+
+	for _, p := range phases {
+		next++
+		record.startPhase(p.Name)
+		if opts.Progress != nil {
+			opts.Progress(p.Name)
+		}
+		if p.Action != nil {
+			tracked := false
+			track := func(spec *job.JobSpec) {
+				tracked = true
+				spec.Observe = record.observeJob
+				record.addJob(spec.JobName)
+			}
+			started := time.Now()
+			err := p.Action(configFlags, track)
+			if !tracked {
+				// No Job to read the timing from, the step is timed here.
+				record.observePhase(p.Name, time.Since(started))
+			}
+			if p.Report != nil {
+				p.Report(record.currentPhase())
+			}
+			record.endPhase(err)
+			if err != nil {
+				return fmt.Errorf("failed to run %s: %w", p.Name, err)
+			}
+			record.phaseCompleted(p.Name)
+			continue
+		}
+
+		jobSpec := p.jobSpec(opts, meta, envSources, jobName(p.Name))
+		jobSpec.Observe = record.observeJob
+		record.addJob(jobSpec.JobName)
+		err := createJob(configFlags, jobSpec)
+		record.endPhase(err)
+		if err != nil {
+			return fmt.Errorf("failed to create %s job: %w", p.Name, err)
+		}
+		record.phaseCompleted(p.Name)
+	}
+
+	return nil
+}
+
+// jobSpec returns the Job running the phase's script.
+func (p phase) jobSpec(opts RestoreOptions, meta runMetadata, envSources []job.EnvVarSource, jobName string) job.JobSpec {
+	labels := meta.labels()
+	labels[LabelPrefix+"phase"] = labelValue(p.Name)
+
+	return job.JobSpec{
+		Namespace:         opts.Namespace,
+		JobName:           jobName,
+		Image:             p.Image,
+		Command:           []string{"/bin/sh"},
+		Args:              []string{"-c", p.Script},
+		EnvVars:           envSources,
+		EnvFrom:           opts.SecretEnvFrom,
+		Labels:            labels,
+		Annotations:       meta.annotations(),
+		Volumes:           p.Volumes,
+		VolumeMounts:      p.VolumeMounts,
+		SecurityContext:   p.SecurityContext,
+		JobSuccessMessage: p.SuccessMessage,
+		JobFailureHeader:  p.FailureHeader,
+		Overrides:         opts.JobOverrides,
+		Watch:             p.Watch,
+	}
+}
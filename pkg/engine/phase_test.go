@@ -0,0 +1,308 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/events"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestJobName_UniqueAcrossGoroutines(t *testing.T) {
+	var mu sync.Mutex
+	names := map[string]bool{}
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := jobName("clickhouse-drop-db")
+			mu.Lock()
+			defer mu.Unlock()
+			names[name] = true
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, names, 50)
+}
+
+func TestLabelValue(t *testing.T) {
+	assert.Equal(t, "daily-2025-06-16", labelValue("daily-2025-06-16"))
+	assert.Equal(t, "nightly-app.dump", labelValue("nightly/app.dump"))
+	assert.Equal(t, "backup", labelValue("_backup/"))
+	assert.Len(t, labelValue(strings.Repeat("a", 80)), 63)
+}
+
+func TestRunPhases_ReportsProgress(t *testing.T) {
+	var created []string
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec.JobName)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	var steps []string
+	opts := RestoreOptions{Namespace: "preview", Progress: func(step string) { steps = append(steps, step) }}
+	phases := []phase{
+		{Name: "clickhouse-drop-db"},
+		{Name: "check", Action: func(*genericclioptions.ConfigFlags, jobTracker) error { return nil }},
+		{Name: "clickhouse-restore"},
+	}
+
+	require.NoError(t, runPhases(&genericclioptions.ConfigFlags{}, opts, runMetadata{Engine: "clickhouse"}, nil, phases))
+	assert.Equal(t, []string{"clickhouse-drop-db", "check", "clickhouse-restore"}, steps)
+	require.Len(t, created, 2)
+	assert.True(t, strings.HasPrefix(created[1], "clickhouse-restore-"))
+}
+
+func TestRunPhases_RecordsRun(t *testing.T) {
+	var records []history.Record
+	saveRunRecord = func(_ *genericclioptions.ConfigFlags, record history.Record) error {
+		records = append(records, record)
+		return nil
+	}
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		if strings.HasPrefix(spec.JobName, "clickhouse-restore-") {
+			return errors.New("boom")
+		}
+		return nil
+	}
+	defer func() {
+		saveRunRecord = func(*genericclioptions.ConfigFlags, history.Record) error { return nil }
+		createJob = job.CreateJob
+	}()
+
+	context := "staging"
+	opts := RestoreOptions{Namespace: "analytics", CHI: "events"}
+	meta := runMetadata{Engine: "clickhouse", Database: "shop", Backup: "daily", RunID: "20250616-083000-a1b2c3"}
+	phases := []phase{{Name: "clickhouse-drop-db"}, {Name: "clickhouse-restore"}}
+
+	err := runPhases(&genericclioptions.ConfigFlags{Context: &context}, opts, meta, nil, phases)
+	require.EqualError(t, err, "failed to create clickhouse-restore job: boom")
+
+	require.Len(t, records, 4, "saved when started, before each Job and when finished")
+	first, last := records[0], records[3]
+	assert.Equal(t, history.StatusRunning, first.Status)
+	assert.Empty(t, first.Jobs)
+	assert.Equal(t, "20250616-083000-a1b2c3", last.ID)
+	assert.Equal(t, "restore", last.Operation)
+	assert.Equal(t, "tester", last.User)
+	assert.Equal(t, "analytics", last.Namespace)
+	assert.Equal(t, "chi/events", last.Target)
+	assert.Equal(t, "staging", last.Context)
+	assert.Equal(t, history.StatusFailed, last.Status)
+	assert.Equal(t, "failed to create clickhouse-restore job: boom", last.Error)
+	assert.False(t, last.Finished.IsZero())
+	require.Len(t, last.Jobs, 2)
+	assert.True(t, strings.HasPrefix(last.Jobs[0], "clickhouse-drop-db-"))
+}
+
+func TestRunPhases_EmitsEvents(t *testing.T) {
+	var emitted []events.Event
+	emitEvent = func(_ *genericclioptions.ConfigFlags, event events.Event) error {
+		emitted = append(emitted, event)
+		return nil
+	}
+	createJob = func(_ *genericclioptions.ConfigFlags, _ job.JobSpec) error { return nil }
+	defer func() {
+		emitEvent = func(*genericclioptions.ConfigFlags, events.Event) error { return nil }
+		createJob = job.CreateJob
+	}()
+
+	opts := RestoreOptions{Namespace: "analytics", ServiceName: "postgres"}
+	meta := runMetadata{Engine: "postgres", Database: "shop", Backup: "daily.dump", RunID: "20250616-083000-a1b2c3"}
+	phases := []phase{{Name: "postgres-drop-db"}, {Name: "postgres-restore"}}
+	require.NoError(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, nil, phases))
+
+	reasons := []string{}
+	for _, e := range emitted {
+		reasons = append(reasons, e.Reason)
+	}
+	assert.Equal(t, []string{events.ReasonRestoreStarted, events.ReasonPhaseCompleted, events.ReasonPhaseCompleted, events.ReasonRestoreSucceeded}, reasons)
+
+	started := emitted[0]
+	assert.Equal(t, "Restore", started.Action)
+	assert.Equal(t, "20250616-083000-a1b2c3", started.RunID)
+	assert.Equal(t, "db-restore-run-20250616-083000-a1b2c3", started.Regarding.Name)
+	assert.Equal(t, "ConfigMap", started.Regarding.Kind)
+	require.NotNil(t, started.Related)
+	assert.Equal(t, "Service", started.Related.Kind)
+	assert.Equal(t, "postgres restore of shop into postgres from backup daily.dump started by tester", started.Note)
+	assert.Equal(t, "postgres restore of shop into postgres: postgres-restore completed", emitted[2].Note)
+
+	emitted = nil
+	createJob = func(_ *genericclioptions.ConfigFlags, _ job.JobSpec) error { return errors.New("boom") }
+	require.Error(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, nil, phases))
+	require.Len(t, emitted, 2)
+	assert.Equal(t, events.ReasonRestoreFailed, emitted[1].Reason)
+	assert.True(t, emitted[1].Warning)
+	assert.Contains(t, emitted[1].Note, "failed to create postgres-drop-db job: boom")
+}
+
+func TestRunPhases_Notifies(t *testing.T) {
+	var received []notify.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var n notify.Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	password := "s3cr3t-password"
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		if strings.HasPrefix(spec.JobName, "clickhouse-restore-") {
+			return errors.New("job failed: wrong password " + password)
+		}
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	opts := RestoreOptions{Namespace: "analytics", ServiceName: "clickhouse", Notifications: notify.Config{Webhooks: []string{server.URL}}}
+	meta := runMetadata{Engine: "clickhouse", Database: "shop", Backup: "daily", RunID: "20250616-083000-a1b2c3"}
+	envSources := []job.EnvVarSource{{Name: "CLICKHOUSE_PASSWORD", Value: &password}}
+	phases := []phase{{Name: "clickhouse-drop-db"}, {Name: "clickhouse-restore"}, {Name: "clickhouse-validate"}}
+
+	require.Error(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, envSources, phases))
+
+	require.Len(t, received, 2)
+	assert.Equal(t, notify.EventStarted, received[0].Event)
+	assert.Equal(t, "tester", received[0].User)
+	assert.Equal(t, "clickhouse", received[0].Target)
+
+	failed := received[1]
+	assert.Equal(t, notify.EventFailed, failed.Event)
+	assert.Equal(t, "daily", failed.Backup)
+	assert.Equal(t, "clickhouse-restore", failed.Phase)
+	assert.Equal(t, "failed to create clickhouse-restore job: job failed: wrong password ***", failed.Error)
+	assert.False(t, failed.Finished.IsZero())
+}
+
+func TestRunRecorder_Redact(t *testing.T) {
+	password, user, empty := "s3cr3t-password", "ab", ""
+	r := startRunRecord(&genericclioptions.ConfigFlags{}, RestoreOptions{}, runMetadata{Engine: "clickhouse"}, []job.EnvVarSource{
+		{Name: "CLICKHOUSE_PASSWORD", Value: &password},
+		{Name: "CLICKHOUSE_USER", Value: &user},
+		{Name: "CLICKHOUSE_DB", Value: &empty},
+	})
+
+	assert.Equal(t, "wrong password *** for user", r.redact("wrong password s3cr3t-password for user"))
+	assert.Equal(t, withheldError, r.redact("authentication failed for ab"), "a value too short to replace withholds the message")
+}
+
+func TestRunPhases_ExportsMetrics(t *testing.T) {
+	started := time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		spec.Observe(job.Timing{Started: started, Finished: started.Add(42 * time.Second), Succeeded: true})
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	dir := t.TempDir()
+	opts := RestoreOptions{Namespace: "analytics", ServiceName: "postgres", Metrics: metrics.Config{TextfileDir: dir}, BackupSize: 2048}
+	meta := runMetadata{Engine: "postgres", Database: "shop", Backup: "daily.dump", RunID: "20250616-083000-a1b2c3"}
+	phases := []phase{
+		{Name: "postgres-restore"},
+		{Name: "postgres-scale-up", Action: func(*genericclioptions.ConfigFlags, jobTracker) error { return nil }},
+	}
+	require.NoError(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, nil, phases))
+
+	data, err := os.ReadFile(filepath.Join(dir, "db-restore_postgres_analytics_shop.prom"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `restore_duration_seconds{engine="postgres",namespace="analytics",database="shop",phase="postgres-restore"} 42`+"\n")
+	assert.Contains(t, string(data), `phase="postgres-scale-up"}`)
+	assert.Contains(t, string(data), `restore_success{engine="postgres",namespace="analytics",database="shop"} 1`)
+	assert.Contains(t, string(data), `restored_bytes{engine="postgres",namespace="analytics",database="shop"} 2048`)
+
+	// The backup half of a clone is not a restore.
+	require.NoError(t, os.Remove(filepath.Join(dir, "db-restore_postgres_analytics_shop.prom")))
+	meta.Operation = "backup"
+	require.NoError(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, nil, phases))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRunPhases_Report(t *testing.T) {
+	started := time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+	exitCode := int32(0)
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		if strings.HasPrefix(spec.JobName, "postgres-mask") {
+			failed := int32(1)
+			spec.Observe(job.Timing{Started: started.Add(time.Minute), Finished: started.Add(70 * time.Second), ExitCode: &failed})
+			return fmt.Errorf("job '%s' failed", spec.JobName)
+		}
+		spec.Observe(job.Timing{Started: started, Finished: started.Add(42 * time.Second), Succeeded: true, ExitCode: &exitCode})
+		return nil
+	}
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		spec.Observe(job.Timing{Started: started.Add(50 * time.Second), Finished: started.Add(55 * time.Second), Succeeded: true, ExitCode: &exitCode})
+		return verify.Marker + " 0\n12\n", nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	var reports []RunReport
+	opts := RestoreOptions{
+		Namespace:   "analytics",
+		ServiceName: "postgres",
+		Checks:      []verify.Check{{Name: "orders", Query: "SELECT count(*) FROM orders;", Op: ">", Value: "0"}},
+		Report:      func(r RunReport) { reports = append(reports, r) },
+	}
+	meta := runMetadata{Engine: "postgres", Database: "shop", Backup: "daily.dump", RunID: "20250616-083000-a1b2c3"}
+	phases := []phase{
+		{Name: "postgres-restore"},
+		verificationPhase(meta, "postgres", "", "psql", opts, nil),
+		{Name: "postgres-mask"},
+		{Name: "postgres-scale-up", Action: func(*genericclioptions.ConfigFlags, jobTracker) error { return nil }},
+	}
+	require.Error(t, runPhases(&genericclioptions.ConfigFlags{}, opts, meta, nil, phases))
+
+	require.Len(t, reports, 1)
+	report := reports[0]
+	assert.Equal(t, "20250616-083000-a1b2c3", report.RunID)
+	assert.Equal(t, "restore", report.Operation)
+	assert.Equal(t, "daily.dump", report.Backup)
+	assert.Equal(t, history.StatusFailed, report.Status)
+	assert.Contains(t, report.Error, "failed to create postgres-mask job")
+
+	require.Len(t, report.Phases, 4)
+	restore := report.Phases[0]
+	assert.Equal(t, PhaseSucceeded, restore.Status)
+	assert.True(t, strings.HasPrefix(restore.Job, "postgres-restore-"))
+	assert.Equal(t, started, restore.Started)
+	assert.Equal(t, 42.0, restore.DurationSeconds)
+	assert.Equal(t, &exitCode, restore.ExitCode)
+
+	verification := report.Phases[1]
+	assert.Equal(t, PhaseSucceeded, verification.Status)
+	assert.True(t, strings.HasPrefix(verification.Job, "postgres-verify-"))
+	assert.Equal(t, 5.0, verification.DurationSeconds, "the Job an Action creates is timed like any other")
+	assert.Equal(t, []CheckReport{{Name: "orders", Expected: "> 0", Result: "12", Passed: true}}, verification.Verification)
+
+	mask := report.Phases[2]
+	assert.Equal(t, PhaseFailed, mask.Status)
+	assert.Equal(t, int32(1), *mask.ExitCode)
+	assert.Equal(t, 10.0, mask.DurationSeconds)
+
+	assert.Equal(t, PhaseReport{Name: "postgres-scale-up", Status: PhaseSkipped}, report.Phases[3])
+}
@@ -1,21 +1,297 @@
 package engine
 
 import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
 	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
+const postgresImage = "postgres:17-alpine"
+
+// postgresScriptHeader prepares the shell of every Postgres job: strict mode so a
+// failed download is not mistaken for an empty dump, the AWS CLI used to read
+// the dump from S3, and libpq credentials.
+const postgresScriptHeader = `set -euo pipefail
+apk add --no-cache aws-cli >/dev/null
+export PGUSER="$POSTGRES_USER" PGPASSWORD="$POSTGRES_PASSWORD"
+`
+
+var postgresRequiredVars = []string{
+	"POSTGRES_USER",
+	"POSTGRES_PASSWORD",
+	"POSTGRES_AWS_S3_BACKUP_URI",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+}
+
+// AWS_ENDPOINT_URL points the AWS CLI at an S3-compatible store such as MinIO.
+var postgresOptionalVars = []string{
+	"AWS_ENDPOINT_URL",
+	"AWS_DEFAULT_REGION",
+}
+
 type PostgresEngine struct{}
 
 func (p *PostgresEngine) Name() string {
 	return "postgres"
 }
 
+// Vars lists the variables of each mode. Operator restores use the operator's own
+// backup configuration and need none.
+func (p *PostgresEngine) Vars() []ModeVars {
+	vars := []ModeVars{{Mode: "logical", Required: postgresRequiredVars, Optional: postgresOptionalVars}}
+	for _, name := range []string{"pgbackrest", "wal-g"} {
+		tool := physicalTools[name]
+		vars = append(vars, ModeVars{Mode: "physical (" + name + ")", Required: tool.requiredVars, Optional: tool.optionalVars})
+	}
+	return append(vars, ModeVars{Mode: "operator"})
+}
+
 func (p *PostgresEngine) Restore(configFlags *genericclioptions.ConfigFlags, backupName string, databaseName string, opts RestoreOptions) error {
-	logger.Global.Info("PostgresEngine.Restore() not implemented yet")
+	if opts.Validation != nil {
+		return fmt.Errorf("post-restore validation is only supported by the clickhouse engine")
+	}
+	if len(opts.MaskingRules) > 0 && opts.Mode != "" && opts.Mode != "logical" {
+		return fmt.Errorf("masking rules are only supported by logical restores")
+	}
+	if len(opts.Checks) > 0 && opts.Mode != "" && opts.Mode != "logical" {
+		return fmt.Errorf("verification queries are only supported by logical restores")
+	}
+	if opts.PreserveGrants && opts.Mode != "" && opts.Mode != "logical" {
+		return fmt.Errorf("preserving grants is only supported by logical restores")
+	}
+
+	switch opts.Mode {
+	case "", "logical":
+	case "physical":
+		return p.restorePhysical(configFlags, backupName, databaseName, opts)
+	case "operator":
+		return p.restoreOperator(configFlags, backupName, databaseName, opts)
+	default:
+		return fmt.Errorf("unsupported postgres restore mode %q (expected logical, physical or operator)", opts.Mode)
+	}
+
+	requiredVars := postgresRequiredVars
+
+	resolvedVars, err := loadPostgresVars(opts.targetFlags(configFlags), opts)
+	if err != nil {
+		return err
+	}
+
+	envSources := toEnvSources(resolvedVars)
+	meta := newRunMetadata(p.Name(), databaseName, backupName)
+	image := opts.image(postgresImage)
+	phases := postgresPhases(backupName, databaseName, opts.ServiceName, image)
+	if len(opts.MaskingRules) > 0 {
+		phases = append(phases, postgresMaskingPhase(databaseName, opts.ServiceName, image, opts.MaskingRules))
+	}
+	if len(opts.Checks) > 0 {
+		phases = append(phases, verificationPhase(meta, image, postgresScriptHeader,
+			fmt.Sprintf(`psql --host %s --dbname %s -v ON_ERROR_STOP=1 --no-align --tuples-only --field-separator "$(printf '\t')"`, opts.ServiceName, databaseName), opts, envSources))
+	}
+	if opts.PreserveGrants {
+		phases = withGrantPhases(phases, meta, opts, envSources, postgresGrantCapture(databaseName, opts.ServiceName, image))
+	}
+	phases = withHookPhases(phases, opts.Hooks)
+
+	if opts.DryRun {
+		logger.Global.Info("🔍 [Dry Run] Initiating validation for restore process...")
+		logger.Global.Info("[Dry Run] Target database: '%s'", databaseName)
+		logger.Global.Info("[Dry Run] Backup source: '%s/%s'", os.Getenv("POSTGRES_AWS_S3_BACKUP_URI"), backupName)
+		logger.Global.Info("[Dry Run] Service name (PostgreSQL host): '%s'", opts.ServiceName)
+		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
+		logger.Global.Info("[Dry Run] Validated secret keys: %v", requiredVars)
+
+		logDryRunPlan(configFlags, opts, meta, envSources, phases)
+
+		logger.Global.Info("✅ [Dry Run] Validation completed successfully. No changes were made.")
+		return nil
+	}
+
+	logger.Global.Info("🚀 Starting PostgreSQL restore sequence for database: %s", databaseName)
+
+	if err := runPhases(opts.targetFlags(configFlags), opts, meta, envSources, phases); err != nil {
+		return err
+	}
+
+	logger.Global.Info("🎉 All jobs for PostgreSQL restore sequence completed successfully!")
 	return nil
 }
 
+// ListBackups lists the objects directly under POSTGRES_AWS_S3_BACKUP_URI.
+// S3 only knows when a dump was uploaded, so that is used as its timestamp,
+// and the databases inside the dumps are not reported.
+func (p *PostgresEngine) ListBackups(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions) ([]BackupInfo, error) {
+	resolvedVars, err := loadPostgresVars(configFlags, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	jobSpec := job.JobSpec{
+		Namespace:         opts.Namespace,
+		JobName:           jobName("postgres-list-backups"),
+		Image:             opts.image(postgresImage),
+		Command:           []string{"/bin/sh"},
+		Args:              []string{"-c", postgresScriptHeader + `aws s3 ls "$POSTGRES_AWS_S3_BACKUP_URI/"`},
+		EnvVars:           toEnvSources(resolvedVars),
+		EnvFrom:           opts.SecretEnvFrom,
+		Labels:            runMetadata{Engine: p.Name()}.labels(),
+		JobSuccessMessage: "📚 Backup listing completed",
+		JobFailureHeader:  "💥 Failed to list PostgreSQL dumps",
+		Overrides:         opts.JobOverrides,
+		Ephemeral:         true,
+	}
+
+	output, err := createJobForOutput(configFlags, jobSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run backup listing job: %w", err)
+	}
+
+	return parseS3Listing(output)
+}
+
+// ResolveBackup picks among the listed objects like the default resolver, but
+// "latest" only considers *.dump objects: the backup prefix commonly also holds
+// globals dumps or WAL archives which are not restorable with pg_restore.
+//
+// Physical and operator restores only resolve "latest": picking a base backup for
+// a point in time is left to the backup tool through --recovery-target-time.
+func (p *PostgresEngine) ResolveBackup(configFlags *genericclioptions.ConfigFlags, selector BackupSelector, opts RestoreOptions) (BackupInfo, error) {
+	if opts.Mode == "physical" || opts.Mode == "operator" {
+		if isLatestBackup(selector.Pattern) && selector.Before.IsZero() {
+			return BackupInfo{Name: "LATEST"}, nil
+		}
+		return BackupInfo{}, fmt.Errorf("%s restores take --backup-name or --backup latest, use --recovery-target-time for point-in-time recovery", opts.Mode)
+	}
+
+	backups, err := p.ListBackups(configFlags, opts)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	if selector.Pattern == "" || selector.Pattern == "latest" {
+		selector.Pattern = "*.dump"
+	}
+	return SelectBackup(backups, selector)
+}
+
+// loadPostgresVars loads the variables of the Jobs, configFlags pointing at the
+// cluster they run in.
+func loadPostgresVars(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions) (map[string]k8screds.LoadedVar, error) {
+	resolvedVars, err := k8screds.LoadSecretsVars(configFlags, opts.Namespace, opts.SecretKeyRefs, opts.SecretEnvFrom, postgresRequiredVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret vars: %w", err)
+	}
+	k8screds.MergeOptionalVars(resolvedVars, k8screds.LoadOptionalVars(opts.SecretKeyRefs, postgresOptionalVars))
+	return resolvedVars, nil
+}
+
+// parseS3Listing parses `aws s3 ls` lines such as
+// "2025-06-16 02:00:03    1048576 app-2025-06-16.dump", skipping "PRE dir/" entries.
+func parseS3Listing(output string) ([]BackupInfo, error) {
+	backups := []BackupInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "PRE" {
+			continue
+		}
+
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.UTC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing line %q: %w", line, err)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing line %q: %w", line, err)
+		}
+
+		backups = append(backups, BackupInfo{
+			Name:      strings.Join(fields[3:], " "),
+			Size:      size,
+			Timestamp: timestamp,
+			Databases: []string{},
+		})
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+	return backups, nil
+}
+
+// postgresPhases returns the jobs of a logical restore from a custom-format
+// pg_dump stored at $POSTGRES_AWS_S3_BACKUP_URI/<backupName>, in execution order.
+// The preflight has pg_restore read the dump header and table of contents, which
+// fails on a missing object as well as on a file that is not a pg_dump archive.
+func postgresPhases(backupName, databaseName, serviceName, image string) []phase {
+	dumpURI := fmt.Sprintf(`"$POSTGRES_AWS_S3_BACKUP_URI/%s"`, backupName)
+
+	return []phase{
+		{
+			Name:  "postgres-preflight",
+			Image: image,
+			// pg_restore stops reading after the table of contents, so the
+			// download's broken pipe is expected and must not fail the check.
+			Script: postgresScriptHeader + fmt.Sprintf(`{ aws s3 cp %s - 2>/dev/null || true; } | pg_restore --list >/dev/null`,
+				dumpURI),
+			Description:    fmt.Sprintf("🔎 Job: Check dump '%s' exists and has a valid header", backupName),
+			SuccessMessage: fmt.Sprintf("🔎 Dump '%s' found and readable", backupName),
+			FailureHeader:  "🚫 Dump not found or not a pg_dump archive, nothing was dropped",
+		},
+		{
+			Name:  "postgres-drop-db",
+			Image: image,
+			Script: postgresScriptHeader + fmt.Sprintf(`dropdb --host %s --if-exists --force %s`,
+				serviceName, databaseName),
+			Description:    fmt.Sprintf("🗑️ Job: Drop database '%s' (if it exists)", databaseName),
+			SuccessMessage: fmt.Sprintf("🗑️ Successfully dropped database '%s' (if it existed)", databaseName),
+			FailureHeader:  "🛑 Failed to drop existing database",
+		},
+		{
+			Name:  "postgres-create-db",
+			Image: image,
+			Script: postgresScriptHeader + fmt.Sprintf(`createdb --host %s %s`,
+				serviceName, databaseName),
+			Description:    fmt.Sprintf("🏗️ Job: Create new database '%s'", databaseName),
+			SuccessMessage: fmt.Sprintf("🏗️ Successfully created database '%s'", databaseName),
+			FailureHeader:  "❌ Failed to create new database",
+		},
+		{
+			Name:  "postgres-restore",
+			Image: image,
+			Script: postgresScriptHeader + fmt.Sprintf(`aws s3 cp %s - | pg_restore --host %s --dbname %s --no-owner --exit-on-error`,
+				dumpURI, serviceName, databaseName),
+			Description:    fmt.Sprintf("📦 Job: Restore database '%s' from dump '%s'", databaseName, backupName),
+			SuccessMessage: fmt.Sprintf("✅ Successfully restored database '%s' from dump '%s'", databaseName, backupName),
+			FailureHeader:  "💣 PostgreSQL restore job failed",
+		},
+	}
+}
+
+// postgresMaskingPhase rewrites the masked columns of the restored database, all
+// tables in one transaction.
+func postgresMaskingPhase(databaseName, serviceName, image string, rules []masking.Rule) phase {
+	return phase{
+		Name:  "postgres-mask",
+		Image: image,
+		Script: postgresScriptHeader + fmt.Sprintf(`psql --host %s --dbname %s -v ON_ERROR_STOP=1 --single-transaction <<'SQL'
+%s
+SQL`, serviceName, databaseName, strings.Join(masking.PostgresStatements(rules), "\n")),
+		Description:    fmt.Sprintf("🎭 Job: Mask %d column(s) of database '%s'", len(rules), databaseName),
+		SuccessMessage: fmt.Sprintf("🎭 Successfully masked database '%s'", databaseName),
+		FailureHeader:  "🚨 Masking failed, the restored database may still contain unmasked data",
+	}
+}
+
 func init() {
 	RegisterEngine(&PostgresEngine{})
 }
@@ -0,0 +1,314 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/workload"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+)
+
+// These are swapped in tests to avoid talking to a cluster.
+var (
+	hasResource        = workload.HasResource
+	getResource        = workload.GetResource
+	createResource     = workload.CreateResource
+	waitForResource    = workload.WaitForResource
+	failedJob          = workload.FailedJob
+	setServiceSelector = workload.SetServiceSelector
+)
+
+// DefaultClusterTimeout is how long an operator or physical restore waits for
+// the restored database to be ready: recovering a large database from an object
+// store is slow.
+const DefaultClusterTimeout = 6 * time.Hour
+
+// clusterTimeout is opts.ClusterTimeout, defaulted.
+func (opts RestoreOptions) clusterTimeout() time.Duration {
+	if opts.ClusterTimeout == 0 {
+		return DefaultClusterTimeout
+	}
+	return opts.ClusterTimeout
+}
+
+// cnpgFailedPhases are the CloudNativePG phases it does not recover from
+// without manual intervention.
+var cnpgFailedPhases = []string{
+	"Failed",
+	"Cluster is in an unrecoverable state, needs manual intervention",
+	"Unable to create required cluster objects",
+	"Cluster cannot proceed to reconciliation due to an error with ImageCatalog",
+	"Cluster cannot proceed to reconciliation due to an error while invoking a plugin",
+}
+
+// postgresOperator knows how one Postgres operator bootstraps a new cluster from
+// the backups of an existing one.
+type postgresOperator struct {
+	Name string
+	GVR  schema.GroupVersionResource
+	// bootstrap returns the spec of a new cluster restoring source.
+	bootstrap func(source *unstructured.Unstructured, backupName string, target time.Time) (map[string]any, error)
+	// ready reports whether the cluster is up, its current status, and an error
+	// when the operator gave up.
+	ready func(obj *unstructured.Unstructured) (bool, string, error)
+	// bootstrapJobs, when set, selects the Jobs the operator runs to bootstrap a
+	// cluster: one that failed fails the restore.
+	bootstrapJobs func(clusterName string) map[string]string
+	// primarySelector selects the primary pod of a cluster.
+	primarySelector func(clusterName string) map[string]string
+}
+
+var postgresOperators = []postgresOperator{
+	{
+		Name:      "CloudNativePG",
+		GVR:       schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"},
+		bootstrap: cnpgBootstrap,
+		ready: func(obj *unstructured.Unstructured) (bool, string, error) {
+			phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+			if slices.Contains(cnpgFailedPhases, phase) {
+				return false, phase, fmt.Errorf("operator reported %q for %s", phase, obj.GetName())
+			}
+			conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+			for _, c := range conditions {
+				condition, _ := c.(map[string]any)
+				if condition["type"] == "Ready" && condition["status"] == "True" {
+					return true, phase, nil
+				}
+			}
+			return false, phase, nil
+		},
+		bootstrapJobs: func(clusterName string) map[string]string {
+			return map[string]string{"cnpg.io/cluster": clusterName, "cnpg.io/jobRole": "full-recovery"}
+		},
+		primarySelector: func(clusterName string) map[string]string {
+			return map[string]string{"cnpg.io/cluster": clusterName, "cnpg.io/instanceRole": "primary"}
+		},
+	},
+	{
+		Name:      "Zalando postgres-operator",
+		GVR:       schema.GroupVersionResource{Group: "acid.zalan.do", Version: "v1", Resource: "postgresqls"},
+		bootstrap: zalandoBootstrap,
+		ready: func(obj *unstructured.Unstructured) (bool, string, error) {
+			status, _, _ := unstructured.NestedString(obj.Object, "status", "PostgresClusterStatus")
+			switch status {
+			case "Running":
+				return true, status, nil
+			case "CreateFailed", "SyncFailed", "UpdateFailed":
+				return false, status, fmt.Errorf("operator reported %s for %s", status, obj.GetName())
+			}
+			return false, status, nil
+		},
+		primarySelector: func(clusterName string) map[string]string {
+			return map[string]string{"application": "spilo", "cluster-name": clusterName, "spilo-role": "master"}
+		},
+	},
+}
+
+// readyOrFailed is ready, also failing once a bootstrap Job of the cluster has
+// failed: the operator may keep the cluster in its bootstrap phase meanwhile.
+func (op postgresOperator) readyOrFailed(configFlags *genericclioptions.ConfigFlags, namespace, clusterName string) func(*unstructured.Unstructured) (bool, string, error) {
+	if op.bootstrapJobs == nil {
+		return op.ready
+	}
+	selector := op.bootstrapJobs(clusterName)
+	return func(obj *unstructured.Unstructured) (bool, string, error) {
+		ok, status, err := op.ready(obj)
+		if ok || err != nil {
+			return ok, status, err
+		}
+		name, err := failedJob(configFlags, namespace, selector)
+		if err != nil {
+			return false, status, err
+		}
+		if name != "" {
+			return false, status, fmt.Errorf("bootstrap Job %s of %s failed, see its logs", name, clusterName)
+		}
+		return false, status, nil
+	}
+}
+
+// copySpecFields deep-copies the listed top-level spec fields of source, when set.
+func copySpecFields(source *unstructured.Unstructured, fields ...string) map[string]any {
+	spec := map[string]any{}
+	for _, field := range fields {
+		if value, found, _ := unstructured.NestedFieldCopy(source.Object, "spec", field); found {
+			spec[field] = value
+		}
+	}
+	return spec
+}
+
+// cnpgBootstrap recovers from a Backup object when one is named, otherwise from the
+// source's object store, declared as an external cluster. spec.backup is not copied:
+// the new cluster must not archive WAL over the source's.
+func cnpgBootstrap(source *unstructured.Unstructured, backupName string, target time.Time) (map[string]any, error) {
+	spec := copySpecFields(source, "instances", "imageName", "storage", "walStorage", "postgresql", "resources", "affinity")
+
+	recovery := map[string]any{}
+	if isLatestBackup(backupName) {
+		store, found, _ := unstructured.NestedFieldCopy(source.Object, "spec", "backup", "barmanObjectStore")
+		if !found {
+			return nil, fmt.Errorf("cluster %s has no spec.backup.barmanObjectStore to recover from, pass a Backup name with --backup-name", source.GetName())
+		}
+		recovery["source"] = source.GetName()
+		spec["externalClusters"] = []any{
+			map[string]any{"name": source.GetName(), "barmanObjectStore": store},
+		}
+	} else {
+		recovery["backup"] = map[string]any{"name": backupName}
+	}
+	if !target.IsZero() {
+		recovery["recoveryTarget"] = map[string]any{"targetTime": postgresTimestamp(target)}
+	}
+
+	spec["bootstrap"] = map[string]any{"recovery": recovery}
+	return spec, nil
+}
+
+// zalandoBootstrap clones the source from its WAL archive. Zalando clones restore a
+// point in time rather than a named backup; without a target, the latest state is used.
+func zalandoBootstrap(source *unstructured.Unstructured, backupName string, target time.Time) (map[string]any, error) {
+	if !isLatestBackup(backupName) {
+		return nil, fmt.Errorf("zalando clusters are cloned to a point in time, use --backup latest with --recovery-target-time instead of backup %q", backupName)
+	}
+
+	spec := copySpecFields(source, "teamId", "numberOfInstances", "volume", "postgresql", "users", "databases", "preparedDatabases", "resources", "patroni")
+
+	if target.IsZero() {
+		target = time.Now()
+	}
+	spec["clone"] = map[string]any{
+		"cluster":   source.GetName(),
+		"uid":       string(source.GetUID()),
+		"timestamp": target.UTC().Format("2006-01-02T15:04:05+00:00"),
+	}
+	return spec, nil
+}
+
+// detectPostgresOperator returns the first operator whose CRD is served by the
+// cluster and which manages a cluster with that name.
+func detectPostgresOperator(configFlags *genericclioptions.ConfigFlags, namespace, clusterName string) (postgresOperator, *unstructured.Unstructured, error) {
+	for _, op := range postgresOperators {
+		served, err := hasResource(configFlags, op.GVR)
+		if err != nil {
+			return postgresOperator{}, nil, err
+		}
+		if !served {
+			continue
+		}
+
+		source, err := getResource(configFlags, op.GVR, namespace, clusterName)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return postgresOperator{}, nil, err
+		}
+		return op, source, nil
+	}
+
+	return postgresOperator{}, nil, fmt.Errorf("no CloudNativePG Cluster or Zalando postgresql named %q found in namespace %q", clusterName, namespace)
+}
+
+// restoreOperator creates a new operator-managed cluster bootstrapped from the
+// backups of opts.SourceCluster, instead of running SQL against the existing one.
+func (p *PostgresEngine) restoreOperator(configFlags *genericclioptions.ConfigFlags, backupName, databaseName string, opts RestoreOptions) error {
+	if opts.SourceCluster == "" {
+		return fmt.Errorf("operator restores require the source cluster name")
+	}
+	// The new cluster recovers from objects of the source cluster (a CloudNativePG
+	// Backup, the credentials of its object store, the Zalando clone source) that
+	// do not exist in another Kubernetes cluster.
+	if opts.crossContext(configFlags) {
+		return fmt.Errorf("operator restores cannot cross contexts: the restored cluster needs the source cluster's backup objects and Secrets, run it in context '%s'", ContextName(configFlags))
+	}
+
+	op, source, err := detectPostgresOperator(configFlags, opts.Namespace, opts.SourceCluster)
+	if err != nil {
+		return err
+	}
+
+	spec, err := op.bootstrap(source, backupName, opts.RecoveryTargetTime)
+	if err != nil {
+		return err
+	}
+
+	targetName := opts.TargetCluster
+	if targetName == "" {
+		targetName = fmt.Sprintf("%s-restore-%d", opts.SourceCluster, time.Now().Unix())
+	}
+
+	meta := newRunMetadata(p.Name(), databaseName, backupName)
+	meta.Target = "cluster/" + targetName
+	cluster := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	cluster.SetAPIVersion(source.GetAPIVersion())
+	cluster.SetKind(source.GetKind())
+	cluster.SetName(targetName)
+	cluster.SetNamespace(opts.Namespace)
+	cluster.SetLabels(meta.labels())
+	cluster.SetAnnotations(meta.annotations())
+
+	phases := []phase{
+		{
+			Name:        "postgres-create-cluster",
+			Description: fmt.Sprintf("🏗️ Create %s '%s' bootstrapped from '%s'", source.GetKind(), targetName, opts.SourceCluster),
+			Action: func(configFlags *genericclioptions.ConfigFlags, _ jobTracker) error {
+				return createResource(configFlags, op.GVR, cluster)
+			},
+		},
+		{
+			Name:        "postgres-wait-cluster",
+			Description: fmt.Sprintf("⏳ Wait for %s '%s' to be ready", source.GetKind(), targetName),
+			Action: func(configFlags *genericclioptions.ConfigFlags, _ jobTracker) error {
+				return waitForResource(configFlags, op.GVR, opts.Namespace, targetName, opts.clusterTimeout(), op.readyOrFailed(configFlags, opts.Namespace, targetName))
+			},
+		},
+	}
+	if opts.RepointService {
+		selector := op.primarySelector(targetName)
+		phases = append(phases, phase{
+			Name:        "postgres-repoint-service",
+			Description: fmt.Sprintf("🔀 Point Service '%s' at the primary of '%s'", opts.ServiceName, targetName),
+			Action: func(configFlags *genericclioptions.ConfigFlags, _ jobTracker) error {
+				return setServiceSelector(configFlags, opts.Namespace, opts.ServiceName, selector)
+			},
+		})
+	}
+	phases = withHookPhases(phases, opts.Hooks)
+
+	if opts.DryRun {
+		manifest, err := yaml.Marshal(cluster.Object)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", source.GetKind(), err)
+		}
+
+		logger.Global.Info("🔍 [Dry Run] Initiating validation for operator restore process...")
+		logger.Global.Info("[Dry Run] Detected operator: %s", op.Name)
+		logger.Global.Info("[Dry Run] Source cluster: '%s'", opts.SourceCluster)
+		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
+		logger.Global.Info("[Dry Run] Would create:\n%s", manifest)
+
+		logDryRunPlan(configFlags, opts, meta, nil, phases)
+
+		logger.Global.Info("✅ [Dry Run] Validation completed successfully. No changes were made.")
+		return nil
+	}
+
+	logger.Global.Info("🚀 Restoring %s cluster '%s' into new cluster '%s'", op.Name, opts.SourceCluster, targetName)
+
+	if err := runPhases(opts.targetFlags(configFlags), opts, meta, nil, phases); err != nil {
+		return err
+	}
+
+	if !opts.RepointService {
+		logger.Global.Info("ℹ️ Service '%s' still points at '%s', use --repoint-service to switch it to '%s'", opts.ServiceName, opts.SourceCluster, targetName)
+	}
+	logger.Global.Info("🎉 Cluster '%s' restored and ready!", targetName)
+	return nil
+}
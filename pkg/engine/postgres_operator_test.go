@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/workload"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func cnpgCluster() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "postgresql.cnpg.io/v1",
+		"kind":       "Cluster",
+		"metadata":   map[string]any{"name": "app-db", "namespace": "backend"},
+		"spec": map[string]any{
+			"instances": int64(3),
+			"storage":   map[string]any{"size": "10Gi"},
+			"backup": map[string]any{
+				"barmanObjectStore": map[string]any{"destinationPath": "s3://backups/app-db"},
+			},
+		},
+	}}
+}
+
+func TestCnpgBootstrap_FromObjectStore(t *testing.T) {
+	spec, err := cnpgBootstrap(cnpgCluster(), "LATEST", time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), spec["instances"])
+	assert.NotContains(t, spec, "backup")
+	assert.Equal(t, map[string]any{
+		"recovery": map[string]any{
+			"source":         "app-db",
+			"recoveryTarget": map[string]any{"targetTime": "2025-06-16 08:30:00+00"},
+		},
+	}, spec["bootstrap"])
+	assert.Equal(t, []any{map[string]any{
+		"name":              "app-db",
+		"barmanObjectStore": map[string]any{"destinationPath": "s3://backups/app-db"},
+	}}, spec["externalClusters"])
+}
+
+func TestCnpgBootstrap_FromBackupObject(t *testing.T) {
+	spec, err := cnpgBootstrap(cnpgCluster(), "app-db-20250616", time.Time{})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"recovery": map[string]any{"backup": map[string]any{"name": "app-db-20250616"}},
+	}, spec["bootstrap"])
+	assert.NotContains(t, spec, "externalClusters")
+}
+
+func TestZalandoBootstrap(t *testing.T) {
+	source := &unstructured.Unstructured{Object: map[string]any{
+		"metadata": map[string]any{"name": "acid-app", "uid": "efd12e58-5786-11e8-b5a7-06148230260c"},
+		"spec":     map[string]any{"teamId": "acid", "numberOfInstances": int64(2)},
+	}}
+
+	spec, err := zalandoBootstrap(source, "latest", time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, "acid", spec["teamId"])
+	assert.Equal(t, map[string]any{
+		"cluster":   "acid-app",
+		"uid":       "efd12e58-5786-11e8-b5a7-06148230260c",
+		"timestamp": "2025-06-16T08:30:00+00:00",
+	}, spec["clone"])
+
+	_, err = zalandoBootstrap(source, "base_000000010000000000000003", time.Time{})
+	assert.Error(t, err)
+}
+
+func TestPostgresEngine_Restore_Operator(t *testing.T) {
+	var created *unstructured.Unstructured
+	var repointed map[string]string
+
+	hasResource = func(_ *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource) (bool, error) {
+		return true, nil
+	}
+	getResource = func(_ *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource, _, name string) (*unstructured.Unstructured, error) {
+		if gvr.Group != "postgresql.cnpg.io" {
+			return nil, apierrors.NewNotFound(gvr.GroupResource(), name)
+		}
+		return cnpgCluster(), nil
+	}
+	createResource = func(_ *genericclioptions.ConfigFlags, _ schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+		created = obj
+		return nil
+	}
+	waitForResource = func(_ *genericclioptions.ConfigFlags, _ schema.GroupVersionResource, _, name string, timeout time.Duration, ready func(*unstructured.Unstructured) (bool, string, error)) error {
+		assert.Equal(t, DefaultClusterTimeout, timeout)
+		ok, _, err := ready(&unstructured.Unstructured{Object: map[string]any{
+			"status": map[string]any{"conditions": []any{map[string]any{"type": "Ready", "status": "True"}}},
+		}})
+		assert.True(t, ok)
+		return err
+	}
+	setServiceSelector = func(_ *genericclioptions.ConfigFlags, _, name string, selector map[string]string) error {
+		assert.Equal(t, "app-db-svc", name)
+		repointed = selector
+		return nil
+	}
+	defer func() {
+		hasResource = workload.HasResource
+		getResource = workload.GetResource
+		createResource = workload.CreateResource
+		waitForResource = workload.WaitForResource
+		setServiceSelector = workload.SetServiceSelector
+	}()
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "LATEST", "", RestoreOptions{
+		Namespace:      "backend",
+		ServiceName:    "app-db-svc",
+		Mode:           "operator",
+		SourceCluster:  "app-db",
+		TargetCluster:  "app-db-restored",
+		RepointService: true,
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, created)
+	assert.Equal(t, "Cluster", created.GetKind())
+	assert.Equal(t, "app-db-restored", created.GetName())
+	assert.Equal(t, "backend", created.GetNamespace())
+	assert.Equal(t, "postgres", created.GetLabels()[LabelPrefix+"engine"])
+	assert.Equal(t, map[string]string{"cnpg.io/cluster": "app-db-restored", "cnpg.io/instanceRole": "primary"}, repointed)
+}
+
+func TestCnpgReady_Failed(t *testing.T) {
+	cnpg := postgresOperators[0]
+	failedJob = func(_ *genericclioptions.ConfigFlags, namespace string, selector map[string]string) (string, error) {
+		assert.Equal(t, "backend", namespace)
+		assert.Equal(t, "full-recovery", selector["cnpg.io/jobRole"])
+		return "app-db-restored-1-full-recovery", nil
+	}
+	defer func() { failedJob = workload.FailedJob }()
+
+	cluster := func(phase string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{"status": map[string]any{"phase": phase}}}
+	}
+	ready := cnpg.readyOrFailed(&genericclioptions.ConfigFlags{}, "backend", "app-db-restored")
+
+	_, _, err := ready(cluster("Cluster is in an unrecoverable state, needs manual intervention"))
+	assert.ErrorContains(t, err, "operator reported")
+
+	_, status, err := ready(cluster("Setting up primary"))
+	assert.Equal(t, "Setting up primary", status)
+	assert.EqualError(t, err, "bootstrap Job app-db-restored-1-full-recovery of app-db-restored failed, see its logs")
+}
+
+func TestDetectPostgresOperator_NoneFound(t *testing.T) {
+	hasResource = func(_ *genericclioptions.ConfigFlags, _ schema.GroupVersionResource) (bool, error) {
+		return false, nil
+	}
+	defer func() { hasResource = workload.HasResource }()
+
+	_, _, err := detectPostgresOperator(&genericclioptions.ConfigFlags{}, "backend", "app-db")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no CloudNativePG Cluster or Zalando postgresql")
+}
+
+func TestPostgresEngine_Restore_OperatorAcrossContexts(t *testing.T) {
+	prod, staging := "prod", "staging"
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{Context: &prod}, "latest", "", RestoreOptions{
+		Mode:              "operator",
+		SourceCluster:     "app-db",
+		TargetConfigFlags: &genericclioptions.ConfigFlags{Context: &staging},
+	})
+	assert.ErrorContains(t, err, "operator restores cannot cross contexts")
+}
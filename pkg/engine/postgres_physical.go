@@ -0,0 +1,307 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/workload"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// getStatefulSet and scaleStatefulSet are swapped in tests to avoid talking to a cluster.
+var (
+	getStatefulSet   = workload.GetStatefulSet
+	scaleStatefulSet = workload.ScaleStatefulSet
+)
+
+// physicalTool knows how to check for and restore a base backup with one backup tool.
+// Backup names are the tool's own (WAL-G backup name, pgBackRest set label),
+// "LATEST" picks the most recent one.
+type physicalTool struct {
+	requiredVars []string
+	optionalVars []string
+	preflight    func(backupName string) string
+	restore      func(backupName string, target time.Time) string
+}
+
+// physicalBackupName matches the names of WAL-G backups and pgBackRest sets, such
+// as base_000000010000000000000002 or 20250616-020003F_20250617-020003I. They are
+// pasted into the Jobs' scripts, so nothing else is accepted.
+var physicalBackupName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+
+var physicalTools = map[string]physicalTool{
+	"wal-g": {
+		requiredVars: []string{
+			"WALG_S3_PREFIX",
+			"AWS_ACCESS_KEY_ID",
+			"AWS_SECRET_ACCESS_KEY",
+		},
+		optionalVars: []string{
+			"AWS_ENDPOINT",
+			"AWS_REGION",
+			"AWS_S3_FORCE_PATH_STYLE",
+			"WALG_LIBSODIUM_KEY",
+		},
+		preflight: func(backupName string) string {
+			// The first column of backup-list, compared as a string.
+			check := fmt.Sprintf(`awk -v name='%s' '$1 == name { found = 1 } END { exit !found }' /tmp/backups`, backupName)
+			if isLatestBackup(backupName) {
+				check = `[ "$(wc -l < /tmp/backups)" -gt 1 ]`
+			}
+			return fmt.Sprintf(`set -eu
+wal-g backup-list > /tmp/backups
+cat /tmp/backups
+%s`, check)
+		},
+		restore: func(backupName string, target time.Time) string {
+			recoveryConf := `restore_command = 'wal-g wal-fetch "%f" "%p"'`
+			if !target.IsZero() {
+				recoveryConf += fmt.Sprintf(`
+recovery_target_time = '%s'
+recovery_target_action = 'promote'`, postgresTimestamp(target))
+			}
+			if isLatestBackup(backupName) {
+				backupName = "LATEST"
+			}
+			return fmt.Sprintf(`set -eu
+mkdir -p "$PGDATA"
+find "$PGDATA" -mindepth 1 -delete
+wal-g backup-fetch "$PGDATA" '%s'
+chmod 700 "$PGDATA"
+cat >> "$PGDATA/postgresql.auto.conf" <<'CONF'
+%s
+CONF
+touch "$PGDATA/recovery.signal"`, backupName, recoveryConf)
+		},
+	},
+	"pgbackrest": {
+		requiredVars: []string{
+			"PGBACKREST_STANZA",
+			"PGBACKREST_REPO1_S3_BUCKET",
+			"PGBACKREST_REPO1_S3_ENDPOINT",
+			"PGBACKREST_REPO1_S3_REGION",
+			"PGBACKREST_REPO1_S3_KEY",
+			"PGBACKREST_REPO1_S3_KEY_SECRET",
+		},
+		optionalVars: []string{
+			"PGBACKREST_REPO1_PATH",
+			"PGBACKREST_REPO1_TYPE",
+			"PGBACKREST_REPO1_S3_URI_STYLE",
+			"PGBACKREST_REPO1_CIPHER_TYPE",
+			"PGBACKREST_REPO1_CIPHER_PASS",
+		},
+		preflight: func(backupName string) string {
+			check := fmt.Sprintf(`grep -qF '"label":"%s"' /tmp/info`, backupName)
+			if isLatestBackup(backupName) {
+				check = `grep -q '"label":' /tmp/info`
+			}
+			return fmt.Sprintf(`set -eu
+export PGBACKREST_REPO1_TYPE="${PGBACKREST_REPO1_TYPE:-s3}"
+pgbackrest --stanza="$PGBACKREST_STANZA" --log-level-file=off info --output=json > /tmp/info
+cat /tmp/info
+%s`, check)
+		},
+		restore: func(backupName string, target time.Time) string {
+			args := []string{`--stanza="$PGBACKREST_STANZA"`, `--pg1-path="$PGDATA"`, "--log-level-file=off", "--delta"}
+			if !isLatestBackup(backupName) {
+				args = append(args, fmt.Sprintf("--set='%s'", backupName))
+			}
+			if !target.IsZero() {
+				args = append(args, "--type=time", fmt.Sprintf("--target='%s'", postgresTimestamp(target)), "--target-action=promote")
+			}
+			return fmt.Sprintf(`set -eu
+export PGBACKREST_REPO1_TYPE="${PGBACKREST_REPO1_TYPE:-s3}"
+mkdir -p "$PGDATA"
+pgbackrest %s restore`, strings.Join(args, " "))
+		},
+	},
+}
+
+func isLatestBackup(backupName string) bool {
+	return backupName == "" || strings.EqualFold(backupName, "latest")
+}
+
+func postgresTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05+00")
+}
+
+// physicalTarget is what a physical restore needs to know about the database StatefulSet.
+// The restore Job reuses the database image, so the backup tool and Postgres
+// version always match, and mounts the data volume of ordinal 0.
+type physicalTarget struct {
+	StatefulSet     string
+	Replicas        int32
+	Image           string
+	PGData          string
+	DataVolume      corev1.Volume
+	DataMount       corev1.VolumeMount
+	SecurityContext *corev1.PodSecurityContext
+}
+
+// inspectStatefulSet finds the Postgres container (named "postgres", or the first one)
+// and the volume claim template mounted in it.
+func inspectStatefulSet(sts *appsv1.StatefulSet) (physicalTarget, error) {
+	containers := sts.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return physicalTarget{}, fmt.Errorf("StatefulSet %s has no containers", sts.Name)
+	}
+	container := containers[0]
+	for _, c := range containers {
+		if c.Name == "postgres" {
+			container = c
+			break
+		}
+	}
+
+	target := physicalTarget{
+		StatefulSet:     sts.Name,
+		Replicas:        1,
+		Image:           container.Image,
+		SecurityContext: sts.Spec.Template.Spec.SecurityContext,
+	}
+	if sts.Spec.Replicas != nil {
+		target.Replicas = *sts.Spec.Replicas
+	}
+
+	for _, claim := range sts.Spec.VolumeClaimTemplates {
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != claim.Name {
+				continue
+			}
+			target.DataMount = mount
+			target.DataVolume = corev1.Volume{
+				Name: claim.Name,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: fmt.Sprintf("%s-%s-0", claim.Name, sts.Name),
+					},
+				},
+			}
+		}
+	}
+	if target.DataVolume.Name == "" {
+		return physicalTarget{}, fmt.Errorf("StatefulSet %s has no volume claim template mounted in container %s", sts.Name, container.Name)
+	}
+
+	target.PGData = target.DataMount.MountPath
+	for _, env := range container.Env {
+		if env.Name == "PGDATA" && env.Value != "" {
+			target.PGData = env.Value
+		}
+	}
+
+	return target, nil
+}
+
+// restorePhysical restores a base backup into the data volume of the StatefulSet
+// and lets Postgres replay WAL up to the recovery target when it starts again.
+func (p *PostgresEngine) restorePhysical(configFlags *genericclioptions.ConfigFlags, backupName, databaseName string, opts RestoreOptions) error {
+	tool, ok := physicalTools[opts.PhysicalTool]
+	if !ok {
+		return fmt.Errorf("unsupported physical restore tool %q (expected wal-g or pgbackrest)", opts.PhysicalTool)
+	}
+	if !physicalBackupName.MatchString(backupName) {
+		return fmt.Errorf("invalid backup name %q: expected a %s backup name such as LATEST", backupName, opts.PhysicalTool)
+	}
+
+	stsName := opts.StatefulSet
+	if stsName == "" {
+		stsName = opts.ServiceName
+	}
+
+	resolvedVars, err := k8screds.LoadSecretsVars(opts.targetFlags(configFlags), opts.Namespace, opts.SecretKeyRefs, opts.SecretEnvFrom, tool.requiredVars)
+	if err != nil {
+		return fmt.Errorf("failed to load secret vars: %w", err)
+	}
+	k8screds.MergeOptionalVars(resolvedVars, k8screds.LoadOptionalVars(opts.SecretKeyRefs, tool.optionalVars))
+
+	sts, err := getStatefulSet(opts.targetFlags(configFlags), opts.Namespace, stsName)
+	if err != nil {
+		return err
+	}
+	target, err := inspectStatefulSet(sts)
+	if err != nil {
+		return err
+	}
+
+	envSources := append(toEnvSources(resolvedVars), job.EnvVarSource{Name: "PGDATA", Value: &target.PGData})
+	meta := newRunMetadata(p.Name(), databaseName, backupName)
+	phases := withHookPhases(postgresPhysicalPhases(tool, target, opts.Namespace, backupName, opts.RecoveryTargetTime, opts.clusterTimeout()), opts.Hooks)
+
+	recoveryTarget := "end of available WAL"
+	if !opts.RecoveryTargetTime.IsZero() {
+		recoveryTarget = postgresTimestamp(opts.RecoveryTargetTime)
+	}
+
+	if opts.DryRun {
+		logger.Global.Info("🔍 [Dry Run] Initiating validation for physical restore process...")
+		logger.Global.Info("[Dry Run] Target StatefulSet: '%s' (%d replica(s), image '%s')", target.StatefulSet, target.Replicas, target.Image)
+		logger.Global.Info("[Dry Run] Data volume: claim '%s' mounted at '%s', PGDATA '%s'", target.DataVolume.PersistentVolumeClaim.ClaimName, target.DataMount.MountPath, target.PGData)
+		logger.Global.Info("[Dry Run] Base backup: '%s' (%s)", backupName, opts.PhysicalTool)
+		logger.Global.Info("[Dry Run] Recovery target: %s", recoveryTarget)
+		logger.Global.Info("[Dry Run] Namespace: '%s'", opts.Namespace)
+		logger.Global.Info("[Dry Run] Validated secret keys: %v", tool.requiredVars)
+
+		logDryRunPlan(configFlags, opts, meta, envSources, phases)
+
+		logger.Global.Info("✅ [Dry Run] Validation completed successfully. No changes were made.")
+		return nil
+	}
+
+	if target.Replicas > 1 {
+		logger.Global.Warn("⚠️ StatefulSet %s has %d replicas: only ordinal 0 is restored, the other volumes must be re-initialised from it", target.StatefulSet, target.Replicas)
+	}
+	logger.Global.Info("🚀 Starting PostgreSQL physical restore of StatefulSet %s up to %s", target.StatefulSet, recoveryTarget)
+
+	if err := runPhases(opts.targetFlags(configFlags), opts, meta, envSources, phases); err != nil {
+		return err
+	}
+
+	logger.Global.Info("🎉 PostgreSQL physical restore completed successfully!")
+	return nil
+}
+
+func postgresPhysicalPhases(tool physicalTool, target physicalTarget, namespace, backupName string, recoveryTargetTime time.Time, timeout time.Duration) []phase {
+	return []phase{
+		{
+			Name:           "postgres-physical-preflight",
+			Image:          target.Image,
+			Script:         tool.preflight(backupName),
+			Description:    fmt.Sprintf("🔎 Job: Check base backup '%s' exists", backupName),
+			SuccessMessage: fmt.Sprintf("🔎 Base backup '%s' found", backupName),
+			FailureHeader:  "🚫 Base backup not found, the database was not touched",
+		},
+		{
+			Name:        "postgres-scale-down",
+			Description: fmt.Sprintf("⏬ Scale StatefulSet '%s' from %d to 0 replicas", target.StatefulSet, target.Replicas),
+			Action: func(configFlags *genericclioptions.ConfigFlags, _ jobTracker) error {
+				return scaleStatefulSet(configFlags, namespace, target.StatefulSet, 0, timeout)
+			},
+		},
+		{
+			Name:            "postgres-physical-restore",
+			Image:           target.Image,
+			Script:          tool.restore(backupName, recoveryTargetTime),
+			Volumes:         []corev1.Volume{target.DataVolume},
+			VolumeMounts:    []corev1.VolumeMount{target.DataMount},
+			SecurityContext: target.SecurityContext,
+			Description:     fmt.Sprintf("📦 Job: Replace data of '%s' with base backup '%s' and configure recovery", target.DataVolume.PersistentVolumeClaim.ClaimName, backupName),
+			SuccessMessage:  fmt.Sprintf("✅ Base backup '%s' restored, recovery configured", backupName),
+			FailureHeader:   fmt.Sprintf("💣 PostgreSQL physical restore failed, StatefulSet '%s' is left at 0 replicas", target.StatefulSet),
+		},
+		{
+			Name:        "postgres-scale-up",
+			Description: fmt.Sprintf("⏫ Scale StatefulSet '%s' back to %d replica(s) and wait for its pods to be ready (at most %s)", target.StatefulSet, target.Replicas, timeout),
+			Action: func(configFlags *genericclioptions.ConfigFlags, _ jobTracker) error {
+				return scaleStatefulSet(configFlags, namespace, target.StatefulSet, target.Replicas, timeout)
+			},
+		},
+	}
+}
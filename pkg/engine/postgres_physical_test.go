@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/workload"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func postgresStatefulSet() *appsv1.StatefulSet {
+	replicas := int32(1)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "backend"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "exporter", Image: "postgres-exporter"},
+						{
+							Name:         "postgres",
+							Image:        "ghcr.io/zalando/spilo-17:4.0-p2",
+							Env:          []corev1.EnvVar{{Name: "PGDATA", Value: "/home/postgres/pgdata/pgroot/data"}},
+							VolumeMounts: []corev1.VolumeMount{{Name: "pgdata", MountPath: "/home/postgres/pgdata"}},
+						},
+					},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "pgdata"}},
+			},
+		},
+	}
+}
+
+func TestInspectStatefulSet(t *testing.T) {
+	target, err := inspectStatefulSet(postgresStatefulSet())
+	require.NoError(t, err)
+
+	assert.Equal(t, "ghcr.io/zalando/spilo-17:4.0-p2", target.Image)
+	assert.Equal(t, "/home/postgres/pgdata/pgroot/data", target.PGData)
+	assert.Equal(t, "pgdata-postgres-0", target.DataVolume.PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, "/home/postgres/pgdata", target.DataMount.MountPath)
+	assert.Equal(t, int32(1), target.Replicas)
+}
+
+func TestInspectStatefulSet_NoDataVolume(t *testing.T) {
+	sts := postgresStatefulSet()
+	sts.Spec.VolumeClaimTemplates = nil
+
+	_, err := inspectStatefulSet(sts)
+	assert.Error(t, err)
+}
+
+func TestPostgresEngine_Restore_Physical(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"WALG_S3_PREFIX":        "s3://backups/postgres",
+		"AWS_ACCESS_KEY_ID":     "minio",
+		"AWS_SECRET_ACCESS_KEY": "minio123",
+	})
+
+	var steps []string
+	var restoreJob job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		steps = append(steps, spec.JobName[:strings.LastIndex(spec.JobName, "-")])
+		if strings.HasPrefix(spec.JobName, "postgres-physical-restore-") {
+			restoreJob = spec
+		}
+		return nil
+	}
+	getStatefulSet = func(_ *genericclioptions.ConfigFlags, namespace, name string) (*appsv1.StatefulSet, error) {
+		assert.Equal(t, "postgres", name)
+		return postgresStatefulSet(), nil
+	}
+	scaleStatefulSet = func(_ *genericclioptions.ConfigFlags, namespace, name string, replicas int32, timeout time.Duration) error {
+		steps = append(steps, fmt.Sprintf("scale %s/%s=%d", namespace, name, replicas))
+		assert.Equal(t, DefaultClusterTimeout, timeout)
+		return nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		getStatefulSet = workload.GetStatefulSet
+		scaleStatefulSet = workload.ScaleStatefulSet
+	}()
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "LATEST", "", RestoreOptions{
+		Namespace:          "backend",
+		ServiceName:        "postgres",
+		Mode:               "physical",
+		PhysicalTool:       "wal-g",
+		RecoveryTargetTime: time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"postgres-physical-preflight",
+		"scale backend/postgres=0",
+		"postgres-physical-restore",
+		"scale backend/postgres=1",
+	}, steps)
+
+	script := restoreJob.Args[1]
+	assert.Contains(t, script, `wal-g backup-fetch "$PGDATA" 'LATEST'`)
+	assert.Contains(t, script, `restore_command = 'wal-g wal-fetch "%f" "%p"'`)
+	assert.Contains(t, script, "recovery_target_time = '2025-06-16 08:30:00+00'")
+	assert.Equal(t, "ghcr.io/zalando/spilo-17:4.0-p2", restoreJob.Image)
+	require.Len(t, restoreJob.Volumes, 1)
+	assert.Equal(t, "pgdata-postgres-0", restoreJob.Volumes[0].PersistentVolumeClaim.ClaimName)
+}
+
+func TestPostgresEngine_Restore_PhysicalPreflightFailureKeepsStatefulSet(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"PGBACKREST_STANZA":              "main",
+		"PGBACKREST_REPO1_S3_BUCKET":     "backups",
+		"PGBACKREST_REPO1_S3_ENDPOINT":   "minio:9000",
+		"PGBACKREST_REPO1_S3_REGION":     "us-east-1",
+		"PGBACKREST_REPO1_S3_KEY":        "minio",
+		"PGBACKREST_REPO1_S3_KEY_SECRET": "minio123",
+	})
+
+	scaled := false
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		return fmt.Errorf("job '%s' failed", spec.JobName)
+	}
+	getStatefulSet = func(_ *genericclioptions.ConfigFlags, _, _ string) (*appsv1.StatefulSet, error) {
+		return postgresStatefulSet(), nil
+	}
+	scaleStatefulSet = func(_ *genericclioptions.ConfigFlags, _, _ string, _ int32, _ time.Duration) error {
+		scaled = true
+		return nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		getStatefulSet = workload.GetStatefulSet
+		scaleStatefulSet = workload.ScaleStatefulSet
+	}()
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "20250616-020003F", "", RestoreOptions{
+		Namespace:    "backend",
+		ServiceName:  "postgres",
+		Mode:         "physical",
+		PhysicalTool: "pgbackrest",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "postgres-physical-preflight")
+	assert.False(t, scaled)
+}
+
+func TestPgBackRestRestoreScript(t *testing.T) {
+	script := physicalTools["pgbackrest"].restore("20250616-020003F", time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC))
+	assert.Contains(t, script, "--set='20250616-020003F'")
+	assert.Contains(t, script, "--type=time --target='2025-06-16 08:30:00+00' --target-action=promote")
+
+	script = physicalTools["pgbackrest"].restore("latest", time.Time{})
+	assert.NotContains(t, script, "--set")
+	assert.NotContains(t, script, "--type=time")
+}
+
+func TestPhysicalPreflightScript(t *testing.T) {
+	script := physicalTools["wal-g"].preflight("base_000000010000000000000002")
+	assert.Contains(t, script, `awk -v name='base_000000010000000000000002' '$1 == name`)
+
+	script = physicalTools["pgbackrest"].preflight("20250616-020003F")
+	assert.Contains(t, script, `grep -qF '"label":"20250616-020003F"' /tmp/info`)
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "base_1' || true '", "", RestoreOptions{Mode: "physical", PhysicalTool: "wal-g"})
+	assert.EqualError(t, err, `invalid backup name "base_1' || true '": expected a wal-g backup name such as LATEST`)
+}
+
+func TestPostgresEngine_Restore_UnknownMode(t *testing.T) {
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "b", "db", RestoreOptions{Mode: "snapshot"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported postgres restore mode")
+}
@@ -1 +1,213 @@
 package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/masking"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func setPostgresEnv(t *testing.T) {
+	setRequiredEnv(t, map[string]string{
+		"POSTGRES_USER":              "user",
+		"POSTGRES_PASSWORD":          "pass",
+		"POSTGRES_AWS_S3_BACKUP_URI": "s3://backups/postgres",
+		"AWS_ACCESS_KEY_ID":          "minio",
+		"AWS_SECRET_ACCESS_KEY":      "minio123",
+	})
+}
+
+func TestPostgresEngine_Name(t *testing.T) {
+	e := &PostgresEngine{}
+	assert.Equal(t, "postgres", e.Name())
+}
+
+func TestPostgresEngine_Restore_DryRun(t *testing.T) {
+	setPostgresEnv(t)
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName: "postgres-service",
+		Namespace:   "default",
+		DryRun:      true,
+	})
+	assert.NoError(t, err)
+}
+
+func TestPostgresEngine_Restore_RunsPhasesInOrder(t *testing.T) {
+	setPostgresEnv(t)
+	t.Setenv("AWS_ENDPOINT_URL", "http://minio:9000")
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName: "postgres-service",
+		Namespace:   "default",
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 4)
+
+	for i, prefix := range []string{"postgres-preflight-", "postgres-drop-db-", "postgres-create-db-", "postgres-restore-"} {
+		assert.True(t, strings.HasPrefix(created[i].JobName, prefix), created[i].JobName)
+	}
+	assert.Contains(t, created[0].Args[1], `"$POSTGRES_AWS_S3_BACKUP_URI/daily.dump"`)
+	assert.Contains(t, created[0].Args[1], "pg_restore --list")
+
+	envNames := []string{}
+	for _, env := range created[0].EnvVars {
+		envNames = append(envNames, env.Name)
+	}
+	assert.Contains(t, envNames, "AWS_ENDPOINT_URL")
+
+	assert.Equal(t, "postgres", created[3].Labels[LabelPrefix+"engine"])
+	assert.Equal(t, "mydb", created[3].Labels[LabelPrefix+"database"])
+	assert.Equal(t, "daily.dump", created[3].Labels[LabelPrefix+"backup"])
+	assert.Equal(t, "postgres-restore", created[3].Labels[LabelPrefix+"phase"])
+}
+
+func TestPostgresEngine_Restore_Masking(t *testing.T) {
+	setPostgresEnv(t)
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	rules := []masking.Rule{{Table: "users", Column: "ssn", Strategy: masking.Null}}
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName:  "postgres-service",
+		Namespace:    "default",
+		MaskingRules: rules,
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 5)
+	assert.True(t, strings.HasPrefix(created[4].JobName, "postgres-mask-"))
+	assert.Contains(t, created[4].Args[1], "psql --host postgres-service --dbname mydb -v ON_ERROR_STOP=1 --single-transaction <<'SQL'\nUPDATE \"users\" SET \"ssn\" = NULL;\nSQL")
+
+	err = (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "LATEST", "", RestoreOptions{
+		Mode:         "physical",
+		MaskingRules: rules,
+	})
+	assert.ErrorContains(t, err, "only supported by logical restores")
+}
+
+func TestParseS3Listing(t *testing.T) {
+	output := `                           PRE wal/
+2025-06-15 02:00:03    1048576 app-2025-06-15.dump
+2025-06-16 02:00:05    2097152 app-2025-06-16.dump
+2025-06-16 02:00:06       4096 globals.sql
+`
+	backups, err := parseS3Listing(output)
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	assert.Equal(t, "globals.sql", backups[0].Name)
+	assert.Equal(t, int64(2097152), backups[1].Size)
+	assert.Equal(t, time.Date(2025, 6, 16, 2, 0, 5, 0, time.UTC), backups[1].Timestamp)
+}
+
+func TestPostgresEngine_ResolveBackup_LatestOnlyConsidersDumps(t *testing.T) {
+	setPostgresEnv(t)
+
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		return `2025-06-16 02:00:05    2097152 app-2025-06-16.dump
+2025-06-16 02:00:06       4096 globals.sql
+`, nil
+	}
+	defer func() { createJobForOutput = job.CreateJobForOutput }()
+
+	backup, err := (&PostgresEngine{}).ResolveBackup(&genericclioptions.ConfigFlags{}, BackupSelector{Pattern: "latest"}, RestoreOptions{Namespace: "default"})
+	require.NoError(t, err)
+	assert.Equal(t, "app-2025-06-16.dump", backup.Name)
+	assert.Equal(t, int64(2097152), backup.Size)
+}
+
+func TestPostgresEngine_Restore_PreserveGrants(t *testing.T) {
+	setPostgresEnv(t)
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	var snapshot job.JobSpec
+	createJobForOutput = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) (string, error) {
+		snapshot = spec
+		return "GRANT SELECT ON TABLE public.orders TO reporting;\n", nil
+	}
+	defer func() {
+		createJob = job.CreateJob
+		createJobForOutput = job.CreateJobForOutput
+	}()
+
+	var report RunReport
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName:    "postgres-service",
+		Namespace:      "default",
+		PreserveGrants: true,
+		Report:         func(r RunReport) { report = r },
+	})
+	require.NoError(t, err)
+	assert.Contains(t, snapshot.Args[1], "pg_dump --host postgres-service --schema-only --create mydb")
+	require.Len(t, report.Phases, 6)
+	assert.Equal(t, snapshot.JobName, report.Phases[1].Job)
+	assert.Equal(t, []string{"GRANT SELECT ON TABLE public.orders TO reporting;"}, report.Phases[1].Grants)
+	require.Len(t, created, 5)
+	assert.True(t, strings.HasPrefix(created[4].JobName, "postgres-replay-grants-"))
+	assert.Contains(t, created[4].Args[1], "--single-transaction <<'SQL'\nGRANT SELECT ON TABLE public.orders TO reporting;\nSQL")
+
+	err = (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName:    "postgres-service",
+		Namespace:      "default",
+		PreserveGrants: true,
+		MaskingRules:   []masking.Rule{{Table: "users", Column: "ssn", Strategy: masking.Null}},
+		Report:         func(r RunReport) { report = r },
+	})
+	require.NoError(t, err)
+	names := []string{}
+	for _, p := range report.Phases {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"postgres-preflight", "postgres-snapshot-grants", "postgres-drop-db", "postgres-create-db", "postgres-restore", "postgres-mask", "postgres-replay-grants"},
+		names, "the grants are replayed once the data is masked")
+
+	err = (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "LATEST", "", RestoreOptions{
+		Mode:           "physical",
+		PreserveGrants: true,
+	})
+	assert.ErrorContains(t, err, "only supported by logical restores")
+}
+
+func TestPostgresEngine_Restore_Image(t *testing.T) {
+	setPostgresEnv(t)
+
+	var created []job.JobSpec
+	createJob = func(_ *genericclioptions.ConfigFlags, spec job.JobSpec) error {
+		created = append(created, spec)
+		return nil
+	}
+	defer func() { createJob = job.CreateJob }()
+
+	err := (&PostgresEngine{}).Restore(&genericclioptions.ConfigFlags{}, "daily.dump", "mydb", RestoreOptions{
+		ServiceName:  "postgres-service",
+		Namespace:    "default",
+		Image:        "registry.internal/postgres:17",
+		MaskingRules: []masking.Rule{{Table: "users", Column: "email", Strategy: masking.Hash}},
+	})
+	require.NoError(t, err)
+	require.Len(t, created, 5)
+	for _, spec := range created {
+		assert.Equal(t, "registry.internal/postgres:17", spec.Image, spec.JobName)
+	}
+}
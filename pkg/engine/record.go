@@ -0,0 +1,310 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/events"
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// saveRunRecord, emitEvent and whoAmI are swapped in tests to avoid talking to a
+// cluster. Notifications are tested against a local HTTP server instead.
+var (
+	saveRunRecord = history.Save
+	emitEvent     = events.Emit
+	whoAmI        = history.WhoAmI
+)
+
+// runRecorder keeps the history record of a run up to date as its Jobs are
+// created, publishes its lifecycle as Events regarding that record, notifies
+// the webhooks of its start and outcome, exports its metrics and hands its
+// report over. None of them is part of the restore: failing to write them is
+// only reported.
+type runRecorder struct {
+	configFlags   *genericclioptions.ConfigFlags
+	record        history.Record
+	related       *corev1.ObjectReference
+	notifications notify.Config
+	metrics       metrics.Config
+	backupSize    int64
+	phase         string // the step running
+	phases        []metrics.Phase
+	phaseReports  []PhaseReport
+	report        func(RunReport)
+	secrets       []string // values of the variables, never written anywhere
+
+	warned       bool
+	eventWarned  bool
+	notifyWarned bool
+}
+
+func startRunRecord(configFlags *genericclioptions.ConfigFlags, opts RestoreOptions, meta runMetadata, envSources []job.EnvVarSource) *runRecorder {
+	operation := meta.Operation
+	if operation == "" {
+		operation = "restore"
+	}
+
+	r := &runRecorder{
+		configFlags: configFlags,
+		record: history.Record{
+			ID:        meta.RunID,
+			Operation: operation,
+			User:      whoAmI(configFlags),
+			Engine:    meta.Engine,
+			Database:  meta.Database,
+			Backup:    meta.Backup,
+			Namespace: opts.Namespace,
+			Target:    meta.Target,
+			Context:   ContextName(configFlags),
+			Status:    history.StatusRunning,
+			Started:   time.Now().UTC(),
+			Jobs:      []string{},
+		},
+		notifications: opts.Notifications,
+		metrics:       opts.Metrics,
+		backupSize:    opts.BackupSize,
+		report:        opts.Report,
+	}
+	for _, env := range envSources {
+		if env.Value != nil && *env.Value != "" {
+			r.secrets = append(r.secrets, *env.Value)
+		}
+	}
+	// Longest first, so a value is not half-hidden by one it contains.
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	switch {
+	case meta.Target != "":
+	case opts.CHI != "":
+		r.record.Target = "chi/" + opts.CHI
+		r.related = &corev1.ObjectReference{APIVersion: chiGVR.GroupVersion().String(), Kind: "ClickHouseInstallation", Namespace: opts.Namespace, Name: opts.CHI}
+	case opts.ServiceName != "":
+		r.record.Target = opts.ServiceName
+		r.related = &corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Namespace: opts.Namespace, Name: opts.ServiceName}
+	}
+
+	r.save()
+	r.emit(events.ReasonRestoreStarted, false, fmt.Sprintf("%s from backup %s started by %s", r.subject(), r.record.Backup, r.record.User))
+	r.notify(notify.EventStarted)
+	return r
+}
+
+func (r *runRecorder) startPhase(name string) {
+	r.phase = name
+	r.phaseReports = append(r.phaseReports, PhaseReport{Name: name, Started: time.Now().UTC()})
+}
+
+// currentPhase is the report of the step running.
+func (r *runRecorder) currentPhase() *PhaseReport {
+	return &r.phaseReports[len(r.phaseReports)-1]
+}
+
+func (r *runRecorder) observePhase(name string, duration time.Duration) {
+	r.phases = append(r.phases, metrics.Phase{Name: name, Duration: duration})
+}
+
+// observeJob receives the timing of the Job of the step running.
+func (r *runRecorder) observeJob(timing job.Timing) {
+	r.currentPhase().observeJob(timing)
+	r.observePhase(r.phase, timing.Duration())
+}
+
+func (r *runRecorder) endPhase(err error) {
+	p := r.currentPhase()
+	if p.Finished.IsZero() {
+		p.Finished = time.Now().UTC()
+	}
+	p.DurationSeconds = p.Finished.Sub(p.Started).Seconds()
+	p.Status = PhaseSucceeded
+	if err != nil {
+		p.Status = PhaseFailed
+	}
+}
+
+func (r *runRecorder) addJob(name string) {
+	r.record.Jobs = append(r.record.Jobs, name)
+	r.currentPhase().Job = name
+	r.save()
+}
+
+func (r *runRecorder) phaseCompleted(name string) {
+	r.emit(events.ReasonPhaseCompleted, false, fmt.Sprintf("%s: %s completed", r.subject(), name))
+}
+
+// finish records the outcome of the run, skipped being the steps not run.
+func (r *runRecorder) finish(err error, skipped []phase) {
+	r.record.Status = history.StatusSucceeded
+	if err != nil {
+		r.record.Status = history.StatusFailed
+		r.record.Error = r.redact(err.Error())
+	}
+	r.record.Finished = time.Now().UTC()
+	r.save()
+	r.exportMetrics()
+	r.sendReport(skipped)
+
+	duration := r.record.Finished.Sub(r.record.Started).Round(time.Second)
+	if err != nil {
+		r.emit(events.ReasonRestoreFailed, true, fmt.Sprintf("%s failed after %s: %s", r.subject(), duration, r.record.Error))
+		r.notify(notify.EventFailed)
+		return
+	}
+	r.emit(events.ReasonRestoreSucceeded, false, fmt.Sprintf("%s succeeded in %s", r.subject(), duration))
+	r.notify(notify.EventSucceeded)
+}
+
+// minSecretLength is the length under which a value is not replaced in a
+// message: it would mangle the message more than it would hide.
+const minSecretLength = 4
+
+// withheldError replaces a message still holding a short variable value.
+const withheldError = "error withheld, it may contain a credential: see the logs of the run's Jobs"
+
+// redact hides the values of the run's variables, which may be credentials,
+// from a message such as an error. A message holding a value too short to be
+// replaced is withheld as a whole.
+func (r *runRecorder) redact(s string) string {
+	for _, secret := range r.secrets {
+		if len(secret) < minSecretLength {
+			if strings.Contains(s, secret) {
+				return withheldError
+			}
+			continue
+		}
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
+
+// subject names the run in the Events, e.g. "clickhouse restore of shop into clickhouse-service".
+func (r *runRecorder) subject() string {
+	s := r.record.Engine + " " + r.record.Operation
+	if r.record.Database != "" {
+		s += " of " + r.record.Database
+	}
+	if r.record.Operation == "restore" && r.record.Target != "" {
+		s += " into " + r.record.Target
+	}
+	return s
+}
+
+func (r *runRecorder) save() {
+	record := r.record
+	record.Jobs = slices.Clone(r.record.Jobs)
+	if err := saveRunRecord(r.configFlags, record); err != nil && !r.warned {
+		// Warn once, the next saves of the run would most likely fail the same way.
+		r.warned = true
+		logger.Global.Warn("⚠️ Run %s is not recorded in the restore history: %v", r.record.ID, err)
+	}
+}
+
+func (r *runRecorder) emit(reason string, warning bool, note string) {
+	err := emitEvent(r.configFlags, events.Event{
+		Namespace: r.record.Namespace,
+		RunID:     r.record.ID,
+		Action:    strings.ToUpper(r.record.Operation[:1]) + r.record.Operation[1:],
+		Reason:    reason,
+		Warning:   warning,
+		Note:      note,
+		Regarding: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: r.record.Namespace, Name: history.ConfigMapName(r.record.ID)},
+		Related:   r.related,
+		Reporter:  r.record.User,
+	})
+	if err != nil && !r.eventWarned {
+		r.eventWarned = true
+		logger.Global.Warn("⚠️ Events of run %s are not published: %v", r.record.ID, err)
+	}
+}
+
+func (r *runRecorder) notify(event string) {
+	if r.notifications.Empty() {
+		return
+	}
+
+	n := notify.Notification{
+		Event:     event,
+		RunID:     r.record.ID,
+		Operation: r.record.Operation,
+		Engine:    r.record.Engine,
+		Database:  r.record.Database,
+		Backup:    r.record.Backup,
+		Namespace: r.record.Namespace,
+		Target:    r.record.Target,
+		Context:   r.record.Context,
+		User:      r.record.User,
+		Error:     r.record.Error,
+		Started:   r.record.Started,
+		Finished:  r.record.Finished,
+	}
+	if event == notify.EventFailed {
+		n.Phase = r.phase
+	}
+	if !n.Finished.IsZero() {
+		n.DurationSeconds = n.Finished.Sub(n.Started).Seconds()
+	}
+
+	if err := notify.Send(r.notifications, n); err != nil && !r.notifyWarned {
+		r.notifyWarned = true
+		logger.Global.Warn("⚠️ Failed to send the notifications of run %s: %v", r.record.ID, err)
+	}
+}
+
+// sendReport hands the report of the run to RestoreOptions.Report.
+func (r *runRecorder) sendReport(skipped []phase) {
+	if r.report == nil {
+		return
+	}
+
+	phases := slices.Clone(r.phaseReports)
+	for _, p := range skipped {
+		phases = append(phases, PhaseReport{Name: p.Name, Status: PhaseSkipped})
+	}
+	r.report(RunReport{
+		RunID:           r.record.ID,
+		Operation:       r.record.Operation,
+		Engine:          r.record.Engine,
+		Database:        r.record.Database,
+		Backup:          r.record.Backup,
+		Namespace:       r.record.Namespace,
+		Target:          r.record.Target,
+		Context:         r.record.Context,
+		User:            r.record.User,
+		Status:          r.record.Status,
+		Error:           r.record.Error,
+		Started:         r.record.Started,
+		Finished:        r.record.Finished,
+		DurationSeconds: r.record.Finished.Sub(r.record.Started).Seconds(),
+		Phases:          phases,
+	})
+}
+
+// exportMetrics exports the restore's duration and outcome. The backups taken
+// by clone are not restores, they are left out.
+func (r *runRecorder) exportMetrics() {
+	if r.metrics.Empty() || r.record.Operation != "restore" {
+		return
+	}
+
+	err := metrics.Export(r.metrics, metrics.Run{
+		Engine:        r.record.Engine,
+		Namespace:     r.record.Namespace,
+		Database:      r.record.Database,
+		Succeeded:     r.record.Status == history.StatusSucceeded,
+		Finished:      r.record.Finished,
+		Duration:      r.record.Finished.Sub(r.record.Started),
+		Phases:        r.phases,
+		RestoredBytes: r.backupSize,
+	})
+	if err != nil {
+		logger.Global.Warn("⚠️ Failed to export the metrics of run %s: %v", r.record.ID, err)
+	}
+}
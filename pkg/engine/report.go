@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/history"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+)
+
+// Status of a step in a RunReport, spelled as the status of runs.
+const (
+	PhaseSucceeded = history.StatusSucceeded
+	PhaseFailed    = history.StatusFailed
+	PhaseSkipped   = "Skipped" // not run because an earlier step failed
+)
+
+// RunReport is the machine-readable outcome of one run, given to
+// RestoreOptions.Report once the run has ended.
+type RunReport struct {
+	RunID           string        `json:"runId"`
+	Operation       string        `json:"operation"`
+	Engine          string        `json:"engine"`
+	Database        string        `json:"database,omitempty"`
+	Backup          string        `json:"backup,omitempty"` // after any selector was resolved
+	Namespace       string        `json:"namespace"`
+	Target          string        `json:"target,omitempty"`
+	Context         string        `json:"context,omitempty"`
+	User            string        `json:"user,omitempty"`
+	Status          string        `json:"status"` // as in the restore history
+	Error           string        `json:"error,omitempty"`
+	Started         time.Time     `json:"started"`
+	Finished        time.Time     `json:"finished"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Phases          []PhaseReport `json:"phases"`
+}
+
+// PhaseReport is one step of a run. Job, ExitCode and the timing come from the
+// Job of the step when it has one, steps done through the API only have the
+// timing measured by the plugin.
+type PhaseReport struct {
+	Name            string        `json:"name"`
+	Status          string        `json:"status"`
+	Job             string        `json:"job,omitempty"`
+	ExitCode        *int32        `json:"exitCode,omitempty"`
+	Started         time.Time     `json:"started,omitzero"`
+	Finished        time.Time     `json:"finished,omitzero"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Verification    []CheckReport `json:"verification,omitempty"`
+	// Grants are the statements captured by --preserve-grants.
+	Grants []string `json:"grants,omitempty"`
+}
+
+// CheckReport is the result of one --verify-sql check.
+type CheckReport struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Result   string `json:"result"`
+	// QueryFailed is set when the query itself failed, Result then holds its error.
+	QueryFailed bool `json:"queryFailed,omitempty"`
+	Passed      bool `json:"passed"`
+}
+
+func checkReports(results []verify.Result) []CheckReport {
+	reports := make([]CheckReport, 0, len(results))
+	for _, r := range results {
+		reports = append(reports, CheckReport{
+			Name:        r.Check.Name,
+			Expected:    r.Check.Expectation(),
+			Result:      r.Output,
+			QueryFailed: r.Err,
+			Passed:      r.Passed,
+		})
+	}
+	return reports
+}
+
+// observeJob sets the timing and exit code of the step's Job on the report.
+func (p *PhaseReport) observeJob(timing job.Timing) {
+	if !timing.Started.IsZero() {
+		p.Started = timing.Started.UTC()
+	}
+	if !timing.Finished.IsZero() {
+		p.Finished = timing.Finished.UTC()
+	}
+	p.ExitCode = timing.ExitCode
+}
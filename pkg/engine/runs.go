@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"sort"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	RunRunning   = "Running"
+	RunSucceeded = "Succeeded"
+	RunFailed    = "Failed"
+)
+
+// RunJob is one Job created by a run.
+type RunJob struct {
+	Name     string    `json:"name"`
+	Phase    string    `json:"phase"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// Run is a restore (or a clone's backup) as seen from the Jobs it left in the
+// cluster. Steps done directly through the API, such as scaling, have no Job.
+type Run struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	Engine    string    `json:"engine"`
+	Database  string    `json:"database,omitempty"`
+	Backup    string    `json:"backup,omitempty"`
+	Status    string    `json:"status"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitzero"`
+	Jobs      []RunJob  `json:"jobs"`
+}
+
+// listJobs is swapped in tests to avoid talking to a cluster.
+var listJobs = job.ListJobs
+
+// ListRuns returns the runs whose Jobs are in namespace (every namespace when
+// empty), newest first. A non-empty runID only returns that run.
+func ListRuns(configFlags *genericclioptions.ConfigFlags, namespace, runID string) ([]Run, error) {
+	selector := LabelPrefix + "run-id"
+	if runID != "" {
+		selector += "=" + runID
+	}
+
+	jobs, err := listJobs(configFlags, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	return groupRuns(jobs), nil
+}
+
+// groupRuns groups the Jobs by run, each run's Jobs in creation order. A run has
+// failed as soon as one of its Jobs did, and runs while one of them does.
+func groupRuns(jobs []batchv1.Job) []Run {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreationTimestamp.Equal(&jobs[j].CreationTimestamp) {
+			return jobs[i].CreationTimestamp.Before(&jobs[j].CreationTimestamp)
+		}
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	runs := []Run{}
+	index := map[string]int{}
+	for _, j := range jobs {
+		id := j.Labels[LabelPrefix+"run-id"]
+		key := j.Namespace + "/" + id
+		i, ok := index[key]
+		if !ok {
+			backup := j.Annotations[LabelPrefix+"backup"]
+			if backup == "" {
+				backup = j.Labels[LabelPrefix+"backup"]
+			}
+			runs = append(runs, Run{
+				ID:        id,
+				Namespace: j.Namespace,
+				Engine:    j.Labels[LabelPrefix+"engine"],
+				Database:  j.Labels[LabelPrefix+"database"],
+				Backup:    backup,
+				Status:    RunSucceeded,
+				Started:   j.CreationTimestamp.Time,
+			})
+			i = len(runs) - 1
+			index[key] = i
+		}
+
+		rj := runJob(j)
+		run := &runs[i]
+		run.Jobs = append(run.Jobs, rj)
+		switch {
+		case rj.Status == RunFailed:
+			run.Status = RunFailed
+		case rj.Status == RunRunning && run.Status != RunFailed:
+			run.Status = RunRunning
+		}
+		if rj.Finished.After(run.Finished) {
+			run.Finished = rj.Finished
+		}
+	}
+
+	for i := range runs {
+		if runs[i].Status == RunRunning {
+			runs[i].Finished = time.Time{}
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.After(runs[j].Started) })
+	return runs
+}
+
+func runJob(j batchv1.Job) RunJob {
+	rj := RunJob{
+		Name:    j.Name,
+		Phase:   j.Labels[LabelPrefix+"phase"],
+		Status:  RunRunning,
+		Started: j.CreationTimestamp.Time,
+	}
+
+	switch {
+	case j.Status.Succeeded > 0:
+		rj.Status = RunSucceeded
+		if j.Status.CompletionTime != nil {
+			rj.Finished = j.Status.CompletionTime.Time
+		}
+	case j.Status.Failed > 0:
+		rj.Status = RunFailed
+		for _, c := range j.Status.Conditions {
+			if c.Type == batchv1.JobFailed {
+				rj.Finished = c.LastTransitionTime.Time
+			}
+		}
+	}
+	return rj
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+var runStart = time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+
+func testRunJob(name, runID, phase string, offset time.Duration, status batchv1.JobStatus) batchv1.Job {
+	meta := runMetadata{Engine: "clickhouse", Database: "shop", Backup: "daily/2025-06-16", RunID: runID}
+	labels := meta.labels()
+	labels[LabelPrefix+"phase"] = phase
+	return batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "analytics",
+			Labels:            labels,
+			Annotations:       meta.annotations(),
+			CreationTimestamp: metav1.NewTime(runStart.Add(offset)),
+		},
+		Status: status,
+	}
+}
+
+func succeeded(at time.Duration) batchv1.JobStatus {
+	t := metav1.NewTime(runStart.Add(at))
+	return batchv1.JobStatus{Succeeded: 1, CompletionTime: &t}
+}
+
+func TestListRuns(t *testing.T) {
+	var selector string
+	listJobs = func(_ *genericclioptions.ConfigFlags, namespace, sel string) ([]batchv1.Job, error) {
+		selector = sel
+		return []batchv1.Job{
+			testRunJob("clickhouse-restore-2", "run-1", "clickhouse-restore", 20*time.Second, batchv1.JobStatus{
+				Failed:     1,
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, LastTransitionTime: metav1.NewTime(runStart.Add(time.Minute))}},
+			}),
+			testRunJob("clickhouse-preflight-1", "run-1", "clickhouse-preflight", 0, succeeded(10*time.Second)),
+			testRunJob("clickhouse-preflight-3", "run-2", "clickhouse-preflight", time.Hour, batchv1.JobStatus{}),
+		}, nil
+	}
+	defer func() { listJobs = job.ListJobs }()
+
+	runs, err := ListRuns(&genericclioptions.ConfigFlags{}, "analytics", "")
+	require.NoError(t, err)
+	assert.Equal(t, LabelPrefix+"run-id", selector)
+	require.Len(t, runs, 2)
+
+	assert.Equal(t, "run-2", runs[0].ID, "newest first")
+	assert.Equal(t, RunRunning, runs[0].Status)
+	assert.True(t, runs[0].Finished.IsZero())
+
+	assert.Equal(t, "run-1", runs[1].ID)
+	assert.Equal(t, RunFailed, runs[1].Status)
+	assert.Equal(t, "daily/2025-06-16", runs[1].Backup, "exact name from the annotation")
+	assert.Equal(t, runStart.Add(time.Minute), runs[1].Finished)
+	require.Len(t, runs[1].Jobs, 2)
+	assert.Equal(t, "clickhouse-preflight", runs[1].Jobs[0].Phase)
+	assert.Equal(t, RunSucceeded, runs[1].Jobs[0].Status)
+
+	_, err = ListRuns(&genericclioptions.ConfigFlags{}, "analytics", "run-1")
+	require.NoError(t, err)
+	assert.Equal(t, LabelPrefix+"run-id=run-1", selector)
+}
+
+func TestNewRunMetadata(t *testing.T) {
+	a := newRunMetadata("postgres", "shop", "daily.dump")
+	b := newRunMetadata("postgres", "shop", "daily.dump")
+
+	assert.NotEqual(t, a.RunID, b.RunID)
+	assert.Regexp(t, `^\d{8}-\d{6}-[0-9a-f]{6}$`, a.RunID)
+	assert.Equal(t, a.RunID, a.labels()[LabelPrefix+"run-id"])
+}
+
+func TestEngines(t *testing.T) {
+	names := []string{}
+	for _, e := range Engines() {
+		names = append(names, e.Name())
+	}
+	assert.Subset(t, names, []string{"clickhouse", "postgres"})
+	assert.IsIncreasing(t, names)
+
+	vars := (&PostgresEngine{}).Vars()
+	require.Len(t, vars, 4)
+	assert.Equal(t, "physical (wal-g)", vars[2].Mode)
+	assert.Contains(t, vars[2].Required, "WALG_S3_PREFIX")
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultValidationTolerance is the relative difference allowed between expected
+// and restored sizes: background merges change a table's bytes on disk.
+const DefaultValidationTolerance = 0.05
+
+// TableStats is the size of one table. Nil fields are not checked.
+type TableStats struct {
+	Table string `json:"table"`
+	Rows  *int64 `json:"rows,omitempty"`
+	Bytes *int64 `json:"bytes,omitempty"`
+}
+
+// ValidationManifest lists the expected size of each table of a database,
+// captured before the backup was taken.
+type ValidationManifest struct {
+	Tables []TableStats `json:"tables"`
+}
+
+// Validation configures the check run after a restore.
+type Validation struct {
+	// Manifest replaces the expectations read from the backup metadata.
+	Manifest  *ValidationManifest
+	Tolerance float64
+}
+
+func LoadValidationManifest(path string) (*ValidationManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validation manifest: %w", err)
+	}
+
+	var manifest ValidationManifest
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid validation manifest %s: %w", path, err)
+	}
+	if len(manifest.Tables) == 0 {
+		return nil, fmt.Errorf("invalid validation manifest %s: no tables", path)
+	}
+	return &manifest, nil
+}
+
+// statsRow is one JSONEachRow line printed by a validation Job.
+type statsRow struct {
+	Source string `json:"source"` // "backup" or "restored"
+	Table  string `json:"table"`
+	Rows   *int64 `json:"rows"`
+	Bytes  *int64 `json:"bytes"`
+}
+
+// parseStatsRows splits the output of a validation Job into the expected
+// (backup) and restored table sizes.
+func parseStatsRows(output string) (expected, restored []TableStats, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var row statsRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse validation line %q: %w", line, err)
+		}
+
+		stats := TableStats{Table: row.Table, Rows: row.Rows, Bytes: row.Bytes}
+		switch row.Source {
+		case "backup":
+			expected = append(expected, stats)
+		case "restored":
+			restored = append(restored, stats)
+		default:
+			return nil, nil, fmt.Errorf("unexpected validation source %q", row.Source)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read validation output: %w", err)
+	}
+	return expected, restored, nil
+}
+
+// tableDiff is one value that diverges beyond the tolerance.
+type tableDiff struct {
+	Table    string
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// compareTableStats returns the differences between expected and restored sizes.
+// A table missing on either side diverges, unless it was restored empty.
+func compareTableStats(expected, restored []TableStats, tolerance float64) []tableDiff {
+	restoredByTable := map[string]TableStats{}
+	for _, s := range restored {
+		restoredByTable[s.Table] = s
+	}
+
+	diffs := []tableDiff{}
+	seen := map[string]bool{}
+	for _, want := range expected {
+		seen[want.Table] = true
+		got, ok := restoredByTable[want.Table]
+		if !ok {
+			diffs = append(diffs, tableDiff{Table: want.Table, Field: "table", Expected: "present", Actual: "missing"})
+			continue
+		}
+		for _, f := range []struct {
+			name      string
+			want, got *int64
+		}{
+			{"rows", want.Rows, got.Rows},
+			{"bytes", want.Bytes, got.Bytes},
+		} {
+			if f.want == nil {
+				continue
+			}
+			actual := int64(0)
+			if f.got != nil {
+				actual = *f.got
+			}
+			if !withinTolerance(*f.want, actual, tolerance) {
+				diffs = append(diffs, tableDiff{Table: want.Table, Field: f.name, Expected: fmt.Sprint(*f.want), Actual: fmt.Sprint(actual)})
+			}
+		}
+	}
+
+	for _, got := range restored {
+		if seen[got.Table] || (isZero(got.Rows) && isZero(got.Bytes)) {
+			continue
+		}
+		diffs = append(diffs, tableDiff{Table: got.Table, Field: "table", Expected: "absent", Actual: "present"})
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Table < diffs[j].Table })
+	return diffs
+}
+
+func isZero(v *int64) bool {
+	return v == nil || *v == 0
+}
+
+func withinTolerance(expected, actual int64, tolerance float64) bool {
+	if expected == 0 {
+		return actual == 0
+	}
+	return math.Abs(float64(actual-expected)) <= tolerance*float64(expected)
+}
+
+// checkTableStats logs the comparison and fails when any table diverges.
+func checkTableStats(databaseName string, expected, restored []TableStats, tolerance float64) error {
+	diffs := compareTableStats(expected, restored, tolerance)
+	if len(diffs) == 0 {
+		logger.Global.Info("🧮 %d table(s) of '%s' match the expected sizes (tolerance %.1f%%)", len(restored), databaseName, tolerance*100)
+		return nil
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TABLE\tFIELD\tEXPECTED\tRESTORED")
+	for _, d := range diffs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Table, d.Field, d.Expected, d.Actual)
+	}
+	_ = w.Flush()
+	logger.Global.Instructions("🧮 Restored database '%s' differs from the expected sizes:\n%s", databaseName, b.String())
+
+	return fmt.Errorf("%d difference(s) beyond the %.1f%% tolerance", len(diffs), tolerance*100)
+}
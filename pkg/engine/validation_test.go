@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func n(v int64) *int64 {
+	return &v
+}
+
+func TestParseStatsRows(t *testing.T) {
+	expected, restored, err := parseStatsRows(`{"source":"restored","table":"events","rows":1000,"bytes":52000}
+{"source":"backup","table":"events","rows":null,"bytes":51000}
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []TableStats{{Table: "events", Bytes: n(51000)}}, expected)
+	assert.Equal(t, []TableStats{{Table: "events", Rows: n(1000), Bytes: n(52000)}}, restored)
+
+	_, _, err = parseStatsRows(`{"source":"elsewhere","table":"events"}`)
+	assert.Error(t, err)
+}
+
+func TestCompareTableStats(t *testing.T) {
+	expected := []TableStats{
+		{Table: "events", Rows: n(1000), Bytes: n(50000)},
+		{Table: "users", Rows: n(10)},
+		{Table: "sessions", Bytes: n(100)},
+	}
+	restored := []TableStats{
+		{Table: "events", Rows: n(1000), Bytes: n(52000)},
+		{Table: "users", Rows: n(8), Bytes: n(300)},
+		{Table: "tmp_import", Rows: n(0), Bytes: n(0)},
+		{Table: "orphans", Rows: n(5), Bytes: n(64)},
+	}
+
+	assert.Equal(t, []tableDiff{
+		{Table: "orphans", Field: "table", Expected: "absent", Actual: "present"},
+		{Table: "sessions", Field: "table", Expected: "present", Actual: "missing"},
+		{Table: "users", Field: "rows", Expected: "10", Actual: "8"},
+	}, compareTableStats(expected, restored, 0.05))
+
+	assert.Len(t, compareTableStats(expected, restored, 0.01), 4, "events bytes are 4% off")
+}
+
+func TestWithinTolerance(t *testing.T) {
+	assert.True(t, withinTolerance(0, 0, 0.05))
+	assert.False(t, withinTolerance(0, 1, 0.05))
+	assert.True(t, withinTolerance(100, 105, 0.05))
+	assert.False(t, withinTolerance(100, 94, 0.05))
+}
+
+func TestLoadValidationManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("tables:\n  - {table: events, rows: 1000}\n"), 0o600))
+
+	manifest, err := LoadValidationManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, []TableStats{{Table: "events", Rows: n(1000)}}, manifest.Tables)
+
+	require.NoError(t, os.WriteFile(path, []byte("tables: []\n"), 0o600))
+	_, err = LoadValidationManifest(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	"github.com/wiremind/kubectl-db-restore/pkg/verify"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// verificationPhase runs the checks of opts.Checks in one Job and reports each of
+// them. run is the engine's client command, reading a query on stdin.
+func verificationPhase(meta runMetadata, image, header, run string, opts RestoreOptions, envSources []job.EnvVarSource) phase {
+	checks := opts.Checks
+
+	labels := meta.labels()
+	labels[LabelPrefix+"phase"] = meta.Engine + "-verify"
+
+	// Set by the Action for the report.
+	var results []verify.Result
+
+	return phase{
+		Name:        meta.Engine + "-verify",
+		Description: fmt.Sprintf("🧪 Job: Run %d verification check(s) against '%s'", len(checks), meta.Database),
+		Action: func(configFlags *genericclioptions.ConfigFlags, track jobTracker) error {
+			spec := job.JobSpec{
+				Namespace:         opts.Namespace,
+				JobName:           jobName(meta.Engine + "-verify"),
+				Image:             image,
+				Command:           []string{"/bin/sh"},
+				Args:              []string{"-c", header + verify.Script(checks, run)},
+				EnvVars:           envSources,
+				EnvFrom:           opts.SecretEnvFrom,
+				Labels:            labels,
+				Annotations:       meta.annotations(),
+				JobSuccessMessage: "🧪 Verification queries completed",
+				JobFailureHeader:  "💥 Failed to run the verification queries",
+				Overrides:         opts.JobOverrides,
+			}
+			track(&spec)
+			output, err := createJobForOutput(configFlags, spec)
+			if err != nil {
+				return err
+			}
+
+			results = verify.ParseOutput(checks, output)
+			table, err := verify.Report(results)
+			logger.Global.Instructions("🧪 Verification of '%s':\n%s", meta.Database, table)
+			return err
+		},
+		Report: func(report *PhaseReport) {
+			if results != nil {
+				report.Verification = checkReports(results)
+			}
+		},
+	}
+}
+
+// logDryRunChecks lists the verification queries in a dry-run plan.
+func logDryRunChecks(checks []verify.Check) {
+	if len(checks) == 0 {
+		return
+	}
+	logger.Global.Info("[Dry Run] Verification checks:")
+	for _, c := range checks {
+		logger.Global.Info("  - %s: expect %s", c.Name, c.Expectation())
+	}
+}
@@ -0,0 +1,117 @@
+// Package events publishes the lifecycle of restores as events.k8s.io/v1
+// Events, for the event pipelines already watching the cluster.
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons are stable identifiers, meant to be matched by alerting rules.
+const (
+	ReasonRestoreStarted   = "RestoreStarted"
+	ReasonPhaseCompleted   = "PhaseCompleted"
+	ReasonRestoreFailed    = "RestoreFailed"
+	ReasonRestoreSucceeded = "RestoreSucceeded"
+)
+
+// ReportingController is the controller named in the Events, as Kubernetes
+// expects: a qualified name.
+const ReportingController = "db-restore.wiremind.io/kubectl-db-restore"
+
+// maxNoteLength is the size limit of an Event's note.
+const maxNoteLength = 1024
+
+// Event is one step of a run. Regarding is the object the Event is about, the
+// record of the run, and Related the object restored into, when known.
+type Event struct {
+	Namespace string
+	RunID     string
+	Action    string // "Restore", or "Backup" for the first half of a clone
+	Reason    string
+	Warning   bool
+	Note      string
+	Regarding corev1.ObjectReference
+	Related   *corev1.ObjectReference
+	Reporter  string // the user running the restore
+}
+
+func newClientset(configFlags *genericclioptions.ConfigFlags) (kubernetes.Interface, error) {
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes REST config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	return clientset, nil
+}
+
+func Emit(configFlags *genericclioptions.ConfigFlags, event Event) error {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return err
+	}
+
+	return EmitWithClient(clientset, event)
+}
+
+func EmitWithClient(clientset kubernetes.Interface, event Event) error {
+	eventType := corev1.EventTypeNormal
+	if event.Warning {
+		eventType = corev1.EventTypeWarning
+	}
+	note := event.Note
+	if len(note) > maxNoteLength {
+		note = note[:maxNoteLength-3] + "..."
+	}
+	now := time.Now()
+
+	e := &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Like the events of client-go's recorders: the object and a timestamp.
+			Name:      fmt.Sprintf("%s.%x", event.Regarding.Name, now.UnixNano()),
+			Namespace: event.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by":  "kubectl-db-restore",
+				"db-restore.wiremind.io/run-id": event.RunID,
+			},
+		},
+		EventTime:           metav1.NewMicroTime(now),
+		ReportingController: ReportingController,
+		ReportingInstance:   reportingInstance(event.Reporter),
+		Action:              event.Action,
+		Reason:              event.Reason,
+		Regarding:           event.Regarding,
+		Related:             event.Related,
+		Note:                note,
+		Type:                eventType,
+	}
+
+	if _, err := clientset.EventsV1().Events(event.Namespace).Create(context.TODO(), e, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create %s event: %w", event.Reason, err)
+	}
+	return nil
+}
+
+// reportingInstance is limited to 128 characters by the API server.
+func reportingInstance(reporter string) string {
+	instance := "kubectl-db-restore"
+	if reporter != "" {
+		instance += "/" + reporter
+	}
+	if len(instance) > 128 {
+		instance = instance[:128]
+	}
+	return instance
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEmitWithClient(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+
+	err := EmitWithClient(client, Event{
+		Namespace: "analytics",
+		RunID:     "20250616-083000-a1b2c3",
+		Action:    "Restore",
+		Reason:    ReasonRestoreFailed,
+		Warning:   true,
+		Note:      strings.Repeat("x", 2000),
+		Regarding: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "analytics", Name: "db-restore-run-20250616-083000-a1b2c3"},
+		Related:   &corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Namespace: "analytics", Name: "clickhouse"},
+		Reporter:  "alice",
+	})
+	require.NoError(t, err)
+
+	list, err := client.EventsV1().Events("analytics").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	e := list.Items[0]
+	assert.True(t, strings.HasPrefix(e.Name, "db-restore-run-20250616-083000-a1b2c3."))
+	assert.Equal(t, "20250616-083000-a1b2c3", e.Labels["db-restore.wiremind.io/run-id"])
+	assert.Equal(t, corev1.EventTypeWarning, e.Type)
+	assert.Equal(t, ReasonRestoreFailed, e.Reason)
+	assert.Equal(t, "Restore", e.Action)
+	assert.Equal(t, ReportingController, e.ReportingController)
+	assert.Equal(t, "kubectl-db-restore/alice", e.ReportingInstance)
+	assert.Equal(t, "clickhouse", e.Related.Name)
+	assert.Len(t, e.Note, maxNoteLength)
+	assert.False(t, e.EventTime.IsZero())
+}
@@ -0,0 +1,179 @@
+// Package history keeps an audit trail of restores in the cluster: one
+// ConfigMap per run, in the namespace restored into, that outlives its Jobs.
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LabelPrefix matches the prefix of the labels set on restore Jobs.
+	LabelPrefix = "db-restore.wiremind.io/"
+	// RecordLabel marks the ConfigMaps holding a run record.
+	RecordLabel = LabelPrefix + "record"
+
+	dataKey = "run.json"
+)
+
+const (
+	StatusRunning   = "Running"
+	StatusSucceeded = "Succeeded"
+	StatusFailed    = "Failed"
+)
+
+// Record is what is kept of a run: who started it, against what, and how it ended.
+type Record struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"` // restore, or backup for the first half of a clone
+	User      string    `json:"user"`
+	Engine    string    `json:"engine"`
+	Database  string    `json:"database,omitempty"`
+	Backup    string    `json:"backup,omitempty"`
+	Namespace string    `json:"namespace"`
+	Target    string    `json:"target,omitempty"` // service, CHI or cluster restored into
+	Context   string    `json:"context,omitempty"`
+	Status    string    `json:"status"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitzero"`
+	Jobs      []string  `json:"jobs"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func newClientset(configFlags *genericclioptions.ConfigFlags) (kubernetes.Interface, error) {
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes REST config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	return clientset, nil
+}
+
+// ConfigMapName is the name of the ConfigMap holding the record of a run.
+func ConfigMapName(runID string) string {
+	return "db-restore-run-" + runID
+}
+
+// Save creates the record of the run, or updates it once the run goes on.
+func Save(configFlags *genericclioptions.ConfigFlags, record Record) error {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return err
+	}
+
+	return SaveWithClient(clientset, record)
+}
+
+func SaveWithClient(clientset kubernetes.Interface, record Record) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run record: %w", err)
+	}
+
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "kubectl-db-restore",
+		RecordLabel:                    "run",
+		LabelPrefix + "run-id":         record.ID,
+		LabelPrefix + "status":         record.Status,
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ConfigMapName(record.ID),
+			Namespace: record.Namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{dataKey: string(data)},
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(record.Namespace)
+	_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save run record %s: %w", cm.Name, err)
+	}
+	return nil
+}
+
+// List returns the records of the runs in namespace (every namespace when
+// empty), newest first.
+func List(configFlags *genericclioptions.ConfigFlags, namespace string) ([]Record, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	return ListWithClient(clientset, namespace)
+}
+
+func ListWithClient(clientset kubernetes.Interface, namespace string) ([]Record, error) {
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: RecordLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list run records: %w", err)
+	}
+
+	records := []Record{}
+	for _, cm := range configMaps.Items {
+		var record Record
+		if err := json.Unmarshal([]byte(cm.Data[dataKey]), &record); err != nil {
+			return nil, fmt.Errorf("invalid run record %s/%s: %w", cm.Namespace, cm.Name, err)
+		}
+		records = append(records, record)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Started.After(records[j].Started) })
+	return records, nil
+}
+
+// WhoAmI returns the user the API server authenticates the kubeconfig as,
+// or the kubeconfig user's name when the server cannot tell (before 1.28).
+func WhoAmI(configFlags *genericclioptions.ConfigFlags) string {
+	clientset, err := newClientset(configFlags)
+	if err == nil {
+		if user := WhoAmIWithClient(clientset); user != "" {
+			return user
+		}
+	}
+
+	rawConfig, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return "unknown"
+	}
+	contextName := rawConfig.CurrentContext
+	if configFlags.Context != nil && *configFlags.Context != "" {
+		contextName = *configFlags.Context
+	}
+	if configFlags.AuthInfoName != nil && *configFlags.AuthInfoName != "" {
+		return *configFlags.AuthInfoName
+	}
+	if kubeContext, ok := rawConfig.Contexts[contextName]; ok && kubeContext.AuthInfo != "" {
+		return kubeContext.AuthInfo
+	}
+	return "unknown"
+}
+
+// WhoAmIWithClient asks the API server with a SelfSubjectReview, returning ""
+// when it cannot.
+func WhoAmIWithClient(clientset kubernetes.Interface) string {
+	review, err := clientset.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(), &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(review.Status.UserInfo.Username)
+}
@@ -0,0 +1,54 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSaveAndListWithClient(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+
+	older := Record{
+		ID: "20250615-020000-d4e5f6", Operation: "restore", User: "alice", Engine: "postgres", Database: "billing",
+		Namespace: "analytics", Status: StatusSucceeded, Started: time.Date(2025, 6, 15, 2, 0, 0, 0, time.UTC),
+	}
+	running := Record{
+		ID: "20250616-083000-a1b2c3", Operation: "restore", User: "bob", Engine: "clickhouse", Database: "shop",
+		Backup: "daily-2025-06-16", Namespace: "analytics", Target: "clickhouse-service", Status: StatusRunning,
+		Started: time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC), Jobs: []string{"clickhouse-drop-db-1"},
+	}
+	require.NoError(t, SaveWithClient(client, older))
+	require.NoError(t, SaveWithClient(client, running))
+
+	finished := running
+	finished.Status = StatusFailed
+	finished.Finished = time.Date(2025, 6, 16, 8, 32, 0, 0, time.UTC)
+	finished.Jobs = []string{"clickhouse-drop-db-1", "clickhouse-restore-2"}
+	finished.Error = "failed to create clickhouse-restore job: boom"
+	require.NoError(t, SaveWithClient(client, finished), "a second save updates the record")
+
+	cm, err := client.CoreV1().ConfigMaps("analytics").Get(context.TODO(), "db-restore-run-20250616-083000-a1b2c3", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "run", cm.Labels[RecordLabel])
+	assert.Equal(t, StatusFailed, cm.Labels[LabelPrefix+"status"])
+
+	records, err := ListWithClient(client, "")
+	require.NoError(t, err)
+	assert.Equal(t, []Record{finished, older}, records, "newest first")
+
+	records, err = ListWithClient(client, "staging")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestWhoAmIWithClient_Unsupported(t *testing.T) {
+	// The fake clientset answers with an empty review, like a server that
+	// does not know the user.
+	assert.Equal(t, "", WhoAmIWithClient(k8sfake.NewSimpleClientset()))
+}
@@ -3,6 +3,7 @@ package job
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
@@ -27,24 +28,182 @@ type JobSpec struct {
 	Command           []string
 	Args              []string
 	EnvVars           []EnvVarSource
+	EnvFrom           []k8screds.SecretEnvFrom // Secrets loaded whole, EnvVars win over them
+	Labels            map[string]string        // set on the Job and its pod
+	Annotations       map[string]string
+	Volumes           []corev1.Volume
+	VolumeMounts      []corev1.VolumeMount
+	SecurityContext   *corev1.PodSecurityContext
 	JobSuccessMessage string
 	JobFailureHeader  string
+	Overrides         *Overrides
+	// Ephemeral Jobs only read something for the plugin: CreateJobForOutput
+	// deletes them once it has their output, and a failed one, kept to be
+	// looked into, is removed by Kubernetes after ephemeralTTL.
+	Ephemeral bool
+
+	// Observe, when set, is called with the Job's timing once it has finished.
+	Observe func(Timing)
+	// Watch, when set, is called with the last lines of the Job's logs each time
+	// its status is polled, for Jobs reporting their own progress.
+	Watch func(logs string)
 }
 
-func CreateJob(configFlags *genericclioptions.ConfigFlags, spec JobSpec) error {
+// Timing is when a Job's pod started and when the Job finished, as the Job
+// status reports them.
+type Timing struct {
+	Started   time.Time
+	Finished  time.Time
+	Succeeded bool
+	// ExitCode is the exit status of the Job's last pod, nil when it is unknown.
+	ExitCode *int32
+}
+
+// Duration is the time the Job ran, zero when the status lacks a timestamp.
+func (t Timing) Duration() time.Duration {
+	if t.Started.IsZero() || t.Finished.IsZero() {
+		return 0
+	}
+	return t.Finished.Sub(t.Started)
+}
+
+// Overrides customise the pod of every Job of a run, e.g. to schedule it on
+// dedicated nodes or give it the service account allowed to reach the bucket.
+type Overrides struct {
+	ServiceAccountName string                       `json:"serviceAccountName,omitempty"`
+	NodeSelector       map[string]string            `json:"nodeSelector,omitempty"`
+	Tolerations        []corev1.Toleration          `json:"tolerations,omitempty"`
+	Resources          *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// apply sets the overridden fields on the pod.
+func (o *Overrides) apply(pod *corev1.PodSpec) {
+	if o == nil {
+		return
+	}
+	if o.ServiceAccountName != "" {
+		pod.ServiceAccountName = o.ServiceAccountName
+	}
+	if len(o.NodeSelector) > 0 {
+		pod.NodeSelector = o.NodeSelector
+	}
+	if len(o.Tolerations) > 0 {
+		pod.Tolerations = o.Tolerations
+	}
+	if o.Resources != nil {
+		for i := range pod.Containers {
+			pod.Containers[i].Resources = *o.Resources
+		}
+	}
+}
+
+func newClientset(configFlags *genericclioptions.ConfigFlags) (kubernetes.Interface, error) {
 	restConfig, err := configFlags.ToRESTConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get Kubernetes REST config: %w", err)
+		return nil, fmt.Errorf("failed to get Kubernetes REST config: %w", err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	return clientset, nil
+}
+
+func CreateJob(configFlags *genericclioptions.ConfigFlags, spec JobSpec) error {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return err
 	}
 
 	return CreateJobWithClient(clientset, spec)
 }
 
+// CreateJobForOutput runs the Job to completion and returns what its pod printed,
+// for jobs whose purpose is to query something rather than change it.
+func CreateJobForOutput(configFlags *genericclioptions.ConfigFlags, spec JobSpec) (string, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return "", err
+	}
+
+	return CreateJobForOutputWithClient(clientset, spec)
+}
+
+func CreateJobForOutputWithClient(clientset kubernetes.Interface, spec JobSpec) (string, error) {
+	if err := CreateJobWithClient(clientset, spec); err != nil {
+		return "", err
+	}
+
+	output, err := GetJobLogs(clientset, spec.Namespace, spec.JobName)
+	if err != nil || !spec.Ephemeral {
+		return output, err
+	}
+
+	// Background propagation deletes the pod along with the Job.
+	propagation := metav1.DeletePropagationBackground
+	if err := clientset.BatchV1().Jobs(spec.Namespace).Delete(context.TODO(), spec.JobName, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+		logger.Global.Warn("⚠️ Failed to delete Job %s, Kubernetes removes it after %s: %v", spec.JobName, ephemeralTTL, err)
+	}
+	return output, nil
+}
+
+// ListJobs returns the Jobs matching the label selector, in every namespace when
+// namespace is empty.
+func ListJobs(configFlags *genericclioptions.ConfigFlags, namespace, selector string) ([]batchv1.Job, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	return ListJobsWithClient(clientset, namespace, selector)
+}
+
+func ListJobsWithClient(clientset kubernetes.Interface, namespace, selector string) ([]batchv1.Job, error) {
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+	return jobs.Items, nil
+}
+
+// ReadJobLogs is GetJobLogs on the cluster the flags point at.
+func ReadJobLogs(configFlags *genericclioptions.ConfigFlags, namespace, jobName string) (string, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return "", err
+	}
+
+	return GetJobLogs(clientset, namespace, jobName)
+}
+
+// GetJobLogs returns the concatenated logs of the pods created for a Job.
+func GetJobLogs(clientset kubernetes.Interface, namespace, jobName string) (string, error) {
+	podClient := clientset.CoreV1().Pods(namespace)
+
+	pods, err := podClient.List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods of Job %s: %w", jobName, err)
+	}
+
+	var logs strings.Builder
+	for _, pod := range pods.Items {
+		raw, err := podClient.GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(context.TODO())
+		if err != nil {
+			return "", fmt.Errorf("failed to get logs of pod %s: %w", pod.Name, err)
+		}
+		logs.Write(raw)
+	}
+
+	return logs.String(), nil
+}
+
+// ephemeralTTL is how long a finished ephemeral Job is kept.
+const ephemeralTTL = time.Hour
+
 func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 	// Build env vars
 	envVars := []corev1.EnvVar{}
@@ -69,23 +228,42 @@ func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 		}
 	}
 
+	var envFrom []corev1.EnvFromSource
+	for _, from := range spec.EnvFrom {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			Prefix: from.Prefix,
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: from.SecretName},
+			},
+		})
+	}
+
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      spec.JobName,
-			Namespace: spec.Namespace,
+			Name:        spec.JobName,
+			Namespace:   spec.Namespace,
+			Labels:      spec.Labels,
+			Annotations: spec.Annotations,
 		},
 		Spec: batchv1.JobSpec{
 			BackoffLimit: int32Ptr(0),
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: spec.Labels,
+				},
 				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyNever,
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: spec.SecurityContext,
+					Volumes:         spec.Volumes,
 					Containers: []corev1.Container{
 						{
-							Name:    "task",
-							Image:   spec.Image,
-							Command: spec.Command,
-							Args:    spec.Args,
-							Env:     envVars,
+							Name:         "task",
+							Image:        spec.Image,
+							Command:      spec.Command,
+							Args:         spec.Args,
+							EnvFrom:      envFrom,
+							Env:          envVars,
+							VolumeMounts: spec.VolumeMounts,
 						},
 					},
 				},
@@ -93,13 +271,20 @@ func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 		},
 	}
 
+	spec.Overrides.apply(&job.Spec.Template.Spec)
+	if spec.Ephemeral {
+		job.Spec.TTLSecondsAfterFinished = int32Ptr(int32(ephemeralTTL.Seconds()))
+	}
+
 	jobClient := clientset.BatchV1().Jobs(spec.Namespace)
 	_, err := jobClient.Create(context.TODO(), job, metav1.CreateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to create Job: %w", err)
 	}
 
-	fmt.Printf("✅ Created Job %s in namespace %s\n", spec.JobName, spec.Namespace)
+	logger.Global.Info("✅ Created Job %s in namespace %s", spec.JobName, spec.Namespace)
+	logger.Global.Debug("🔍 Job %s runs image %s", spec.JobName, spec.Image)
+	logger.Global.Info("⏳ Waiting for Job to complete...")
 
 	// Watch job status
 	for {
@@ -109,6 +294,7 @@ func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 		}
 
 		if jobStatus.Status.Succeeded > 0 {
+			spec.observe(clientset, jobStatus, true)
 			msg := spec.JobSuccessMessage
 			if msg == "" {
 				msg = "🎉 Job completed successfully!"
@@ -118,6 +304,7 @@ func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 		}
 
 		if jobStatus.Status.Failed > 0 {
+			spec.observe(clientset, jobStatus, false)
 			var failMsg string
 			for _, c := range jobStatus.Status.Conditions {
 				if c.Type == batchv1.JobFailed {
@@ -147,11 +334,97 @@ func CreateJobWithClient(clientset kubernetes.Interface, spec JobSpec) error {
 			return fmt.Errorf("job '%s' failed", spec.JobName)
 		}
 
-		logger.Global.Info("⏳ Waiting for Job to complete...")
+		logger.Global.Debug("⏳ Job %s: %d pod(s) active", spec.JobName, jobStatus.Status.Active)
+		if spec.Watch != nil && jobStatus.Status.Active > 0 {
+			spec.Watch(tailJobLogs(clientset, spec.Namespace, spec.JobName))
+		}
 		time.Sleep(3 * time.Second)
 	}
 
 	return nil
 }
 
+// observe reports the timing of the finished Job to spec.Observe.
+func (spec JobSpec) observe(clientset kubernetes.Interface, j *batchv1.Job, succeeded bool) {
+	if spec.Observe == nil {
+		return
+	}
+
+	timing := Timing{Succeeded: succeeded, ExitCode: lastExitCode(clientset, spec.Namespace, spec.JobName)}
+	if j.Status.StartTime != nil {
+		timing.Started = j.Status.StartTime.Time
+	}
+	if j.Status.CompletionTime != nil {
+		timing.Finished = j.Status.CompletionTime.Time
+	}
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobFailed && timing.Finished.IsZero() {
+			timing.Finished = c.LastTransitionTime.Time
+		}
+	}
+	spec.Observe(timing)
+}
+
+// watchedLines is how many lines of a watched Job's logs are read on each poll.
+const watchedLines = 20
+
+// tailJobLogs returns the last lines of the logs of the Job's running pods,
+// empty while they have not started.
+func tailJobLogs(clientset kubernetes.Interface, namespace, jobName string) string {
+	podClient := clientset.CoreV1().Pods(namespace)
+	pods, err := podClient.List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+	})
+	if err != nil {
+		return ""
+	}
+
+	var logs strings.Builder
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		raw, err := podClient.GetLogs(pod.Name, &corev1.PodLogOptions{TailLines: int64Ptr(watchedLines)}).DoRaw(context.TODO())
+		if err == nil {
+			logs.Write(raw)
+		}
+	}
+	return logs.String()
+}
+
+// lastExitCode returns the exit code of the task container of the Job's most
+// recent pod. The pods may already be gone, the exit code is then unknown.
+func lastExitCode(clientset kubernetes.Interface, namespace, jobName string) *int32 {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+	})
+	if err != nil {
+		return nil
+	}
+
+	var last *corev1.Pod
+	for i, pod := range pods.Items {
+		if last == nil || last.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			last = &pods.Items[i]
+		}
+	}
+	if last == nil {
+		return nil
+	}
+	for _, c := range last.Status.ContainerStatuses {
+		if c.Name != "task" {
+			continue
+		}
+		if c.State.Terminated != nil {
+			return int32Ptr(c.State.Terminated.ExitCode)
+		}
+		if c.LastTerminationState.Terminated != nil {
+			return int32Ptr(c.LastTerminationState.Terminated.ExitCode)
+		}
+	}
+	return nil
+}
+
 func int32Ptr(i int32) *int32 { return &i }
+
+func int64Ptr(i int64) *int64 { return &i }
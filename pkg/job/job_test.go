@@ -4,12 +4,18 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/k8screds"
 )
 
 func TestCreateJobWithClient_Success(t *testing.T) {
@@ -22,6 +28,7 @@ func TestCreateJobWithClient_Success(t *testing.T) {
 		Command:   []string{"/bin/sh"},
 		Args:      []string{"-c", "echo Hello"},
 		EnvVars:   []EnvVarSource{},
+		EnvFrom:   []k8screds.SecretEnvFrom{{SecretName: "ch-credentials", Prefix: "CLICKHOUSE_"}},
 	}
 
 	// Simulate successful job status *after* it is created
@@ -37,6 +44,13 @@ func TestCreateJobWithClient_Success(t *testing.T) {
 
 	err := CreateJobWithClient(client, spec)
 	assert.NoError(t, err)
+
+	job, err := client.BatchV1().Jobs(spec.Namespace).Get(context.TODO(), spec.JobName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.EnvFromSource{{
+		Prefix:    "CLICKHOUSE_",
+		SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ch-credentials"}},
+	}}, job.Spec.Template.Spec.Containers[0].EnvFrom)
 }
 
 func TestCreateJobWithClient_Failure(t *testing.T) {
@@ -69,3 +83,144 @@ func TestCreateJobWithClient_Failure(t *testing.T) {
 	assert.Contains(t, strings.ToLower(err.Error()), "job 'fail-job' failed")
 
 }
+
+func TestCreateJobForOutputWithClient_Ephemeral(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+	spec := JobSpec{Namespace: "default", JobName: "list-job", Image: "alpine", Ephemeral: true}
+
+	var ttl *int32
+	go func() {
+		for {
+			job, err := client.BatchV1().Jobs(spec.Namespace).Get(context.TODO(), spec.JobName, metav1.GetOptions{})
+			if err == nil {
+				ttl = job.Spec.TTLSecondsAfterFinished
+				job.Status.Succeeded = 1
+				if _, err := client.BatchV1().Jobs(spec.Namespace).Update(context.TODO(), job, metav1.UpdateOptions{}); err != nil {
+					t.Errorf("failed to update job status: %v", err)
+				}
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	_, err := CreateJobForOutputWithClient(client, spec)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3600), *ttl, "a failed listing Job does not stay forever")
+	_, err = client.BatchV1().Jobs(spec.Namespace).Get(context.TODO(), spec.JobName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the Job is deleted once its output is read")
+}
+
+func TestGetJobLogs(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "list-job-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"job-name": "list-job"},
+		},
+	})
+
+	logs, err := GetJobLogs(client, "default", "list-job")
+	assert.NoError(t, err)
+	// The fake clientset always answers "fake logs" for pod logs.
+	assert.Equal(t, "fake logs", logs)
+
+	logs, err = GetJobLogs(client, "default", "other-job")
+	assert.NoError(t, err)
+	assert.Empty(t, logs)
+}
+
+func TestTailJobLogs(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"job-name": "restore-job"}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	client := k8sfake.NewSimpleClientset(pod("restore-job-pending", corev1.PodPending))
+	assert.Empty(t, tailJobLogs(client, "default", "restore-job"), "pods that have not started have no logs")
+
+	client = k8sfake.NewSimpleClientset(pod("restore-job-running", corev1.PodRunning))
+	assert.Equal(t, "fake logs", tailJobLogs(client, "default", "restore-job"))
+}
+
+func TestOverrides_Apply(t *testing.T) {
+	pod := corev1.PodSpec{Containers: []corev1.Container{{Name: "task"}}}
+
+	var none *Overrides
+	none.apply(&pod)
+	assert.Empty(t, pod.ServiceAccountName)
+
+	(&Overrides{
+		ServiceAccountName: "db-restore",
+		NodeSelector:       map[string]string{"pool": "restore"},
+		Tolerations:        []corev1.Toleration{{Key: "dedicated", Value: "restore", Effect: corev1.TaintEffectNoSchedule}},
+		Resources: &corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+		},
+	}).apply(&pod)
+
+	assert.Equal(t, "db-restore", pod.ServiceAccountName)
+	assert.Equal(t, map[string]string{"pool": "restore"}, pod.NodeSelector)
+	assert.Len(t, pod.Tolerations, 1)
+	assert.Equal(t, "2Gi", pod.Containers[0].Resources.Limits.Memory().String())
+}
+
+func TestJobSpec_Observe(t *testing.T) {
+	started := time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+	var timing Timing
+	spec := JobSpec{Namespace: "default", JobName: "restore-1", Observe: func(t Timing) { timing = t }}
+	client := k8sfake.NewSimpleClientset()
+
+	spec.observe(client, &batchv1.Job{Status: batchv1.JobStatus{
+		StartTime:      &metav1.Time{Time: started},
+		CompletionTime: &metav1.Time{Time: started.Add(90 * time.Second)},
+	}}, true)
+	assert.Equal(t, Timing{Started: started, Finished: started.Add(90 * time.Second), Succeeded: true}, timing)
+	assert.Equal(t, 90*time.Second, timing.Duration())
+
+	// Failed Jobs have no completion time, only a Failed condition.
+	spec.observe(client, &batchv1.Job{Status: batchv1.JobStatus{
+		StartTime: &metav1.Time{Time: started},
+		Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailureTarget, LastTransitionTime: metav1.Time{Time: started.Add(5 * time.Second)}},
+			{Type: batchv1.JobFailed, LastTransitionTime: metav1.Time{Time: started.Add(10 * time.Second)}},
+		},
+	}}, false)
+	assert.Equal(t, Timing{Started: started, Finished: started.Add(10 * time.Second)}, timing)
+
+	spec.observe(client, &batchv1.Job{}, false)
+	assert.Zero(t, timing.Duration())
+}
+
+func TestJobSpec_ObserveExitCode(t *testing.T) {
+	started := time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC)
+	pod := func(name string, created time.Time, exitCode int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "default", Labels: map[string]string{"job-name": "restore-1"},
+				CreationTimestamp: metav1.Time{Time: created},
+			},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "task",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+			}}},
+		}
+	}
+	client := k8sfake.NewSimpleClientset(
+		pod("restore-1-retry", started.Add(time.Minute), 2),
+		pod("restore-1-first", started, 1),
+	)
+
+	var timing Timing
+	spec := JobSpec{Namespace: "default", JobName: "restore-1", Observe: func(t Timing) { timing = t }}
+	spec.observe(client, &batchv1.Job{}, false)
+	if assert.NotNil(t, timing.ExitCode) {
+		assert.Equal(t, int32(2), *timing.ExitCode, "the last pod's exit code")
+	}
+
+	spec.JobName = "gone"
+	spec.observe(client, &batchv1.Job{}, false)
+	assert.Nil(t, timing.ExitCode)
+}
@@ -1,10 +1,19 @@
 package k8screds
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
 )
 
 type SecretKeyRef struct {
@@ -13,15 +22,50 @@ type SecretKeyRef struct {
 	Key        string // Key in secret: e.g. user
 }
 
+// SecretEnvFrom loads every key of a Secret as a variable, named Prefix+key, the
+// way a container's envFrom does.
+type SecretEnvFrom struct {
+	SecretName string
+	Prefix     string
+}
+
 type LoadedVar struct {
-	FromEnv       *string       // if set, from os env
-	FromSecretRef *SecretKeyRef // if set, to use in valueFrom
+	FromEnv       *string        // if set, from os env
+	FromSecretRef *SecretKeyRef  // if set, to use in valueFrom
+	FromSecretEnv *SecretEnvFrom // if set, provided by the Secret's envFrom
 }
 
-// LoadEnvVarsSmart loads credentials from explicit SecretRefs if available,
-// otherwise falls back to env vars. Returns an error if any required key is missing.
-func LoadSecretsVars(configFlags *genericclioptions.ConfigFlags, namespace string, refs []SecretKeyRef, requiredVars []string) (map[string]LoadedVar, error) {
-	result := map[string]LoadedVar{}
+// LoadSecretsVars loads credentials from explicit SecretRefs if available,
+// then from the Secrets loaded whole, otherwise falls back to env vars. Returns
+// an error if any required key is missing.
+//
+// The Secrets of envFrom are read, in namespace, to check which variables they
+// provide; every variable they provide is returned, required or not. The
+// Secrets of refs are read to check their keys exist and are not empty, so a
+// typo fails here rather than as a pod stuck in CreateContainerConfigError.
+func LoadSecretsVars(configFlags *genericclioptions.ConfigFlags, namespace string, refs []SecretKeyRef, envFrom []SecretEnvFrom, requiredVars []string) (map[string]LoadedVar, error) {
+	if len(refs) == 0 && len(envFrom) == 0 {
+		return LoadSecretsVarsWithClient(nil, namespace, nil, nil, requiredVars)
+	}
+
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return LoadSecretsVarsWithClient(clientset, namespace, refs, envFrom, requiredVars)
+}
+
+func LoadSecretsVarsWithClient(clientset kubernetes.Interface, namespace string, refs []SecretKeyRef, envFrom []SecretEnvFrom, requiredVars []string) (map[string]LoadedVar, error) {
+	secrets := &secretReader{clientset: clientset, namespace: namespace}
+	problems := secrets.checkRefs(refs)
+	result, empty, unreadable, err := loadEnvFromVars(secrets, envFrom)
+	if err != nil {
+		return nil, err
+	}
 
 	refMap := map[string]SecretKeyRef{}
 	for _, ref := range refs {
@@ -33,10 +77,21 @@ func LoadSecretsVars(configFlags *genericclioptions.ConfigFlags, namespace strin
 			result[key] = LoadedVar{
 				FromSecretRef: &ref,
 			}
+		} else if loaded, ok := result[key]; ok {
+			if from := loaded.FromSecretEnv; empty[key] {
+				problems = append(problems, fmt.Errorf("%s: key %q of secret %s/%s is empty", key, strings.TrimPrefix(key, from.Prefix), namespace, from.SecretName))
+			}
+			continue
 		} else {
 			envVal := os.Getenv(key)
-			if envVal == "" {
-				return nil, fmt.Errorf("missing required variable %q (not in secret nor env)", key)
+			if envVal == "" && unreadable != nil {
+				// Trust the Secret that could not be read to provide it.
+				result[key] = LoadedVar{FromSecretEnv: unreadable}
+				continue
+			}
+			// The invalid references, if any, are reported instead.
+			if envVal == "" && len(problems) == 0 {
+				return nil, fmt.Errorf("missing required variable %q (not in %s nor env)", key, secretSources(envFrom))
 			}
 			result[key] = LoadedVar{
 				FromEnv: &envVal,
@@ -44,5 +99,137 @@ func LoadSecretsVars(configFlags *genericclioptions.ConfigFlags, namespace strin
 		}
 	}
 
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid secret references:\n%w", errors.Join(problems...))
+	}
 	return result, nil
 }
+
+// loadEnvFromVars returns the variables the Secrets provide, and which of
+// them are empty. A later Secret wins over an earlier one, as in envFrom. The
+// last Secret the plugin is not allowed to read is returned as unreadable.
+func loadEnvFromVars(secrets *secretReader, envFrom []SecretEnvFrom) (result map[string]LoadedVar, empty map[string]bool, unreadable *SecretEnvFrom, err error) {
+	result = map[string]LoadedVar{}
+	empty = map[string]bool{}
+	for i, from := range envFrom {
+		secret, err := secrets.get(from.SecretName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if secret == nil {
+			unreadable = &envFrom[i]
+			continue
+		}
+		for key, value := range secret.Data {
+			result[from.Prefix+key] = LoadedVar{FromSecretEnv: &envFrom[i]}
+			empty[from.Prefix+key] = len(value) == 0
+		}
+	}
+	return result, empty, unreadable, nil
+}
+
+// secretReader reads the Secrets of a namespace once each. A Secret the
+// plugin is forbidden to read is skipped with a warning: the Jobs' service
+// account may still be allowed to.
+type secretReader struct {
+	clientset kubernetes.Interface
+	namespace string
+	read      map[string]*corev1.Secret
+}
+
+// get returns the Secret, nil when reading it is forbidden.
+func (r *secretReader) get(name string) (*corev1.Secret, error) {
+	if secret, ok := r.read[name]; ok {
+		return secret, nil
+	}
+	if r.read == nil {
+		r.read = map[string]*corev1.Secret{}
+	}
+
+	secret, err := r.clientset.CoreV1().Secrets(r.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsForbidden(err):
+		logger.Global.Warn("⚠️ Not allowed to read secret %s/%s, its keys are not checked", r.namespace, name)
+		secret = nil
+	case apierrors.IsNotFound(err):
+		return nil, fmt.Errorf("secret %s/%s not found", r.namespace, name)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", r.namespace, name, err)
+	}
+	r.read[name] = secret
+	return secret, nil
+}
+
+// checkRefs returns a problem for every ref whose key is missing or empty,
+// so they are all reported at once.
+func (r *secretReader) checkRefs(refs []SecretKeyRef) (errs []error) {
+	for _, ref := range refs {
+		secret, err := r.get(ref.SecretName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ref.EnvVarName, err))
+			continue
+		}
+		if secret == nil {
+			continue
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: secret %s/%s has no key %q", ref.EnvVarName, r.namespace, ref.SecretName, ref.Key))
+		} else if len(value) == 0 {
+			errs = append(errs, fmt.Errorf("%s: key %q of secret %s/%s is empty", ref.EnvVarName, ref.Key, r.namespace, ref.SecretName))
+		}
+	}
+	return errs
+}
+
+// secretSources names where a required variable was looked for, for errors.
+func secretSources(envFrom []SecretEnvFrom) string {
+	if len(envFrom) == 0 {
+		return "secret"
+	}
+	names := make([]string, 0, len(envFrom))
+	for _, from := range envFrom {
+		names = append(names, from.SecretName)
+	}
+	sort.Strings(names)
+	return "secret refs, secret(s) " + strings.Join(names, ", ")
+}
+
+// LoadOptionalVars resolves variables the same way as LoadSecretsVars,
+// but silently skips the ones that are neither in a SecretRef nor in the env.
+// Merge them with MergeOptionalVars, so an env var does not shadow a Secret
+// loaded whole.
+func LoadOptionalVars(refs []SecretKeyRef, optionalVars []string) map[string]LoadedVar {
+	result := map[string]LoadedVar{}
+
+	refMap := map[string]SecretKeyRef{}
+	for _, ref := range refs {
+		refMap[ref.EnvVarName] = ref
+	}
+
+	for _, key := range optionalVars {
+		if ref, ok := refMap[key]; ok {
+			result[key] = LoadedVar{
+				FromSecretRef: &ref,
+			}
+		} else if envVal := os.Getenv(key); envVal != "" {
+			result[key] = LoadedVar{
+				FromEnv: &envVal,
+			}
+		}
+	}
+
+	return result
+}
+
+// MergeOptionalVars adds the optional variables to the resolved ones. An
+// optional variable read from the env is dropped when a Secret already
+// provides it.
+func MergeOptionalVars(resolved, optional map[string]LoadedVar) {
+	for name, lv := range optional {
+		if _, ok := resolved[name]; ok && lv.FromEnv != nil {
+			continue
+		}
+		resolved[name] = lv
+	}
+}
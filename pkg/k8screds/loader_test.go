@@ -0,0 +1,115 @@
+package k8screds
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLoadSecretsVarsWithClient_EnvFrom(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ch-credentials", Namespace: "db"},
+		Data: map[string][]byte{
+			"USER":     []byte("restore"),
+			"PASSWORD": []byte("hunter2"),
+		},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "db"},
+		Data:       map[string][]byte{"user": []byte("admin")},
+	})
+	t.Setenv("CLICKHOUSE_PASSWORD", "from-env")
+	t.Setenv("AWS_ACCESS_KEY_ID", "from-env")
+
+	envFrom := []SecretEnvFrom{{SecretName: "ch-credentials", Prefix: "CLICKHOUSE_"}}
+	refs := []SecretKeyRef{{EnvVarName: "CLICKHOUSE_USER", SecretName: "other", Key: "user"}}
+
+	vars, err := LoadSecretsVarsWithClient(client, "db", refs, envFrom, []string{"CLICKHOUSE_USER", "CLICKHOUSE_PASSWORD", "AWS_ACCESS_KEY_ID"})
+	require.NoError(t, err)
+	assert.Equal(t, &refs[0], vars["CLICKHOUSE_USER"].FromSecretRef, "a SecretRef wins over the Secret loaded whole")
+	assert.Equal(t, &envFrom[0], vars["CLICKHOUSE_PASSWORD"].FromSecretEnv, "the Secret wins over the env")
+	require.NotNil(t, vars["AWS_ACCESS_KEY_ID"].FromEnv)
+	assert.Equal(t, "from-env", *vars["AWS_ACCESS_KEY_ID"].FromEnv)
+
+	_, err = LoadSecretsVarsWithClient(client, "db", nil, envFrom, []string{"CLICKHOUSE_HOST"})
+	assert.EqualError(t, err, `missing required variable "CLICKHOUSE_HOST" (not in secret refs, secret(s) ch-credentials nor env)`)
+
+	_, err = LoadSecretsVarsWithClient(client, "db", nil, []SecretEnvFrom{{SecretName: "missing"}}, nil)
+	assert.EqualError(t, err, "secret db/missing not found")
+}
+
+func TestLoadSecretsVarsWithClient_ChecksRefs(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ch", Namespace: "db"},
+		Data:       map[string][]byte{"user": []byte("restore"), "password": {}},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ch-env", Namespace: "db"},
+		Data:       map[string][]byte{"PASSWORD": {}},
+	})
+
+	refs := []SecretKeyRef{
+		{EnvVarName: "CLICKHOUSE_USER", SecretName: "ch", Key: "user"},
+		{EnvVarName: "CLICKHOUSE_PASSWORD", SecretName: "ch", Key: "password"},
+		{EnvVarName: "AWS_ACCESS_KEY_ID", SecretName: "ch", Key: "access-key"},
+		{EnvVarName: "AWS_SECRET_ACCESS_KEY", SecretName: "s3", Key: "secret-key"},
+	}
+	_, err := LoadSecretsVarsWithClient(client, "db", refs, nil, nil)
+	assert.EqualError(t, err, `invalid secret references:
+CLICKHOUSE_PASSWORD: key "password" of secret db/ch is empty
+AWS_ACCESS_KEY_ID: secret db/ch has no key "access-key"
+AWS_SECRET_ACCESS_KEY: secret db/s3 not found`)
+
+	envFrom := []SecretEnvFrom{{SecretName: "ch-env", Prefix: "CLICKHOUSE_"}}
+	_, err = LoadSecretsVarsWithClient(client, "db", refs[:1], envFrom, []string{"CLICKHOUSE_PASSWORD", "CLICKHOUSE_HOST"})
+	assert.EqualError(t, err, `invalid secret references:
+CLICKHOUSE_PASSWORD: key "PASSWORD" of secret db/ch-env is empty`, "a required variable the Secret loaded whole leaves empty is reported first")
+
+	vars, err := LoadSecretsVarsWithClient(client, "db", refs[:1], nil, []string{"CLICKHOUSE_USER"})
+	require.NoError(t, err)
+	assert.Equal(t, "ch", vars["CLICKHOUSE_USER"].FromSecretRef.SecretName)
+}
+
+func TestLoadSecretsVarsWithClient_Forbidden(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+	client.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "ch", errors.New("RBAC"))
+	})
+	var logs bytes.Buffer
+	logger.Global.SetOutput(&logs)
+	defer logger.Global.SetOutput(os.Stdout)
+
+	envFrom := []SecretEnvFrom{{SecretName: "ch-env", Prefix: "CLICKHOUSE_"}}
+	refs := []SecretKeyRef{{EnvVarName: "AWS_ACCESS_KEY_ID", SecretName: "s3", Key: "access-key"}}
+	vars, err := LoadSecretsVarsWithClient(client, "db", refs, envFrom, []string{"CLICKHOUSE_USER", "AWS_ACCESS_KEY_ID"})
+	require.NoError(t, err, "the Jobs may be allowed to read what the plugin cannot")
+	assert.Equal(t, &envFrom[0], vars["CLICKHOUSE_USER"].FromSecretEnv)
+	assert.NotNil(t, vars["AWS_ACCESS_KEY_ID"].FromSecretRef)
+	assert.Contains(t, logs.String(), "Not allowed to read secret db/s3")
+	assert.Contains(t, logs.String(), "Not allowed to read secret db/ch")
+}
+
+func TestMergeOptionalVars(t *testing.T) {
+	fromSecret := SecretEnvFrom{SecretName: "pg"}
+	value := "from-env"
+	resolved := map[string]LoadedVar{"PGSSLMODE": {FromSecretEnv: &fromSecret}}
+
+	MergeOptionalVars(resolved, map[string]LoadedVar{
+		"PGSSLMODE":  {FromEnv: &value},
+		"PGSSLCERT":  {FromEnv: &value},
+		"PGPASSFILE": {FromSecretRef: &SecretKeyRef{EnvVarName: "PGPASSFILE", SecretName: "pg", Key: "passfile"}},
+	})
+	assert.Same(t, &fromSecret, resolved["PGSSLMODE"].FromSecretEnv)
+	assert.Equal(t, &value, resolved["PGSSLCERT"].FromEnv)
+	assert.NotNil(t, resolved["PGPASSFILE"].FromSecretRef)
+}
@@ -1,37 +1,190 @@
+// Package logger prints the progress of the commands, built on log/slog: as
+// colored lines for humans or as JSON lines for CI, at the debug, info, warn and
+// error levels.
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"sync"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
+// Output formats of the logger.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// instructionsKey marks the records of Instructions, which the text format sets
+// apart from the progress lines.
+const instructionsKey = "instructions"
+
+// Logger is safe for concurrent use: restores running in parallel share it, and
+// each message is written whole.
 type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	level  slog.LevelVar
+	slog   *slog.Logger
 }
 
 func NewLogger() *Logger {
-	return &Logger{}
+	l := &Logger{out: color.Output, format: FormatText}
+	l.rebuild()
+	return l
 }
 
-func (l *Logger) Info(msg string, args ...interface{}) {
-	if msg == "" {
-		fmt.Println("")
+// rebuild creates the slog logger for the current output and format. The caller
+// holds the lock, or owns the logger.
+func (l *Logger) rebuild() {
+	var handler slog.Handler
+	if l.format == FormatJSON {
+		handler = slog.NewJSONHandler(l.out, &slog.HandlerOptions{Level: &l.level})
+	} else {
+		handler = &textHandler{out: l.out, level: &l.level, color: colorize(l.out)}
+	}
+	l.slog = slog.New(handler)
+}
+
+// SetOutput redirects the logger, e.g. to stderr when stdout carries command output.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+	l.rebuild()
+}
+
+// SetFormat switches between FormatText and FormatJSON.
+func (l *Logger) SetFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q: must be %s or %s", format, FormatText, FormatJSON)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+	l.rebuild()
+	return nil
+}
+
+// SetLevel sets the lowest level printed, slog.LevelInfo by default.
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+func (l *Logger) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if msg == "" && l.format == FormatJSON {
 		return
 	}
+	l.slog.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(msg, args...))
+}
 
-	c := color.New(color.FgHiCyan)
-	_, _ = c.Println(fmt.Sprintf(msg, args...))
+func (l *Logger) Info(msg string, args ...interface{}) {
+	// An empty message is a blank line between sections, skipped in JSON.
+	l.log(slog.LevelInfo, fmt.Sprintf(msg, args...))
+}
+
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(msg, args...))
 }
 
 func (l *Logger) Error(err error) {
-	c := color.New(color.FgHiRed)
-	_, _ = c.Println(fmt.Sprintf("%#v", err))
+	if err == nil {
+		return
+	}
+	l.log(slog.LevelError, err.Error())
 }
 
+// Instructions prints a block of text meant to be read, such as a summary or
+// the steps to follow after a failure.
 func (l *Logger) Instructions(msg string, args ...interface{}) {
-	white := color.New(color.FgHiWhite)
-	_, _ = white.Println("")
-	_, _ = white.Println(fmt.Sprintf(msg, args...))
+	l.log(slog.LevelInfo, fmt.Sprintf(msg, args...), slog.Bool(instructionsKey, true))
 }
 
 var Global = NewLogger()
+
+// colorize reports whether w is a terminal that should get colors: never when
+// NO_COLOR is set or the output is redirected to a file or a pipe.
+func colorize(w io.Writer) bool {
+	if color.NoColor {
+		return false
+	}
+	if f, ok := w.(interface{ Fd() uintptr }); ok {
+		return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+	}
+	// color.Output on Windows, which only wraps a terminal.
+	return w == color.Output
+}
+
+// textHandler prints the message of each record on its own line, colored by
+// level. It does not print the time or the level, the emojis of the messages
+// already tell what they are.
+type textHandler struct {
+	out   io.Writer
+	level slog.Leveler
+	color bool
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	if r.Message == "" {
+		_, err := fmt.Fprintln(h.out, "")
+		return err
+	}
+
+	instructions := false
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == instructionsKey {
+			instructions = a.Value.Bool()
+			return false
+		}
+		return true
+	})
+
+	var c *color.Color
+	switch {
+	case instructions:
+		c = color.New(color.FgHiWhite)
+	case r.Level >= slog.LevelError:
+		c = color.New(color.FgHiRed)
+	case r.Level >= slog.LevelWarn:
+		c = color.New(color.FgHiYellow)
+	case r.Level >= slog.LevelInfo:
+		c = color.New(color.FgHiCyan)
+	default:
+		c = color.New(color.FgHiBlack)
+	}
+	if h.color {
+		c.EnableColor()
+	} else {
+		c.DisableColor()
+	}
+
+	if instructions {
+		if _, err := fmt.Fprintln(h.out, ""); err != nil {
+			return err
+		}
+	}
+	_, err := c.Fprintln(h.out, r.Message)
+	return err
+}
+
+// WithAttrs and WithGroup are not used by the logger, the attributes of the
+// records are not printed in text.
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *textHandler) WithGroup(string) slog.Handler { return h }
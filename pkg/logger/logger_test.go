@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_Text(t *testing.T) {
+	var out bytes.Buffer
+	l := NewLogger()
+	l.SetOutput(&out)
+
+	l.Debug("🔍 hidden")
+	l.Info("✅ Created Job %s", "restore-1")
+	l.Warn("⚠️ careful")
+	l.Error(errors.New("job 'restore-1' failed"))
+	l.Instructions("📋 summary")
+
+	// A buffer is not a terminal: no color codes.
+	assert.Equal(t, "✅ Created Job restore-1\n⚠️ careful\njob 'restore-1' failed\n\n📋 summary\n", out.String())
+
+	out.Reset()
+	l.SetLevel(slog.LevelDebug)
+	l.Debug("🔍 shown")
+	assert.Equal(t, "🔍 shown\n", out.String())
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var out bytes.Buffer
+	l := NewLogger()
+	l.SetOutput(&out)
+	require.NoError(t, l.SetFormat(FormatJSON))
+
+	l.Debug("🔍 hidden")
+	l.Info("")
+	l.Info("✅ Created Job %s", "restore-1")
+	l.Warn("⚠️ careful")
+	l.Error(errors.New("job 'restore-1' failed"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+
+	var entries []map[string]any
+	for _, line := range lines {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Contains(t, entry, "time")
+		delete(entry, "time")
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, []map[string]any{
+		{"level": "INFO", "msg": "✅ Created Job restore-1"},
+		{"level": "WARN", "msg": "⚠️ careful"},
+		{"level": "ERROR", "msg": "job 'restore-1' failed"},
+	}, entries)
+}
+
+func TestLogger_SetFormat(t *testing.T) {
+	assert.EqualError(t, NewLogger().SetFormat("yaml"), `invalid log format "yaml": must be text or json`)
+}
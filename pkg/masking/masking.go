@@ -0,0 +1,192 @@
+// Package masking turns a rules file describing how to anonymise columns into
+// the SQL run after a restore.
+package masking
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+type Strategy string
+
+const (
+	Hash      Strategy = "hash"       // hex SHA-256 of the value
+	Null      Strategy = "null"       // NULL, the column must be nullable
+	FakeEmail Strategy = "fake_email" // user-<hash>@example.com, stable per value
+	Truncate  Strategy = "truncate"   // first Length characters, empty by default
+)
+
+// Rule masks one column.
+type Rule struct {
+	Table    string   `json:"table"`
+	Column   string   `json:"column"`
+	Strategy Strategy `json:"strategy"`
+	Length   int      `json:"length,omitempty"`
+}
+
+func (r Rule) String() string {
+	if r.Strategy == Truncate {
+		return fmt.Sprintf("%s.%s: %s to %d characters", r.Table, r.Column, r.Strategy, r.Length)
+	}
+	return fmt.Sprintf("%s.%s: %s", r.Table, r.Column, r.Strategy)
+}
+
+// file is the layout of a rules file.
+type file struct {
+	Rules []Rule `json:"rules"`
+}
+
+// identifier is deliberately strict: names end up in SQL run by a shell script.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Load reads and validates a YAML (or JSON) rules file.
+func Load(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read masking rules: %w", err)
+	}
+
+	rules, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid masking rules file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func Parse(data []byte) ([]Rule, error) {
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Rules) == 0 {
+		return nil, fmt.Errorf("no rules")
+	}
+
+	seen := map[string]bool{}
+	for i, r := range f.Rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		key := r.Table + "." + r.Column
+		if seen[key] {
+			return nil, fmt.Errorf("rule %d: column %s is masked twice", i+1, key)
+		}
+		seen[key] = true
+	}
+	return f.Rules, nil
+}
+
+func (r Rule) validate() error {
+	// Postgres tables may be schema-qualified.
+	for _, part := range strings.Split(r.Table, ".") {
+		if !identifier.MatchString(part) {
+			return fmt.Errorf("invalid table %q", r.Table)
+		}
+	}
+	if strings.Count(r.Table, ".") > 1 {
+		return fmt.Errorf("invalid table %q", r.Table)
+	}
+	if !identifier.MatchString(r.Column) {
+		return fmt.Errorf("invalid column %q", r.Column)
+	}
+
+	switch r.Strategy {
+	case Hash, Null, FakeEmail:
+		if r.Length != 0 {
+			return fmt.Errorf("length only applies to the truncate strategy")
+		}
+	case Truncate:
+		if r.Length < 0 {
+			return fmt.Errorf("negative length %d", r.Length)
+		}
+	default:
+		return fmt.Errorf("unknown strategy %q (expected hash, null, fake_email or truncate)", r.Strategy)
+	}
+	return nil
+}
+
+// byTable groups the rules per table, tables in order of first appearance, so
+// each table is rewritten once.
+func byTable(rules []Rule) ([]string, map[string][]Rule) {
+	tables := []string{}
+	grouped := map[string][]Rule{}
+	for _, r := range rules {
+		if _, ok := grouped[r.Table]; !ok {
+			tables = append(tables, r.Table)
+		}
+		grouped[r.Table] = append(grouped[r.Table], r)
+	}
+	return tables, grouped
+}
+
+// ClickhouseStatements returns one ALTER TABLE ... UPDATE mutation per table of the
+// database. Replicated tables are mutated once per shard through ON CLUSTER.
+func ClickhouseStatements(database, cluster string, rules []Rule) []string {
+	tables, grouped := byTable(rules)
+
+	statements := []string{}
+	for _, table := range tables {
+		assignments := []string{}
+		for _, r := range grouped[table] {
+			assignments = append(assignments, fmt.Sprintf("`%s` = %s", r.Column, clickhouseExpression(r)))
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE `%s`.`%s` ON CLUSTER `%s` UPDATE %s WHERE 1;",
+			database, table, cluster, strings.Join(assignments, ", ")))
+	}
+	return statements
+}
+
+func clickhouseExpression(r Rule) string {
+	column := fmt.Sprintf("`%s`", r.Column)
+	switch r.Strategy {
+	case Hash:
+		return fmt.Sprintf("lower(hex(SHA256(toString(%s))))", column)
+	case Null:
+		return "NULL"
+	case FakeEmail:
+		return fmt.Sprintf("concat('user-', lower(hex(cityHash64(%s))), '@example.com')", column)
+	default:
+		return fmt.Sprintf("substringUTF8(%s, 1, %d)", column, r.Length)
+	}
+}
+
+// PostgresStatements returns one UPDATE per table, meant to run in a single transaction.
+func PostgresStatements(rules []Rule) []string {
+	tables, grouped := byTable(rules)
+
+	statements := []string{}
+	for _, table := range tables {
+		assignments := []string{}
+		for _, r := range grouped[table] {
+			assignments = append(assignments, fmt.Sprintf(`"%s" = %s`, r.Column, postgresExpression(r)))
+		}
+		statements = append(statements, fmt.Sprintf("UPDATE %s SET %s;", postgresTable(table), strings.Join(assignments, ", ")))
+	}
+	return statements
+}
+
+func postgresTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = `"` + part + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func postgresExpression(r Rule) string {
+	column := fmt.Sprintf(`"%s"`, r.Column)
+	switch r.Strategy {
+	case Hash:
+		return fmt.Sprintf("encode(sha256(convert_to(%s::text, 'UTF8')), 'hex')", column)
+	case Null:
+		return "NULL"
+	case FakeEmail:
+		return fmt.Sprintf("'user-' || left(md5(%s::text), 16) || '@example.com'", column)
+	default:
+		return fmt.Sprintf("left(%s, %d)", column, r.Length)
+	}
+}
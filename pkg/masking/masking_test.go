@@ -0,0 +1,76 @@
+package masking
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+rules:
+  - table: users
+    column: email
+    strategy: fake_email
+  - table: users
+    column: password_hash
+    strategy: hash
+  - table: orders
+    column: comment
+    strategy: truncate
+    length: 10
+  - table: users
+    column: phone
+    strategy: "null"
+`
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "masking.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testRules), 0o600))
+
+	rules, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+	assert.Equal(t, Rule{Table: "orders", Column: "comment", Strategy: Truncate, Length: 10}, rules[2])
+	assert.Equal(t, "orders.comment: truncate to 10 characters", rules[2].String())
+	assert.Equal(t, "users.phone: null", rules[3].String())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":            `rules: []`,
+		"unknown field":    "rules:\n  - {table: users, column: email, strategy: hash, salt: x}",
+		"unknown strategy": "rules:\n  - {table: users, column: email, strategy: shuffle}",
+		"injection":        "rules:\n  - {table: \"users; DROP TABLE users\", column: email, strategy: hash}",
+		"bad column":       "rules:\n  - {table: users, column: \"e`mail\", strategy: hash}",
+		"length on hash":   "rules:\n  - {table: users, column: email, strategy: hash, length: 3}",
+		"duplicate":        "rules:\n  - {table: users, column: email, strategy: hash}\n  - {table: users, column: email, strategy: \"null\"}",
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestClickhouseStatements(t *testing.T) {
+	rules, err := Parse([]byte(testRules))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"ALTER TABLE `shop`.`users` ON CLUSTER `default` UPDATE " +
+			"`email` = concat('user-', lower(hex(cityHash64(`email`))), '@example.com'), " +
+			"`password_hash` = lower(hex(SHA256(toString(`password_hash`)))), " +
+			"`phone` = NULL WHERE 1;",
+		"ALTER TABLE `shop`.`orders` ON CLUSTER `default` UPDATE `comment` = substringUTF8(`comment`, 1, 10) WHERE 1;",
+	}, ClickhouseStatements("shop", "default", rules))
+}
+
+func TestPostgresStatements(t *testing.T) {
+	rules, err := Parse([]byte("rules:\n  - {table: billing.invoices, column: email, strategy: fake_email}\n  - {table: billing.invoices, column: notes, strategy: truncate}"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`UPDATE "billing"."invoices" SET "email" = 'user-' || left(md5("email"::text), 16) || '@example.com', "notes" = left("notes", 0);`,
+	}, PostgresStatements(rules))
+}
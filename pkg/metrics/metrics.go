@@ -0,0 +1,196 @@
+// Package metrics exports the outcome of restores in the Prometheus text format,
+// to a Pushgateway or to the directory of node_exporter's textfile collector, so
+// restore drills can be followed on dashboards.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config selects where the metrics of a restore go. Both can be set.
+type Config struct {
+	// Pushgateway is the base URL of a Prometheus Pushgateway.
+	Pushgateway string `json:"pushgateway,omitempty"`
+	// TextfileDir is the directory read by node_exporter's textfile collector.
+	TextfileDir string `json:"textfileDir,omitempty"`
+}
+
+func (c Config) Empty() bool {
+	return c.Pushgateway == "" && c.TextfileDir == ""
+}
+
+// pushJob is the Pushgateway job the metrics are grouped under.
+const pushJob = "kubectl_db_restore"
+
+// Phase is the time one step of a restore took.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Run is what is exported of one restore.
+type Run struct {
+	Engine    string
+	Namespace string
+	Database  string
+	Succeeded bool
+	Finished  time.Time
+	Duration  time.Duration
+	Phases    []Phase
+	// RestoredBytes is the size of the backup, exported when known (> 0).
+	RestoredBytes int64
+}
+
+// Format renders the run in the Prometheus text exposition format.
+func Format(run Run) []byte {
+	var b bytes.Buffer
+	labels := fmt.Sprintf(`engine="%s",namespace="%s",database="%s"`, escape(run.Engine), escape(run.Namespace), escape(run.Database))
+
+	b.WriteString("# HELP restore_duration_seconds Time taken by the restore, per phase and in total (phase=\"total\").\n")
+	b.WriteString("# TYPE restore_duration_seconds gauge\n")
+	for _, p := range run.Phases {
+		fmt.Fprintf(&b, "restore_duration_seconds{%s,phase=\"%s\"} %g\n", labels, escape(p.Name), p.Duration.Seconds())
+	}
+	fmt.Fprintf(&b, "restore_duration_seconds{%s,phase=\"total\"} %g\n", labels, run.Duration.Seconds())
+
+	success := 0
+	if run.Succeeded {
+		success = 1
+	}
+	b.WriteString("# HELP restore_success Whether the last restore succeeded (1) or failed (0).\n")
+	b.WriteString("# TYPE restore_success gauge\n")
+	fmt.Fprintf(&b, "restore_success{%s} %d\n", labels, success)
+
+	b.WriteString("# HELP restore_last_run_timestamp_seconds When the last restore finished, as a Unix timestamp.\n")
+	b.WriteString("# TYPE restore_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "restore_last_run_timestamp_seconds{%s} %d\n", labels, run.Finished.Unix())
+
+	if run.RestoredBytes > 0 {
+		b.WriteString("# HELP restored_bytes Size of the backup the last restore read.\n")
+		b.WriteString("# TYPE restored_bytes gauge\n")
+		fmt.Fprintf(&b, "restored_bytes{%s} %d\n", labels, run.RestoredBytes)
+	}
+	return b.Bytes()
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Export sends the run's metrics everywhere the config says, and returns the
+// errors of the destinations that failed.
+func Export(config Config, run Run) error {
+	data := Format(run)
+
+	var errs []error
+	if config.Pushgateway != "" {
+		errs = append(errs, push(config.Pushgateway, run, data))
+	}
+	if config.TextfileDir != "" {
+		errs = append(errs, writeTextfile(config.TextfileDir, run, data))
+	}
+	return errors.Join(errs...)
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// push replaces the run's group on the Pushgateway, so the phases of an
+// earlier restore of the same database do not linger.
+func push(gateway string, run Run, data []byte) error {
+	target := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + pushJob + groupingPath(run)
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPut, target, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid Pushgateway URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to push metrics: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// groupingPath is the grouping key of the run, label values encoded as the
+// Pushgateway expects when they are empty or hold a '/'.
+func groupingPath(run Run) string {
+	labels := map[string]string{"engine": run.Engine, "namespace": run.Namespace, "database": run.Database}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var path strings.Builder
+	for _, name := range names {
+		value := labels[name]
+		if value == "" || strings.Contains(value, "/") {
+			fmt.Fprintf(&path, "/%s@base64/%s", name, base64.RawURLEncoding.EncodeToString([]byte(value)))
+			if value == "" {
+				path.WriteString("=")
+			}
+			continue
+		}
+		fmt.Fprintf(&path, "/%s/%s", name, url.PathEscape(value))
+	}
+	return path.String()
+}
+
+// writeTextfile writes the run's file of the collector directory, through a
+// rename so the collector never reads a partial file.
+func writeTextfile(dir string, run Run, data []byte) error {
+	name := fmt.Sprintf("db-restore_%s_%s_%s.prom", fileSafe(run.Engine), fileSafe(run.Namespace), fileSafe(run.Database))
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+func fileSafe(s string) string {
+	if s == "" {
+		return "all"
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, s)
+}
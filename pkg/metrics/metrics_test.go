@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRun = Run{
+	Engine:    "clickhouse",
+	Namespace: "analytics",
+	Database:  "shop",
+	Succeeded: true,
+	Finished:  time.Date(2025, 6, 16, 8, 32, 0, 0, time.UTC),
+	Duration:  125 * time.Second,
+	Phases: []Phase{
+		{Name: "clickhouse-drop-db", Duration: 5 * time.Second},
+		{Name: "clickhouse-restore", Duration: 110500 * time.Millisecond},
+	},
+	RestoredBytes: 3145728,
+}
+
+const testExposition = `# HELP restore_duration_seconds Time taken by the restore, per phase and in total (phase="total").
+# TYPE restore_duration_seconds gauge
+restore_duration_seconds{engine="clickhouse",namespace="analytics",database="shop",phase="clickhouse-drop-db"} 5
+restore_duration_seconds{engine="clickhouse",namespace="analytics",database="shop",phase="clickhouse-restore"} 110.5
+restore_duration_seconds{engine="clickhouse",namespace="analytics",database="shop",phase="total"} 125
+# HELP restore_success Whether the last restore succeeded (1) or failed (0).
+# TYPE restore_success gauge
+restore_success{engine="clickhouse",namespace="analytics",database="shop"} 1
+# HELP restore_last_run_timestamp_seconds When the last restore finished, as a Unix timestamp.
+# TYPE restore_last_run_timestamp_seconds gauge
+restore_last_run_timestamp_seconds{engine="clickhouse",namespace="analytics",database="shop"} 1750062720
+# HELP restored_bytes Size of the backup the last restore read.
+# TYPE restored_bytes gauge
+restored_bytes{engine="clickhouse",namespace="analytics",database="shop"} 3145728
+`
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, testExposition, string(Format(testRun)))
+
+	failed := testRun
+	failed.Succeeded = false
+	failed.RestoredBytes = 0
+	failed.Database = `we"ird`
+	out := string(Format(failed))
+	assert.Contains(t, out, `restore_success{engine="clickhouse",namespace="analytics",database="we\"ird"} 0`)
+	assert.NotContains(t, out, "restored_bytes")
+}
+
+func TestExport_Pushgateway(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	require.NoError(t, Export(Config{Pushgateway: server.URL + "/"}, testRun))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/kubectl_db_restore/database/shop/engine/clickhouse/namespace/analytics", path)
+	assert.Equal(t, testExposition, body)
+
+	physical := testRun
+	physical.Database = ""
+	require.NoError(t, Export(Config{Pushgateway: server.URL}, physical))
+	assert.Equal(t, "/metrics/job/kubectl_db_restore/database@base64/=/engine/clickhouse/namespace/analytics", path)
+}
+
+func TestExport_PushgatewayError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Export(Config{Pushgateway: server.URL}, testRun)
+	assert.EqualError(t, err, "failed to push metrics: 400 Bad Request pushed metrics are invalid")
+}
+
+func TestExport_Textfile(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, Export(Config{TextfileDir: dir}, testRun))
+	data, err := os.ReadFile(filepath.Join(dir, "db-restore_clickhouse_analytics_shop.prom"))
+	require.NoError(t, err)
+	assert.Equal(t, testExposition, string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+
+	assert.Error(t, Export(Config{TextfileDir: filepath.Join(dir, "missing")}, testRun))
+}
@@ -0,0 +1,150 @@
+// Package notify posts the start and the outcome of runs to webhooks, as a
+// JSON document or as a Slack-compatible message.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Events of a run a notification is sent for.
+const (
+	EventStarted   = "started"
+	EventFailed    = "failed"
+	EventSucceeded = "succeeded"
+)
+
+// Config lists the webhooks notified of every run.
+type Config struct {
+	// Webhooks receive the Notification as JSON.
+	Webhooks []string `json:"webhooks,omitempty"`
+	// Slack receive a message for Slack incoming webhooks (and compatible
+	// services such as Mattermost).
+	Slack []string `json:"slack,omitempty"`
+}
+
+func (c Config) Empty() bool {
+	return len(c.Webhooks) == 0 && len(c.Slack) == 0
+}
+
+// Notification is the JSON payload posted to webhooks. It only carries what
+// identifies the run, never the values of its variables.
+type Notification struct {
+	Event     string    `json:"event"`
+	RunID     string    `json:"runId"`
+	Operation string    `json:"operation"`
+	Engine    string    `json:"engine"`
+	Database  string    `json:"database,omitempty"`
+	Backup    string    `json:"backup,omitempty"`
+	Namespace string    `json:"namespace"`
+	Target    string    `json:"target,omitempty"`
+	Context   string    `json:"context,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Phase     string    `json:"phase,omitempty"` // the step that failed
+	Error     string    `json:"error,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitzero"`
+	// DurationSeconds is set once the run is over.
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+}
+
+// slackMessage is the payload of Slack incoming webhooks.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// httpClient is shared by the notifications, a slow webhook must not hold the restore.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Send posts the notification to every webhook of the config, and returns the
+// errors of those that failed.
+func Send(config Config, n Notification) error {
+	var errs []error
+	for _, url := range config.Webhooks {
+		errs = append(errs, post(url, n))
+	}
+	if len(config.Slack) > 0 {
+		message := slackMessage{Text: SlackText(n)}
+		for _, url := range config.Slack {
+			errs = append(errs, post(url, message))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func post(url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook %s: %w", redactURL(url), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kubectl-db-restore")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// The error holds the URL, whose path is the secret of Slack webhooks.
+		return fmt.Errorf("failed to notify %s: %w", redactURL(url), errors.Unwrap(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to notify %s: %s", redactURL(url), resp.Status)
+	}
+	return nil
+}
+
+// redactURL keeps the scheme and host of a webhook URL, its path and query
+// often being its credentials.
+func redactURL(url string) string {
+	scheme, rest, found := strings.Cut(url, "://")
+	if !found {
+		return "(invalid URL)"
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	if _, h, ok := strings.Cut(host, "@"); ok {
+		host = h
+	}
+	return scheme + "://" + host + "/..."
+}
+
+// SlackText renders the notification as a one-line message.
+func SlackText(n Notification) string {
+	subject := fmt.Sprintf("%s %s", n.Engine, n.Operation)
+	if n.Database != "" {
+		subject += fmt.Sprintf(" of `%s`", n.Database)
+	}
+	where := fmt.Sprintf("`%s`", n.Namespace)
+	if n.Target != "" {
+		where = fmt.Sprintf("`%s/%s`", n.Namespace, n.Target)
+	}
+	duration := time.Duration(n.DurationSeconds * float64(time.Second)).Round(time.Second)
+
+	switch n.Event {
+	case EventStarted:
+		return fmt.Sprintf(":hourglass_flowing_sand: %s from backup `%s` started in %s by %s (run `%s`)", subject, n.Backup, where, n.User, n.RunID)
+	case EventFailed:
+		text := fmt.Sprintf(":x: %s from backup `%s` failed in %s after %s (run `%s`)", subject, n.Backup, where, duration, n.RunID)
+		if n.Phase != "" {
+			text += fmt.Sprintf(" at step `%s`", n.Phase)
+		}
+		if n.Error != "" {
+			text += ": " + n.Error
+		}
+		return text
+	default:
+		return fmt.Sprintf(":white_check_mark: %s from backup `%s` succeeded in %s in %s (run `%s`)", subject, n.Backup, where, duration, n.RunID)
+	}
+}
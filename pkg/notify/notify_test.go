@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNotification = Notification{
+	Event:           EventFailed,
+	RunID:           "20250616-083000-a1b2c3",
+	Operation:       "restore",
+	Engine:          "clickhouse",
+	Database:        "shop",
+	Backup:          "daily-2025-06-16",
+	Namespace:       "analytics",
+	Target:          "clickhouse-service",
+	User:            "alice",
+	Phase:           "clickhouse-restore",
+	Error:           "job 'clickhouse-restore-1' failed",
+	Started:         time.Date(2025, 6, 16, 8, 30, 0, 0, time.UTC),
+	Finished:        time.Date(2025, 6, 16, 8, 32, 5, 0, time.UTC),
+	DurationSeconds: 125,
+}
+
+func TestSend(t *testing.T) {
+	var webhook Notification
+	var slack map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		switch r.URL.Path {
+		case "/webhook":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&webhook))
+		case "/slack/T000/B000/XXXX":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&slack))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	err := Send(Config{Webhooks: []string{server.URL + "/webhook"}, Slack: []string{server.URL + "/slack/T000/B000/XXXX"}}, testNotification)
+	require.NoError(t, err)
+	assert.Equal(t, testNotification, webhook)
+	assert.Equal(t, map[string]string{
+		"text": ":x: clickhouse restore of `shop` from backup `daily-2025-06-16` failed in `analytics/clickhouse-service` after 2m5s (run `20250616-083000-a1b2c3`) at step `clickhouse-restore`: job 'clickhouse-restore-1' failed",
+	}, slack)
+}
+
+func TestSend_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := Send(Config{Slack: []string{server.URL + "/services/T000/B000/secret-token"}}, testNotification)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403 Forbidden")
+	assert.NotContains(t, err.Error(), "secret-token", "webhook paths are credentials")
+
+	err = Send(Config{Webhooks: []string{"http://127.0.0.1:1/hooks/secret-token"}}, testNotification)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-token")
+}
+
+func TestSlackText(t *testing.T) {
+	n := testNotification
+	n.Event = EventStarted
+	assert.Equal(t, ":hourglass_flowing_sand: clickhouse restore of `shop` from backup `daily-2025-06-16` started in `analytics/clickhouse-service` by alice (run `20250616-083000-a1b2c3`)", SlackText(n))
+
+	n.Event = EventSucceeded
+	assert.Equal(t, ":white_check_mark: clickhouse restore of `shop` from backup `daily-2025-06-16` succeeded in `analytics/clickhouse-service` in 2m5s (run `20250616-083000-a1b2c3`)", SlackText(n))
+}
@@ -0,0 +1,275 @@
+// Package plan reads RestorePlan files, which describe a restore declaratively
+// so restore runbooks can be kept under version control and reviewed.
+package plan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/engine"
+	"github.com/wiremind/kubectl-db-restore/pkg/job"
+	"github.com/wiremind/kubectl-db-restore/pkg/metrics"
+	"github.com/wiremind/kubectl-db-restore/pkg/notify"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "db-restore.wiremind.io/v1alpha1"
+	Kind       = "RestorePlan"
+)
+
+// RestorePlan is the layout of a plan file, versioned like a Kubernetes object.
+type RestorePlan struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+	Spec       Spec     `json:"spec"`
+}
+
+type Metadata struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Spec struct {
+	Engine string `json:"engine"`
+	Backup Backup `json:"backup"`
+	Source Source `json:"source,omitempty"`
+	Target Target `json:"target"`
+
+	// Parallelism is the number of databases restored at the same time, 1 by default.
+	Parallelism int         `json:"parallelism,omitempty"`
+	SecretRefs  []SecretRef `json:"secretRefs,omitempty"`
+	// SecretEnvFrom are Secrets whose every key is loaded into the Jobs.
+	SecretEnvFrom []SecretEnvFrom `json:"secretEnvFrom,omitempty"`
+
+	// Physical or Operator select the matching Postgres restore mode, a logical
+	// restore is run when neither is set.
+	Physical *Physical `json:"physical,omitempty"`
+	Operator *Operator `json:"operator,omitempty"`
+
+	JobOverrides  *job.Overrides `json:"jobOverrides,omitempty"`
+	Hooks         engine.Hooks   `json:"hooks,omitempty"`
+	Notifications notify.Config  `json:"notifications,omitempty"`
+	Metrics       metrics.Config `json:"metrics,omitempty"`
+
+	// MaskingRules is the path of a masking rules file.
+	MaskingRules   string       `json:"maskingRules,omitempty"`
+	PreserveGrants bool         `json:"preserveGrants,omitempty"`
+	Verification   Verification `json:"verification,omitempty"`
+}
+
+// Backup names the backup to restore, or how to select it.
+type Backup struct {
+	Name     string `json:"name,omitempty"`
+	Selector string `json:"selector,omitempty"` // "latest" or a glob
+	Before   string `json:"before,omitempty"`   // RFC3339 or YYYY-MM-DD, UTC
+}
+
+type Source struct {
+	Context string `json:"context,omitempty"`
+}
+
+type Target struct {
+	Context     string   `json:"context,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
+	ServiceName string   `json:"serviceName,omitempty"`
+	CHI         string   `json:"chi,omitempty"`
+	CHICluster  string   `json:"chiCluster,omitempty"`
+	Databases   []string `json:"databases,omitempty"`
+}
+
+// SecretRef provides the variable Env from key Key of Secret Secret.
+type SecretRef struct {
+	Env    string `json:"env"`
+	Secret string `json:"secret"`
+	Key    string `json:"key"`
+}
+
+// SecretEnvFrom loads every key of Secret Secret as Prefix+key, and each key of
+// Rename as the variable it is mapped from.
+type SecretEnvFrom struct {
+	Secret string            `json:"secret"`
+	Prefix string            `json:"prefix,omitempty"`
+	Rename map[string]string `json:"rename,omitempty"`
+}
+
+type Physical struct {
+	Tool               string `json:"tool"`
+	RecoveryTargetTime string `json:"recoveryTargetTime,omitempty"`
+	StatefulSet        string `json:"statefulSet,omitempty"`
+	// Timeout bounds the wait for the StatefulSet, e.g. "2h".
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type Operator struct {
+	Cluster            string `json:"cluster"`
+	TargetCluster      string `json:"targetCluster,omitempty"`
+	RecoveryTargetTime string `json:"recoveryTargetTime,omitempty"`
+	RepointService     bool   `json:"repointService,omitempty"`
+	// Timeout bounds the wait for the restored cluster, e.g. "2h".
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Verification groups the checks run after the restore. Paths are files like
+// the ones given to --verify-sql and --validation-manifest.
+type Verification struct {
+	SQL       string   `json:"sql,omitempty"`
+	Validate  bool     `json:"validate,omitempty"`
+	Manifest  string   `json:"manifest,omitempty"`
+	Tolerance *float64 `json:"tolerance,omitempty"`
+}
+
+// Mode returns the restore mode selected by the spec.
+func (s Spec) Mode() string {
+	switch {
+	case s.Physical != nil:
+		return "physical"
+	case s.Operator != nil:
+		return "operator"
+	}
+	return ""
+}
+
+// Load reads and validates a YAML (or JSON) plan file. Relative paths in the
+// plan are resolved from the directory of the file.
+func Load(path string) (*RestorePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore plan: %w", err)
+	}
+
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid restore plan %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, file := range []*string{&p.Spec.MaskingRules, &p.Spec.Verification.SQL, &p.Spec.Verification.Manifest} {
+		if *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(dir, *file)
+		}
+	}
+	return p, nil
+}
+
+// Parse decodes a plan, rejecting unknown fields, and validates it.
+func Parse(data []byte) (*RestorePlan, error) {
+	var p RestorePlan
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, err
+	}
+	if p.APIVersion != APIVersion || p.Kind != Kind {
+		return nil, fmt.Errorf("expected apiVersion %s and kind %s, got %q and %q", APIVersion, Kind, p.APIVersion, p.Kind)
+	}
+	if p.Metadata.Name == "" {
+		return nil, fmt.Errorf("metadata.name is required")
+	}
+	if err := p.Spec.validate(); err != nil {
+		return nil, fmt.Errorf("spec.%w", err)
+	}
+	return &p, nil
+}
+
+func (s Spec) validate() error {
+	if s.Engine == "" {
+		return fmt.Errorf("engine is required")
+	}
+
+	if s.Backup.Name == "" && s.Backup.Selector == "" && s.Backup.Before == "" {
+		return fmt.Errorf("backup: one of name, selector or before is required")
+	}
+	if s.Backup.Name != "" && (s.Backup.Selector != "" || s.Backup.Before != "") {
+		return fmt.Errorf("backup: name names an exact backup and cannot be combined with selector or before")
+	}
+
+	if s.Target.ServiceName == "" && s.Target.CHI == "" {
+		return fmt.Errorf("target: serviceName or chi is required")
+	}
+	if s.Target.CHICluster != "" && s.Target.CHI == "" {
+		return fmt.Errorf("target: chiCluster requires chi")
+	}
+
+	if s.Physical != nil && s.Operator != nil {
+		return fmt.Errorf("physical and operator cannot both be set")
+	}
+	if s.Mode() == "" && len(s.Target.Databases) == 0 {
+		return fmt.Errorf("target.databases is required by logical restores")
+	}
+	if s.Mode() != "" && len(s.Target.Databases) > 0 {
+		return fmt.Errorf("target.databases cannot be set, %s restores bring back the whole server", s.Mode())
+	}
+	if s.Physical != nil && !slices.Contains([]string{"wal-g", "pgbackrest"}, s.Physical.Tool) {
+		return fmt.Errorf("physical.tool must be wal-g or pgbackrest, got %q", s.Physical.Tool)
+	}
+	if s.Operator != nil && s.Operator.Cluster == "" {
+		return fmt.Errorf("operator.cluster is required")
+	}
+	if s.Physical != nil && s.Physical.Timeout != "" {
+		if d, err := time.ParseDuration(s.Physical.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("physical.timeout must be a positive duration such as 2h, got %q", s.Physical.Timeout)
+		}
+	}
+	if s.Operator != nil && s.Operator.Timeout != "" {
+		if d, err := time.ParseDuration(s.Operator.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("operator.timeout must be a positive duration such as 2h, got %q", s.Operator.Timeout)
+		}
+	}
+	if s.Parallelism < 0 {
+		return fmt.Errorf("parallelism must be at least 1, got %d", s.Parallelism)
+	}
+
+	envs := map[string]bool{}
+	for i, ref := range s.SecretRefs {
+		if ref.Env == "" || ref.Secret == "" || ref.Key == "" {
+			return fmt.Errorf("secretRefs[%d]: env, secret and key are required", i)
+		}
+		if envs[ref.Env] {
+			return fmt.Errorf("secretRefs[%d]: %s is provided twice", i, ref.Env)
+		}
+		envs[ref.Env] = true
+	}
+	for i, from := range s.SecretEnvFrom {
+		if from.Secret == "" {
+			return fmt.Errorf("secretEnvFrom[%d]: secret is required", i)
+		}
+		if strings.ContainsAny(from.Secret+from.Prefix, ",:") {
+			return fmt.Errorf("secretEnvFrom[%d]: secret and prefix cannot contain ',' or ':'", i)
+		}
+		for env, key := range from.Rename {
+			if env == "" || key == "" || strings.ContainsAny(env+key, ",=") {
+				return fmt.Errorf("secretEnvFrom[%d]: invalid rename %q: %q", i, env, key)
+			}
+		}
+	}
+
+	if err := validateHooks("preRestore", s.Hooks.PreRestore); err != nil {
+		return err
+	}
+	if err := validateHooks("postRestore", s.Hooks.PostRestore); err != nil {
+		return err
+	}
+
+	if s.Verification.Tolerance != nil && *s.Verification.Tolerance < 0 {
+		return fmt.Errorf("verification.tolerance must be a fraction such as 0.05, got %v", *s.Verification.Tolerance)
+	}
+	return nil
+}
+
+func validateHooks(field string, hooks []engine.Hook) error {
+	names := map[string]bool{}
+	for i, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("hooks.%s[%d]: %w", field, i, err)
+		}
+		if names[h.Name] {
+			return fmt.Errorf("hooks.%s[%d]: hook %q is defined twice", field, i, h.Name)
+		}
+		names[h.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPlan = `
+apiVersion: db-restore.wiremind.io/v1alpha1
+kind: RestorePlan
+metadata:
+  name: analytics-incident
+spec:
+  engine: clickhouse
+  backup:
+    selector: daily-*
+    before: "2025-06-16"
+  target:
+    context: staging
+    namespace: analytics
+    chi: analytics
+    databases: [shop, events]
+  parallelism: 2
+  secretRefs:
+    - env: AWS_ACCESS_KEY_ID
+      secret: backup-bucket
+      key: access-key
+  jobOverrides:
+    serviceAccountName: db-restore
+    nodeSelector:
+      pool: restore
+  hooks:
+    preRestore:
+      - name: pause-consumers
+        image: bitnami/kubectl
+        script: kubectl scale deploy/consumer --replicas 0
+  maskingRules: masking.yaml
+  verification:
+    sql: /etc/runbooks/smoke.sql
+    validate: true
+    tolerance: 0.1
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "restore.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPlan), 0o600))
+
+	p, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "analytics-incident", p.Metadata.Name)
+	assert.Equal(t, Backup{Selector: "daily-*", Before: "2025-06-16"}, p.Spec.Backup)
+	assert.Equal(t, []string{"shop", "events"}, p.Spec.Target.Databases)
+	assert.Equal(t, "db-restore", p.Spec.JobOverrides.ServiceAccountName)
+	require.Len(t, p.Spec.Hooks.PreRestore, 1)
+	assert.Equal(t, "", p.Spec.Mode())
+
+	assert.Equal(t, filepath.Join(dir, "masking.yaml"), p.Spec.MaskingRules, "relative to the plan")
+	assert.Equal(t, "/etc/runbooks/smoke.sql", p.Spec.Verification.SQL)
+	assert.Equal(t, 0.1, *p.Spec.Verification.Tolerance)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		from, to string
+		err      string
+	}{
+		"unknown field":    {"parallelism: 2", "paralelism: 2", `unknown field "paralelism"`},
+		"wrong version":    {"v1alpha1", "v2", "expected apiVersion"},
+		"exact and glob":   {"selector: daily-*", "name: daily-1\n    selector: daily-*", "spec.backup: name names an exact backup"},
+		"no databases":     {"databases: [shop, events]", "databases: []", "spec.target.databases is required"},
+		"secret key":       {"key: access-key", "key: ''", "spec.secretRefs[0]: env, secret and key are required"},
+		"hook name":        {"name: pause-consumers", "name: Pause", "spec.hooks.preRestore[0]: invalid hook name"},
+		"physical and dbs": {"parallelism: 2", "physical:\n    tool: wal-g", "target.databases cannot be set, physical restores"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Contains(t, testPlan, tc.from)
+			_, err := Parse([]byte(strings.Replace(testPlan, tc.from, tc.to, 1)))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// Package verify reads smoke-test queries with their expected results and
+// checks what the restored database returns for them.
+package verify
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Check is one query and the result it must return.
+type Check struct {
+	Name  string
+	Query string
+	// Op compares the first column of the first row with Value.
+	Op    string
+	Value string
+}
+
+func (c Check) Expectation() string {
+	return c.Op + " " + c.Value
+}
+
+// operators, longest first so ">=" is not read as ">".
+var operators = []string{">=", "<=", "!=", "<>", "==", "=", ">", "<"}
+
+// Load reads a SQL file where each query is preceded by an expectation comment:
+//
+//	-- name: events were loaded yesterday
+//	-- expect: > 0
+//	SELECT count() FROM events WHERE day = yesterday();
+//
+// A query ends with a line ending in ';'. The name is optional.
+func Load(path string) ([]Check, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read verification queries: %w", err)
+	}
+	defer f.Close()
+
+	checks, err := Parse(bufio.NewScanner(f))
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification file %s: %w", path, err)
+	}
+	return checks, nil
+}
+
+func Parse(scanner *bufio.Scanner) ([]Check, error) {
+	checks := []Check{}
+	var current Check
+	var query []string
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "--"):
+			comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+			if name, ok := strings.CutPrefix(comment, "name:"); ok {
+				current.Name = strings.TrimSpace(name)
+			} else if expect, ok := strings.CutPrefix(comment, "expect:"); ok {
+				op, value, err := parseExpectation(strings.TrimSpace(expect))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				current.Op, current.Value = op, value
+			}
+			continue
+		}
+
+		query = append(query, line)
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+
+		current.Query = strings.TrimSuffix(strings.Join(query, "\n"), ";")
+		if current.Op == "" {
+			return nil, fmt.Errorf("line %d: query has no '-- expect:' comment", lineNumber)
+		}
+		if current.Name == "" {
+			current.Name = fmt.Sprintf("check %d", len(checks)+1)
+		}
+		checks = append(checks, current)
+		current, query = Check{}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(query) > 0 {
+		return nil, fmt.Errorf("last query does not end with ';'")
+	}
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no queries")
+	}
+	return checks, nil
+}
+
+// parseExpectation reads "> 0", "= ok" or a bare value, meaning equality.
+func parseExpectation(expect string) (string, string, error) {
+	if expect == "" {
+		return "", "", fmt.Errorf("empty expectation")
+	}
+	for _, op := range operators {
+		if value, ok := strings.CutPrefix(expect, op); ok {
+			if op == "==" {
+				op = "="
+			}
+			if op == "<>" {
+				op = "!="
+			}
+			return op, strings.TrimSpace(value), nil
+		}
+	}
+	return "=", expect, nil
+}
+
+// Evaluate tells whether the result satisfies the check. Values that both parse
+// as numbers are compared as numbers, others as strings.
+func (c Check) Evaluate(result string) bool {
+	result = strings.TrimSpace(result)
+
+	got, errGot := strconv.ParseFloat(result, 64)
+	want, errWant := strconv.ParseFloat(c.Value, 64)
+	if errGot == nil && errWant == nil {
+		switch c.Op {
+		case "=":
+			return got == want
+		case "!=":
+			return got != want
+		case ">":
+			return got > want
+		case ">=":
+			return got >= want
+		case "<":
+			return got < want
+		case "<=":
+			return got <= want
+		}
+	}
+
+	switch c.Op {
+	case "=":
+		return result == c.Value
+	case "!=":
+		return result != c.Value
+	case ">":
+		return result > c.Value
+	case ">=":
+		return result >= c.Value
+	case "<":
+		return result < c.Value
+	case "<=":
+		return result <= c.Value
+	}
+	return false
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Check  Check
+	Output string // first column of the first row, or the error
+	Err    bool   // the query itself failed
+	Passed bool
+}
+
+// FailedError is returned when at least one check did not pass.
+type FailedError struct {
+	Failed int
+	Total  int
+}
+
+func (e *FailedError) Error() string {
+	return fmt.Sprintf("%d of %d verification check(s) failed", e.Failed, e.Total)
+}
+
+// IsFailed reports whether err is, or wraps, a failed verification.
+func IsFailed(err error) bool {
+	var failed *FailedError
+	return errors.As(err, &failed)
+}
+
+// Marker separates the outputs of the queries in a verification Job's logs.
+const Marker = "@@db-restore-verify"
+
+// Script returns the shell running every check with run, a command reading the
+// query on stdin and printing the result in a tab-separated, unquoted format.
+// Each check runs even when an earlier one failed.
+func Script(checks []Check, run string) string {
+	var b strings.Builder
+	for i, c := range checks {
+		fmt.Fprintf(&b, "echo '%s %d'\n", Marker, i)
+		fmt.Fprintf(&b, "%s <<'DB_RESTORE_VERIFY_SQL' 2>&1 || echo '%s error'\n%s\nDB_RESTORE_VERIFY_SQL\n", run, Marker, c.Query)
+	}
+	return b.String()
+}
+
+// ParseOutput matches the Job logs produced by Script to the checks.
+func ParseOutput(checks []Check, output string) []Result {
+	outputs := make([][]string, len(checks))
+	failed := make([]bool, len(checks))
+	current := -1
+
+	for _, line := range strings.Split(output, "\n") {
+		if rest, ok := strings.CutPrefix(line, Marker+" "); ok {
+			if rest == "error" {
+				if current >= 0 {
+					failed[current] = true
+				}
+			} else if i, err := strconv.Atoi(rest); err == nil && i < len(checks) {
+				current = i
+			}
+			continue
+		}
+		if current >= 0 && strings.TrimSpace(line) != "" {
+			outputs[current] = append(outputs[current], line)
+		}
+	}
+
+	results := make([]Result, len(checks))
+	for i, c := range checks {
+		r := Result{Check: c}
+		if failed[i] {
+			r.Err = true
+			r.Output = strings.TrimSpace(strings.Join(outputs[i], " "))
+		} else if len(outputs[i]) > 0 {
+			// First column of the first row.
+			r.Output, _, _ = strings.Cut(outputs[i][0], "\t")
+			r.Passed = c.Evaluate(r.Output)
+		}
+		results[i] = r
+	}
+	return results
+}
+
+// Report renders the results as a table and returns a *FailedError when any failed.
+func Report(results []Result) (string, error) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CHECK\tEXPECTED\tRESULT\tSTATUS")
+
+	failed := 0
+	for _, r := range results {
+		status := "✅ pass"
+		if !r.Passed {
+			status = "❌ fail"
+			failed++
+		}
+		output := r.Output
+		switch {
+		case r.Err:
+			output = "error: " + output
+		case output == "":
+			output = "(no rows)"
+		}
+		if runes := []rune(output); len(runes) > 60 {
+			output = string(runes[:57]) + "..."
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Check.Name, r.Check.Expectation(), output, status)
+	}
+	_ = w.Flush()
+
+	if failed > 0 {
+		return b.String(), &FailedError{Failed: failed, Total: len(results)}
+	}
+	return b.String(), nil
+}
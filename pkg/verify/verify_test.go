@@ -0,0 +1,94 @@
+package verify
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChecks = `
+-- name: events loaded yesterday
+-- expect: > 0
+SELECT count()
+FROM events
+WHERE day = yesterday();
+
+-- expect: ok
+SELECT 'ok';
+
+-- name: no orphan orders
+-- expect: == 0
+SELECT count() FROM orders WHERE user_id NOT IN (SELECT id FROM users);
+`
+
+func parse(t *testing.T, data string) []Check {
+	checks, err := Parse(bufio.NewScanner(strings.NewReader(data)))
+	require.NoError(t, err)
+	return checks
+}
+
+func TestParse(t *testing.T) {
+	checks := parse(t, testChecks)
+
+	require.Len(t, checks, 3)
+	assert.Equal(t, Check{Name: "events loaded yesterday", Query: "SELECT count()\nFROM events\nWHERE day = yesterday()", Op: ">", Value: "0"}, checks[0])
+	assert.Equal(t, Check{Name: "check 2", Query: "SELECT 'ok'", Op: "=", Value: "ok"}, checks[1])
+	assert.Equal(t, "= 0", checks[2].Expectation())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"no expectation":    "SELECT 1;",
+		"empty expectation": "-- expect:\nSELECT 1;",
+		"unterminated":      "-- expect: 1\nSELECT 1",
+		"empty":             "-- just a comment",
+	} {
+		_, err := Parse(bufio.NewScanner(strings.NewReader(data)))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	assert.True(t, Check{Op: ">", Value: "0"}.Evaluate("12"))
+	assert.False(t, Check{Op: ">", Value: "0"}.Evaluate("0"))
+	assert.True(t, Check{Op: "=", Value: "1"}.Evaluate("1.0"), "numbers are compared as numbers")
+	assert.True(t, Check{Op: ">=", Value: "9"}.Evaluate("10"), "not as strings")
+	assert.True(t, Check{Op: "!=", Value: "failed"}.Evaluate("ok"))
+	assert.False(t, Check{Op: "=", Value: "ok"}.Evaluate("ko"))
+}
+
+func TestScriptAndParseOutput(t *testing.T) {
+	checks := parse(t, testChecks)
+
+	script := Script(checks, "clickhouse-client --format TSVRaw")
+	assert.Contains(t, script, "echo '@@db-restore-verify 1'\nclickhouse-client --format TSVRaw <<'DB_RESTORE_VERIFY_SQL' 2>&1 || echo '@@db-restore-verify error'\nSELECT 'ok'\nDB_RESTORE_VERIFY_SQL\n")
+
+	results := ParseOutput(checks, `@@db-restore-verify 0
+1234
+@@db-restore-verify 1
+
+@@db-restore-verify 2
+Code: 60. DB::Exception: Unknown table expression identifier 'orders'.
+@@db-restore-verify error
+`)
+
+	assert.Equal(t, Result{Check: checks[0], Output: "1234", Passed: true}, results[0])
+	assert.Equal(t, Result{Check: checks[1]}, results[1])
+	assert.True(t, results[2].Err)
+	assert.Contains(t, results[2].Output, "Unknown table")
+
+	table, err := Report(results)
+	assert.True(t, IsFailed(err))
+	assert.EqualError(t, err, "2 of 3 verification check(s) failed")
+	assert.Contains(t, table, "events loaded yesterday   > 0")
+	assert.Contains(t, table, "(no rows)")
+	assert.Contains(t, table, "error: Code: 60.")
+}
+
+func TestReport_AllPassed(t *testing.T) {
+	_, err := Report([]Result{{Check: Check{Name: "ok", Op: "=", Value: "1"}, Output: "1", Passed: true}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,186 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+func newDynamicClient(configFlags *genericclioptions.ConfigFlags) (dynamic.Interface, error) {
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes REST config: %w", err)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+
+	return client, nil
+}
+
+// HasResource tells whether the API server serves the resource, e.g. whether an
+// operator's CRD is installed.
+func HasResource(configFlags *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource) (bool, error) {
+	discoveryClient, err := configFlags.ToDiscoveryClient()
+	if err != nil {
+		return false, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	return HasResourceWithClient(discoveryClient, gvr)
+}
+
+func HasResourceWithClient(discoveryClient discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover %s: %w", gvr.GroupVersion(), err)
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func GetResource(configFlags *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	client, err := newDynamicClient(configFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", gvr.Resource, name, err)
+	}
+	return obj, nil
+}
+
+func CreateResource(configFlags *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	client, err := newDynamicClient(configFlags)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.Resource(gvr).Namespace(obj.GetNamespace()).Create(context.TODO(), obj, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create %s %s: %w", gvr.Resource, obj.GetName(), err)
+	}
+
+	logger.Global.Info("✅ Created %s %s in namespace %s", obj.GetKind(), obj.GetName(), obj.GetNamespace())
+	return nil
+}
+
+// WaitForResource polls the object until ready reports true, or returns the error
+// ready reports. The status string returned by ready is shown while waiting. It
+// gives up once timeout has passed.
+func WaitForResource(configFlags *genericclioptions.ConfigFlags, gvr schema.GroupVersionResource, namespace, name string, timeout time.Duration, ready func(*unstructured.Unstructured) (bool, string, error)) error {
+	client, err := newDynamicClient(configFlags)
+	if err != nil {
+		return err
+	}
+
+	return WaitForResourceWithClient(client, gvr, namespace, name, timeout, ready)
+}
+
+func WaitForResourceWithClient(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, timeout time.Duration, ready func(*unstructured.Unstructured) (bool, string, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		obj, err := client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s status: %w", gvr.Resource, name, err)
+		}
+
+		ok, status, err := ready(obj)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		if status == "" {
+			status = "no status yet"
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s %s not ready after %s (%s)", obj.GetKind(), name, timeout, status)
+		}
+		logger.Global.Info("⏳ Waiting for %s %s to become ready (%s)...", obj.GetKind(), name, status)
+		time.Sleep(pollInterval)
+	}
+}
+
+// FailedJob returns the name of a Job matching the label selector that has
+// failed for good, "" when there is none.
+func FailedJob(configFlags *genericclioptions.ConfigFlags, namespace string, selector map[string]string) (string, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return "", err
+	}
+
+	return FailedJobWithClient(clientset, namespace, selector)
+}
+
+func FailedJobWithClient(clientset kubernetes.Interface, namespace string, selector map[string]string) (string, error) {
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list Jobs: %w", err)
+	}
+
+	for _, job := range jobs.Items {
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return job.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// SetServiceSelector points an existing Service at other pods.
+func SetServiceSelector(configFlags *genericclioptions.ConfigFlags, namespace, name string, selector map[string]string) error {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return err
+	}
+
+	return SetServiceSelectorWithClient(clientset, namespace, name, selector)
+}
+
+func SetServiceSelectorWithClient(clientset kubernetes.Interface, namespace, name string, selector map[string]string) error {
+	// A merge patch would keep the old selector keys, so replace the whole map.
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "replace", "path": "/spec/selector", "value": selector},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build Service patch: %w", err)
+	}
+
+	if _, err := clientset.CoreV1().Services(namespace).Patch(context.TODO(), name, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to repoint Service %s: %w", name, err)
+	}
+
+	logger.Global.Info("🔀 Service %s now selects %v", name, selector)
+	return nil
+}
@@ -0,0 +1,104 @@
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var clustersGVR = schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}
+
+func TestHasResourceWithClient(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+	discoveryClient := client.Discovery().(*fakediscovery.FakeDiscovery)
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "postgresql.cnpg.io/v1",
+			APIResources: []metav1.APIResource{{Name: "clusters"}, {Name: "backups"}},
+		},
+	}
+
+	served, err := HasResourceWithClient(discoveryClient, clustersGVR)
+	require.NoError(t, err)
+	assert.True(t, served)
+
+	served, err = HasResourceWithClient(discoveryClient, schema.GroupVersionResource{Group: "acid.zalan.do", Version: "v1", Resource: "postgresqls"})
+	require.NoError(t, err)
+	assert.False(t, served)
+}
+
+func TestWaitForResourceWithClient(t *testing.T) {
+	cluster := &unstructured.Unstructured{}
+	cluster.SetAPIVersion("postgresql.cnpg.io/v1")
+	cluster.SetKind("Cluster")
+	cluster.SetName("app-db")
+	cluster.SetNamespace("backend")
+	require.NoError(t, unstructured.SetNestedField(cluster.Object, "Cluster in healthy state", "status", "phase"))
+
+	client := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{clustersGVR: "ClusterList"}, cluster)
+
+	err := WaitForResourceWithClient(client, clustersGVR, "backend", "app-db", time.Minute, func(obj *unstructured.Unstructured) (bool, string, error) {
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return phase == "Cluster in healthy state", phase, nil
+	})
+	assert.NoError(t, err)
+
+	err = WaitForResourceWithClient(client, clustersGVR, "backend", "missing", time.Minute, func(*unstructured.Unstructured) (bool, string, error) {
+		return true, "", nil
+	})
+	assert.Error(t, err)
+
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = 3 * time.Second }()
+	err = WaitForResourceWithClient(client, clustersGVR, "backend", "app-db", 30*time.Millisecond, func(*unstructured.Unstructured) (bool, string, error) {
+		return false, "Setting up primary", nil
+	})
+	assert.EqualError(t, err, "Cluster app-db not ready after 30ms (Setting up primary)")
+}
+
+func TestFailedJobWithClient(t *testing.T) {
+	recovery := map[string]string{"cnpg.io/cluster": "app-db", "cnpg.io/jobRole": "full-recovery"}
+	client := k8sfake.NewSimpleClientset(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-db-1-full-recovery", Namespace: "backend", Labels: recovery},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+		}},
+	})
+
+	name, err := FailedJobWithClient(client, "backend", recovery)
+	require.NoError(t, err)
+	assert.Equal(t, "app-db-1-full-recovery", name)
+
+	name, err = FailedJobWithClient(client, "backend", map[string]string{"cnpg.io/cluster": "other"})
+	require.NoError(t, err)
+	assert.Empty(t, name)
+}
+
+func TestSetServiceSelectorWithClient(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-db", Namespace: "backend"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"cnpg.io/cluster": "app-db", "role": "primary"},
+		},
+	})
+
+	err := SetServiceSelectorWithClient(client, "backend", "app-db", map[string]string{"cnpg.io/cluster": "app-db-restored"})
+	require.NoError(t, err)
+
+	svc, err := client.CoreV1().Services("backend").Get(context.TODO(), "app-db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cnpg.io/cluster": "app-db-restored"}, svc.Spec.Selector)
+}
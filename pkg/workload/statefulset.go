@@ -0,0 +1,135 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/wiremind/kubectl-db-restore/pkg/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pollInterval is how often the StatefulSet status is checked while scaling.
+var pollInterval = 3 * time.Second
+
+func newClientset(configFlags *genericclioptions.ConfigFlags) (kubernetes.Interface, error) {
+	restConfig, err := configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes REST config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	return clientset, nil
+}
+
+func GetStatefulSet(configFlags *genericclioptions.ConfigFlags, namespace, name string) (*appsv1.StatefulSet, error) {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	sts, err := clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get StatefulSet %s: %w", name, err)
+	}
+	return sts, nil
+}
+
+func ScaleStatefulSet(configFlags *genericclioptions.ConfigFlags, namespace, name string, replicas int32, timeout time.Duration) error {
+	clientset, err := newClientset(configFlags)
+	if err != nil {
+		return err
+	}
+
+	return ScaleStatefulSetWithClient(clientset, namespace, name, replicas, timeout)
+}
+
+// ScaleStatefulSetWithClient sets the replica count and waits until the StatefulSet
+// has exactly that many pods, all ready. Scaling to 0 therefore returns once every
+// pod is gone and its volumes can be mounted elsewhere. The wait gives up once
+// timeout has passed, or as soon as a pod is stuck failing, e.g. in CrashLoopBackOff.
+func ScaleStatefulSetWithClient(clientset kubernetes.Interface, namespace, name string, replicas int32, timeout time.Duration) error {
+	stsClient := clientset.AppsV1().StatefulSets(namespace)
+
+	sts, err := stsClient.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get StatefulSet %s: %w", name, err)
+	}
+
+	sts.Spec.Replicas = &replicas
+	if _, err := stsClient.Update(context.TODO(), sts, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to scale StatefulSet %s to %d: %w", name, replicas, err)
+	}
+
+	logger.Global.Info("↕️ Scaling StatefulSet %s to %d replica(s)", name, replicas)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		sts, err := stsClient.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get StatefulSet %s status: %w", name, err)
+		}
+
+		if sts.Status.ObservedGeneration >= sts.Generation &&
+			sts.Status.Replicas == replicas && sts.Status.ReadyReplicas == replicas {
+			return nil
+		}
+
+		if replicas > 0 {
+			failure, err := podFailure(clientset, sts)
+			if err != nil {
+				return err
+			}
+			if failure != "" {
+				return fmt.Errorf("StatefulSet %s will not become ready: %s", name, failure)
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("StatefulSet %s not scaled to %d after %s (%d ready, %d running)", name, replicas, timeout, sts.Status.ReadyReplicas, sts.Status.Replicas)
+		}
+
+		logger.Global.Info("⏳ Waiting for StatefulSet %s: %d/%d ready, %d running...", name, sts.Status.ReadyReplicas, replicas, sts.Status.Replicas)
+		time.Sleep(pollInterval)
+	}
+}
+
+// stuckReasons are the container waiting reasons a pod does not get out of on
+// its own.
+var stuckReasons = []string{"CrashLoopBackOff", "ImagePullBackOff", "ErrImagePull", "InvalidImageName", "CreateContainerConfigError"}
+
+// podFailure describes the first pod of the StatefulSet that is stuck failing,
+// "" when there is none.
+func podFailure(clientset kubernetes.Interface, sts *appsv1.StatefulSet) (string, error) {
+	if sts.Spec.Selector == nil {
+		return "", nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector of StatefulSet %s: %w", sts.Name, err)
+	}
+	pods, err := clientset.CoreV1().Pods(sts.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the pods of StatefulSet %s: %w", sts.Name, err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodFailed {
+			return fmt.Sprintf("pod %s failed", pod.Name), nil
+		}
+		for _, c := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if c.State.Waiting != nil && slices.Contains(stuckReasons, c.State.Waiting.Reason) {
+				return fmt.Sprintf("container %s of pod %s is in %s, see kubectl logs %s -c %s --previous", c.Name, pod.Name, c.State.Waiting.Reason, pod.Name, c.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
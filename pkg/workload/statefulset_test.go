@@ -0,0 +1,90 @@
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestScaleStatefulSetWithClient(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = 3 * time.Second }()
+
+	client := k8sfake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(2)},
+		Status:     appsv1.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2},
+	})
+
+	// Simulate the controller removing the pods once the scale down is requested.
+	go func() {
+		for {
+			sts, err := client.AppsV1().StatefulSets("default").Get(context.TODO(), "postgres", metav1.GetOptions{})
+			if err == nil && *sts.Spec.Replicas == 0 {
+				sts.Status = appsv1.StatefulSetStatus{}
+				if _, err := client.AppsV1().StatefulSets("default").UpdateStatus(context.TODO(), sts, metav1.UpdateOptions{}); err != nil {
+					t.Errorf("failed to update StatefulSet status: %v", err)
+				}
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	err := ScaleStatefulSetWithClient(client, "default", "postgres", 0, time.Minute)
+	require.NoError(t, err)
+
+	sts, err := client.AppsV1().StatefulSets("default").Get(context.TODO(), "postgres", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), *sts.Spec.Replicas)
+}
+
+func TestScaleStatefulSetWithClient_NotFound(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+
+	err := ScaleStatefulSetWithClient(client, "default", "missing", 0, time.Minute)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get StatefulSet missing")
+}
+
+func TestScaleStatefulSetWithClient_StuckPod(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = 3 * time.Second }()
+
+	selector := map[string]string{"app": "postgres"}
+	client := k8sfake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(0), Selector: &metav1.LabelSelector{MatchLabels: selector}},
+	}, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres-0", Namespace: "default", Labels: selector},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  "postgres",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}},
+	})
+
+	err := ScaleStatefulSetWithClient(client, "default", "postgres", 1, time.Minute)
+	assert.EqualError(t, err, "StatefulSet postgres will not become ready: container postgres of pod postgres-0 is in CrashLoopBackOff, see kubectl logs postgres-0 -c postgres --previous")
+}
+
+func TestScaleStatefulSetWithClient_Timeout(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = 3 * time.Second }()
+
+	client := k8sfake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(0)},
+	})
+
+	err := ScaleStatefulSetWithClient(client, "default", "postgres", 1, 30*time.Millisecond)
+	assert.EqualError(t, err, "StatefulSet postgres not scaled to 1 after 30ms (0 ready, 0 running)")
+}
@@ -0,0 +1,15 @@
+#!/bin/bash
+
+# PostgreSQL credentials
+export POSTGRES_USER="mock_user"
+export POSTGRES_PASSWORD="mock_password"
+
+# S3 location of the pg_dump archives
+export POSTGRES_AWS_S3_BACKUP_URI="s3://mock-bucket/postgres"
+
+# Local MinIO instead of AWS S3
+export AWS_ENDPOINT_URL="http://localhost:9000"
+
+# AWS credentials
+export AWS_ACCESS_KEY_ID="MOCKAWSACCESSKEY123456"
+export AWS_SECRET_ACCESS_KEY="MOCKAWSSECRETKEY987654321"